@@ -19,8 +19,20 @@ func (m *photoConfig) baseChat() *tg.BaseChat {
 	return &m.BaseChat
 }
 
+type chatActionConfig struct{ tg.ChatActionConfig }
+
+func (m *chatActionConfig) baseChat() *tg.BaseChat {
+	return &m.BaseChat
+}
+
 type documentConfig struct{ tg.DocumentConfig }
 
 func (m *documentConfig) baseChat() *tg.BaseChat {
 	return &m.BaseChat
 }
+
+type invoiceConfig struct{ tg.InvoiceConfig }
+
+func (m *invoiceConfig) baseChat() *tg.BaseChat {
+	return &m.BaseChat
+}
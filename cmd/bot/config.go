@@ -6,37 +6,70 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bcmk/siren/lib"
 )
 
 type endpoint struct {
-	ListenPath      string   `json:"listen_path"`      // the path excluding domain to listen to, the good choice is "/your-telegram-bot-token"
-	WebhookDomain   string   `json:"webhook_domain"`   // the domain listening to the webhook
-	CertificatePath string   `json:"certificate_path"` // a path to your certificate, it is used to setup a webhook and to setup this HTTP server
-	BotToken        string   `json:"bot_token"`        // your Telegram bot token
-	Translation     []string `json:"translation"`      // translation strings
+	ListenPath       string              `json:"listen_path"`       // the path excluding domain to listen to, the good choice is "/your-telegram-bot-token"
+	WebhookDomain    string              `json:"webhook_domain"`    // the domain listening to the webhook
+	CertificatePath  string              `json:"certificate_path"`  // a path to your certificate, it is used to setup a webhook and to setup this HTTP server
+	BotToken         string              `json:"bot_token"`         // your Telegram bot token
+	Translation      []string            `json:"translation"`       // translation strings
+	CommandLanguages map[string][]string `json:"command_languages"` // translation strings by language code, used to register localized command lists and chosen by the language command
+	ForceRawParse    bool                `json:"force_raw_parse"`   // send all messages as a raw text ignoring parse modes of translations
+	Canary           *canaryConfig       `json:"canary"`            // alternate translations for a fraction of new users
+	CoinPayments     *coinPaymentsConfig `json:"coin_payments"`     // CoinPayments integration overriding the shared one for this endpoint
+}
+
+type canaryConfig struct {
+	Translation []string `json:"translation"` // translation strings for canary users
+	Fraction    float64  `json:"fraction"`    // the fraction of new users served by the canary, from 0 to 1
+}
+
+type adaptiveConfig struct {
+	Cycles            int     `json:"cycles"`             // the number of recent cycles the flapping fraction is computed over
+	MinChanges        int     `json:"min_changes"`        // do not adapt if there are fewer status changes in recent cycles
+	FlappingThreshold float64 `json:"flapping_threshold"` // widen confirmation if the fraction of unconfirmed status changes exceeds this value
+	MaxFactor         int     `json:"max_factor"`         // the maximum factor confirmation seconds are multiplied by
+}
+
+type onlineVariant struct {
+	Name     string `json:"name"`      // the name passed to the online notification template as time_of_day
+	FromHour int    `json:"from_hour"` // the first hour of the range, inclusive
+	ToHour   int    `json:"to_hour"`   // the last hour of the range, exclusive, the range wraps around midnight if it is less than from_hour
 }
 
 type coinPaymentsConfig struct {
-	SubscriptionPacket string   `json:"subscription_packet"` // subscription packet, format "15/10" meaning 15 USD for 10 models
-	Currencies         []string `json:"currencies"`          // CoinPayments currencies to buy a subscription with
-	PublicKey          string   `json:"public_key"`          // CoinPayments public key
-	PrivateKey         string   `json:"private_key"`         // CoinPayments private key
-	IPNListenURL       string   `json:"ipn_listen_url"`      // CoinPayments IPN payment status notification listen URL
-	IPNSecret          string   `json:"ipn_secret"`          // CoinPayments IPN secret
+	SubscriptionPacket     string   `json:"subscription_packet"`      // subscription packet, format "15/10" meaning 15 USD for 10 models
+	SubscriptionPacketDays int      `json:"subscription_packet_days"` // a bought packet expires after this number of days, every packet adds its subscriptions until its own expiration, 0 means forever
+	Currencies             []string `json:"currencies"`               // CoinPayments currencies to buy a subscription with
+	PublicKey              string   `json:"public_key"`               // CoinPayments public key
+	PrivateKey             string   `json:"private_key"`              // CoinPayments private key
+	IPNListenURL           string   `json:"ipn_listen_url"`           // CoinPayments IPN payment status notification listen URL
+	IPNSecret              string   `json:"ipn_secret"`               // CoinPayments IPN secret
 
 	subscriptionPacketPrice       int
 	subscriptionPacketModelNumber int
 }
 
+type telegramPaymentsConfig struct {
+	ProviderToken string `json:"provider_token"` // the payment provider token given by BotFather, card payments are charged in USD for the CoinPayments subscription packet
+}
+
 type mailConfig struct {
 	Host           string `json:"host"`            // the hostname for email
 	ListenAddress  string `json:"listen_address"`  // the address to listen to incoming mail
 	Certificate    string `json:"certificate"`     // certificate path for STARTTLS
 	CertificateKey string `json:"certificate_key"` // certificate key path for STARTTLS
+	Workers        int    `json:"workers"`         // the number of workers forwarding emails, 1 by default
 }
 
 type statusConfirmationSeconds struct {
@@ -44,27 +77,33 @@ type statusConfirmationSeconds struct {
 	Online   int `json:"online"`
 	NotFound int `json:"not_found"`
 	Denied   int `json:"denied"`
+	Idle     int `json:"idle"`
+	Private  int `json:"private"`
 }
 
 type config struct {
 	ListenAddress               string                    `json:"listen_address"`                 // the address to listen to
-	Website                     string                    `json:"website"`                        // one of the following strings: "bongacams", "stripchat", "chaturbate", "livejasmin"
+	Website                     string                    `json:"website"`                        // one of the following strings: "bongacams", "stripchat", "chaturbate", "livejasmin", "camsoda", "flirt4free", "myfreecams", "cam4"
 	WebsiteLink                 string                    `json:"website_link"`                   // affiliate link to website
 	PeriodSeconds               int                       `json:"period_seconds"`                 // the period of querying models statuses
 	MaxModels                   int                       `json:"max_models"`                     // maximum models per user
 	TimeoutSeconds              int                       `json:"timeout_seconds"`                // HTTP timeout
 	AdminID                     int64                     `json:"admin_id"`                       // admin Telegram ID
 	AdminEndpoint               string                    `json:"admin_endpoint"`                 // admin endpoint
-	DBPath                      string                    `json:"db_path"`                        // path to the database
+	DBDriver                    string                    `json:"db_driver"`                      // one of the following strings: "sqlite3" (default), "postgres"
+	DBPath                      string                    `json:"db_path"`                        // path to the database, the data source name for PostgreSQL
 	BlockThreshold              int                       `json:"block_threshold"`                // do not send a message to the user after being blocked by him this number of times
 	Debug                       bool                      `json:"debug"`                          // debug mode
 	IntervalMs                  int                       `json:"interval_ms"`                    // queries interval per IP address for rate limited access
 	SourceIPAddresses           []string                  `json:"source_ip_addresses"`            // source IP addresses for rate limited access
+	ProxyURL                    string                    `json:"proxy_url"`                      // HTTP or SOCKS5 proxy for website requests, for example "socks5://127.0.0.1:1080", requests are direct if it is unreachable
 	DangerousErrorRate          string                    `json:"dangerous_error_rate"`           // dangerous error rate, warn admin if it is reached, format "1000/10000"
 	EnableCookies               bool                      `json:"enable_cookies"`                 // enable cookies, it can be useful to mitigate rate limits
 	Headers                     [][2]string               `json:"headers"`                        // HTTP headers to make queries with
+	UserAgents                  []string                  `json:"user_agents"`                    // user agents picked round-robin for every query to the website, they override the one in headers
 	StatPassword                string                    `json:"stat_password"`                  // password for statistics
 	ErrorReportingPeriodMinutes int                       `json:"error_reporting_period_minutes"` // the period of the error reports
+	AlertsChatID                int64                     `json:"alerts_chat_id"`                 // the chat or channel receiving error rate alerts via the admin endpoint, admin chat by default
 	Endpoints                   map[string]endpoint       `json:"endpoints"`                      // the endpoints by simple name, used for the support of the bots in different languages accessing the same database
 	HeavyUserRemainder          int                       `json:"heavy_user_remainder"`           // the maximum remainder of models to treat an user as heavy
 	CoinPayments                *coinPaymentsConfig       `json:"coin_payments"`                  // CoinPayments integration
@@ -75,14 +114,62 @@ type config struct {
 	StatusConfirmationSeconds   statusConfirmationSeconds `json:"status_confirmation_seconds"`    // a status is confirmed only if it lasts for at least this number of seconds
 	OfflineNotifications        bool                      `json:"offline_notifications"`          // enable offline notifications
 	SQLPrelude                  []string                  `json:"sql_prelude"`                    // run these SQL commands before any other
+	SQLBusyTimeoutMs            int                       `json:"sql_busy_timeout_ms"`            // wait for this number of milliseconds if the database is locked
+	SQLWAL                      bool                      `json:"sql_wal"`                        // enable WAL journal mode
 	EnableWeek                  bool                      `json:"enable_week"`                    // enable week command
-	AffiliateLink               string                    `json:"affiliate_link"`                 // affiliate link template
+	AffiliateLink               string                    `json:"affiliate_link"`                 // affiliate link template, model_name function displays a model ID
+	ModelNameDisplay            string                    `json:"model_name_display"`             // one of the following strings: "" keeps canonical model IDs, "original" uses the site spelling, "capitalized"
 	SpecificConfig              map[string]string         `json:"specific_config"`                // the config for specific website
 	TelegramTimeoutSeconds      int                       `json:"telegram_timeout_seconds"`       // the timeout for Telegram queries
 	MaxSubscriptionsForPics     int                       `json:"max_subscriptions_for_pics"`     // the maximum amount of subscriptions for pics in a group chat
+	FallbackImageURL            string                    `json:"fallback_image_url"`             // image URL template with {{ .model }} for special models, used if the primary image cannot be downloaded
+	FreshImageURL               string                    `json:"fresh_image_url"`                // image URL template with {{ .model }} and {{ .timestamp }} downloaded right before notifications of special models, the image from the last poll is used if it fails
+	ReceiptDocuments            bool                      `json:"receipt_documents"`              // also send payment receipts as text documents for record-keeping
+	ImageCacheSeconds           int                       `json:"image_cache_seconds"`            // reuse a downloaded image for this number of seconds, 0 disables the cache
+	IdleStatus                  bool                      `json:"idle_status"`                    // track idle status of online models if the website supports it
+	PrivateStatus               bool                      `json:"private_status"`                 // track private and group shows of online models if the website supports it
+	DisplayNameNotifications    bool                      `json:"display_name_notifications"`     // notify subscribers when a model changes the display name
+	MaxConcurrentImageUploads   int                       `json:"max_concurrent_image_uploads"`   // the maximum number of images uploaded to Telegram simultaneously, 0 means no limit
+	SettlingPeriodSeconds       int                       `json:"settling_period_seconds"`        // do not notify of status changes during this period after a subscription is created
+	InteractionsRetentionDays   int                       `json:"interactions_retention_days"`    // interactions older than this number of days are pruned, 0 keeps them forever
+	PruningPeriodMinutes        int                       `json:"pruning_period_minutes"`         // the period of pruning old data
+	DailyNotifications          int                       `json:"daily_notifications"`            // the default maximum number of notifications per user in 24 hours, 0 means no limit
+	MaxReminders                int                       `json:"max_reminders"`                  // the maximum number of schedule reminders per user, 0 disables reminders
+	ReminderLeadMinutes         int                       `json:"reminder_lead_minutes"`          // send a reminder this number of minutes before the most active hour of a model
+	SpreadSubscribersThreshold  int                       `json:"spread_subscribers_threshold"`   // spread online notifications of models having at least this number of subscribers, 0 disables spreading
+	SpreadWindowSeconds         int                       `json:"spread_window_seconds"`          // the window online notifications of popular models are spread across
+	MaxCheckList                int                       `json:"max_check_list"`                 // the maximum number of models checked by the check_list command, 0 disables the command
+	Timezone                    string                    `json:"timezone"`                       // the timezone to determine the time of day for notifications, UTC by default
+	OnlineVariants              []onlineVariant           `json:"online_variants"`                // online notification variants by the time of day
+	UndoRemoveMinutes           int                       `json:"undo_remove_minutes"`            // the period the last removed model can be restored in, 0 disables the undo_remove command
+	MaxStalenessSeconds         int                       `json:"max_staleness_seconds"`          // warn admin if online models cannot be queried for this number of seconds, 0 disables the check
+	OfflineOnStaleness          bool                      `json:"offline_on_staleness"`           // treat all models as offline if online models cannot be queried for max_staleness_seconds
+	BlockedProbesPerPeriod      int                       `json:"blocked_probes_per_period"`      // the number of chats blocked the bot probed silently every period to detect unblocking, 0 disables probing
+	BlockedProbeIntervalHours   int                       `json:"blocked_probe_interval_hours"`   // do not probe the same blocked chat more often than this number of hours
+	StartupConcurrency          int                       `json:"startup_concurrency"`            // the maximum number of endpoints initialized simultaneously at startup, 1 by default
+	WatchMinutes                int                       `json:"watch_minutes"`                  // the duration of the watch command, 0 disables the command
+	WatchPeriodSeconds          int                       `json:"watch_period_seconds"`           // the period of checking a watched model
+	MaxWatches                  int                       `json:"max_watches"`                    // the maximum number of models watched simultaneously
+	DeliveryWebhookURL          string                    `json:"delivery_webhook_url"`           // POST every message delivery result as JSON to this URL, empty disables the webhook
+	AdaptiveConfirmation        *adaptiveConfig           `json:"adaptive_confirmation"`          // widen confirmation seconds automatically if many models are flapping, null disables adaptation
+	MaxSearchResults            int                       `json:"max_search_results"`             // the maximum number of online models found by the search command, 0 disables the command
+	SearchIntervalSeconds       int                       `json:"search_interval_seconds"`        // do not allow a chat to search more often than this number of seconds
+	ImageProxyURL               string                    `json:"image_proxy_url"`                // links to model images in notifications are rewritten to this URL with the parameter u, empty disables the links
+	CheckRetries                int                       `json:"check_retries"`                  // the number of retries of a model check failed with a network error, the checks made when a user adds a model are not retried
+	RetryBackoffMs              int                       `json:"retry_backoff_ms"`               // the delay before the first retry of a model check, it doubles after every retry
+	PruneNotFoundChats          bool                      `json:"prune_not_found_chats"`          // remove subscriptions of the chats Telegram cannot find, otherwise these chats are treated as blocked
+	TelegramPayments            *telegramPaymentsConfig   `json:"telegram_payments"`              // Telegram payments by card as an alternative to CoinPayments
+	AuditWorkers                int                       `json:"audit_workers"`                  // the number of models checked simultaneously by the audit_models command, 1 by default
+	AuditMaxMinutes             int                       `json:"audit_max_minutes"`              // the audit_models command stops after this number of minutes, 60 by default
+	PacketExpiryWarningHours    int                       `json:"packet_expiry_warning_hours"`    // warn users this number of hours before a bought subscription packet expires
+	Checker                     string                    `json:"checker"`                        // "api" queries all online models at once, "polling" checks the models one by one, empty means the website default
+	NotificationWorkers         int                       `json:"notification_workers"`           // the number of goroutines building the notifications of confirmed statuses, 1 by default
+	BroadcastBatchSize          int                       `json:"broadcast_batch_size"`           // the number of chats a broadcast is queued for at once, 1000 by default
+	BroadcastProgressSeconds    int                       `json:"broadcast_progress_seconds"`     // the admin gets the progress of a broadcast this often, 60 by default
 
 	errorThreshold   int
 	errorDenominator int
+	checkerKind      lib.CheckerKind
 }
 
 var fractionRegexp = regexp.MustCompile(`^(\d+)/(\d+)$`)
@@ -108,6 +195,12 @@ func parseConfig(r io.Reader) *config {
 }
 
 func checkConfig(cfg *config) error {
+	if cfg.ProxyURL != "" {
+		proxy, err := url.Parse(cfg.ProxyURL)
+		if err != nil || proxy.Host == "" || (proxy.Scheme != "http" && proxy.Scheme != "https" && proxy.Scheme != "socks5") {
+			return errors.New("configure proxy_url as an HTTP, HTTPS or SOCKS5 URL")
+		}
+	}
 	for _, x := range cfg.SourceIPAddresses {
 		if net.ParseIP(x) == nil {
 			return fmt.Errorf("cannot parse sourece IP address %s", x)
@@ -123,6 +216,24 @@ func checkConfig(cfg *config) error {
 		if len(x.Translation) == 0 {
 			return errors.New("configure translation")
 		}
+		for lang, trs := range x.CommandLanguages {
+			if lang == "" || len(trs) == 0 {
+				return errors.New("configure command_languages")
+			}
+		}
+		if x.Canary != nil {
+			if len(x.Canary.Translation) == 0 {
+				return errors.New("configure canary/translation")
+			}
+			if x.Canary.Fraction <= 0 || x.Canary.Fraction > 1 {
+				return errors.New("configure canary/fraction in the range (0, 1]")
+			}
+		}
+	}
+	for n := range cfg.Endpoints {
+		if strings.HasSuffix(n, canarySuffix) {
+			return fmt.Errorf("endpoint names ending with %s are reserved for canaries", canarySuffix)
+		}
 	}
 	if cfg.ListenAddress == "" {
 		return errors.New("configure listen_address")
@@ -142,6 +253,9 @@ func checkConfig(cfg *config) error {
 	if cfg.AdminID == 0 {
 		return errors.New("configure admin_id")
 	}
+	if cfg.DBDriver != "" && cfg.DBDriver != driverSQLite && cfg.DBDriver != driverPostgres {
+		return errors.New("configure db_driver as sqlite3 or postgres")
+	}
 	if cfg.DBPath == "" {
 		return errors.New("configure db_path")
 	}
@@ -151,6 +265,20 @@ func checkConfig(cfg *config) error {
 	if cfg.Website == "" {
 		return errors.New("configure website")
 	}
+	site, ok := websites[cfg.Website]
+	if !ok {
+		return fmt.Errorf("unknown website %s", cfg.Website)
+	}
+	switch cfg.Checker {
+	case "":
+		cfg.checkerKind = site.checker
+	case lib.CheckerAPI.String():
+		cfg.checkerKind = lib.CheckerAPI
+	case lib.CheckerPolling.String():
+		cfg.checkerKind = lib.CheckerPolling
+	default:
+		return errors.New(`configure checker as "api" or "polling"`)
+	}
 	if cfg.WebsiteLink == "" {
 		return errors.New("configure website_link")
 	}
@@ -178,7 +306,12 @@ func checkConfig(cfg *config) error {
 		return errors.New("configure follower_bonus")
 	}
 	if cfg.AffiliateLink == "" {
-		cfg.AffiliateLink = "{{ . }}"
+		cfg.AffiliateLink = "{{ model_name . }}"
+	}
+	switch cfg.ModelNameDisplay {
+	case "", "original", "capitalized":
+	default:
+		return errors.New(`configure model_name_display as "", "original" or "capitalized"`)
 	}
 	if cfg.TelegramTimeoutSeconds == 0 {
 		return errors.New("configure telegram_timeout_seconds")
@@ -186,6 +319,136 @@ func checkConfig(cfg *config) error {
 	if cfg.MaxSubscriptionsForPics == 0 {
 		return errors.New("configure max_subscriptions_for_pics")
 	}
+	if cfg.InteractionsRetentionDays < 0 {
+		return errors.New("configure interactions_retention_days as a non-negative number")
+	}
+	if cfg.InteractionsRetentionDays > 0 && cfg.PruningPeriodMinutes == 0 {
+		return errors.New("configure pruning_period_minutes")
+	}
+	if cfg.SQLBusyTimeoutMs < 0 {
+		return errors.New("configure sql_busy_timeout_ms as a non-negative number")
+	}
+	if cfg.DailyNotifications < 0 {
+		return errors.New("configure daily_notifications as a non-negative number")
+	}
+	if cfg.SpreadSubscribersThreshold < 0 {
+		return errors.New("configure spread_subscribers_threshold as a non-negative number")
+	}
+	if cfg.SpreadSubscribersThreshold > 0 && cfg.SpreadWindowSeconds <= 0 {
+		return errors.New("configure spread_window_seconds")
+	}
+	if cfg.MaxCheckList < 0 {
+		return errors.New("configure max_check_list as a non-negative number")
+	}
+	if _, err := time.LoadLocation(cfg.Timezone); err != nil {
+		return fmt.Errorf("configure timezone, %v", err)
+	}
+	for _, v := range cfg.OnlineVariants {
+		if v.Name == "" {
+			return errors.New("configure online_variants/name")
+		}
+		if v.FromHour < 0 || v.FromHour > 23 || v.ToHour < 0 || v.ToHour > 23 {
+			return errors.New("configure online_variants hours in the range from 0 to 23")
+		}
+	}
+	if cfg.UndoRemoveMinutes < 0 {
+		return errors.New("configure undo_remove_minutes as a non-negative number")
+	}
+	if cfg.TelegramPayments != nil && cfg.TelegramPayments.ProviderToken == "" {
+		return errors.New("configure telegram_payments/provider_token")
+	}
+	if cfg.AuditWorkers < 0 {
+		return errors.New("configure audit_workers as a non-negative number")
+	}
+	if cfg.AuditWorkers == 0 {
+		cfg.AuditWorkers = 1
+	}
+	if cfg.NotificationWorkers < 0 {
+		return errors.New("configure notification_workers as a non-negative number")
+	}
+	if cfg.NotificationWorkers == 0 {
+		cfg.NotificationWorkers = 1
+	}
+	if cfg.BroadcastBatchSize < 0 {
+		return errors.New("configure broadcast_batch_size as a non-negative number")
+	}
+	if cfg.BroadcastBatchSize == 0 {
+		cfg.BroadcastBatchSize = 1000
+	}
+	if cfg.BroadcastProgressSeconds < 0 {
+		return errors.New("configure broadcast_progress_seconds as a non-negative number")
+	}
+	if cfg.BroadcastProgressSeconds == 0 {
+		cfg.BroadcastProgressSeconds = 60
+	}
+	if cfg.AuditMaxMinutes < 0 {
+		return errors.New("configure audit_max_minutes as a non-negative number")
+	}
+	if cfg.AuditMaxMinutes == 0 {
+		cfg.AuditMaxMinutes = 60
+	}
+	if cfg.PacketExpiryWarningHours < 0 {
+		return errors.New("configure packet_expiry_warning_hours as a non-negative number")
+	}
+	if cfg.ImageCacheSeconds < 0 {
+		return errors.New("configure image_cache_seconds as a non-negative number")
+	}
+	if cfg.MaxStalenessSeconds < 0 {
+		return errors.New("configure max_staleness_seconds as a non-negative number")
+	}
+	if cfg.OfflineOnStaleness && cfg.MaxStalenessSeconds == 0 {
+		return errors.New("configure max_staleness_seconds")
+	}
+	if cfg.BlockedProbesPerPeriod < 0 {
+		return errors.New("configure blocked_probes_per_period as a non-negative number")
+	}
+	if cfg.BlockedProbesPerPeriod > 0 && cfg.BlockedProbeIntervalHours <= 0 {
+		return errors.New("configure blocked_probe_interval_hours")
+	}
+	if cfg.StartupConcurrency < 0 {
+		return errors.New("configure startup_concurrency as a non-negative number")
+	}
+	if cfg.StartupConcurrency == 0 {
+		cfg.StartupConcurrency = 1
+	}
+	if cfg.MaxReminders < 0 {
+		return errors.New("configure max_reminders as a non-negative number")
+	}
+	if cfg.ReminderLeadMinutes < 0 || cfg.ReminderLeadMinutes >= 24*60 {
+		return errors.New("configure reminder_lead_minutes as a number of minutes less than a day")
+	}
+	if cfg.WatchMinutes < 0 {
+		return errors.New("configure watch_minutes as a non-negative number")
+	}
+	if cfg.WatchMinutes > 0 && cfg.WatchPeriodSeconds == 0 {
+		return errors.New("configure watch_period_seconds")
+	}
+	if cfg.WatchMinutes > 0 && cfg.MaxWatches == 0 {
+		return errors.New("configure max_watches")
+	}
+	if cfg.ImageProxyURL != "" && !strings.HasPrefix(cfg.ImageProxyURL, "http://") && !strings.HasPrefix(cfg.ImageProxyURL, "https://") {
+		return errors.New("configure image_proxy_url as an HTTP or HTTPS URL")
+	}
+	if cfg.CheckRetries < 0 || cfg.RetryBackoffMs < 0 {
+		return errors.New("configure check_retries and retry_backoff_ms as non-negative numbers")
+	}
+	if cfg.MaxSearchResults < 0 {
+		return errors.New("configure max_search_results as a non-negative number")
+	}
+	if cfg.DeliveryWebhookURL != "" && !strings.HasPrefix(cfg.DeliveryWebhookURL, "http://") && !strings.HasPrefix(cfg.DeliveryWebhookURL, "https://") {
+		return errors.New("configure delivery_webhook_url as an HTTP or HTTPS URL")
+	}
+	if x := cfg.AdaptiveConfirmation; x != nil {
+		if x.Cycles <= 0 {
+			return errors.New("configure adaptive_confirmation/cycles")
+		}
+		if x.FlappingThreshold <= 0 || x.FlappingThreshold >= 1 {
+			return errors.New("configure adaptive_confirmation/flapping_threshold in the range (0, 1)")
+		}
+		if x.MaxFactor < 2 {
+			return errors.New("configure adaptive_confirmation/max_factor of at least 2")
+		}
+	}
 
 	if m := fractionRegexp.FindStringSubmatch(cfg.DangerousErrorRate); len(m) == 3 {
 		errorThreshold, err := strconv.ParseInt(m[1], 10, 0)
@@ -208,10 +471,24 @@ func checkConfig(cfg *config) error {
 		return errors.New("configure dangerous_error_rate")
 	}
 
+	ipnListenURLs := map[string]bool{}
 	if cfg.CoinPayments != nil {
 		if err := checkCoinPaymentsConfig(cfg.CoinPayments); err != nil {
 			return err
 		}
+		ipnListenURLs[cfg.CoinPayments.IPNListenURL] = true
+	}
+	for _, x := range cfg.Endpoints {
+		if x.CoinPayments == nil {
+			continue
+		}
+		if err := checkCoinPaymentsConfig(x.CoinPayments); err != nil {
+			return err
+		}
+		if ipnListenURLs[x.CoinPayments.IPNListenURL] {
+			return errors.New("configure distinct ipn_listen_url for every CoinPayments integration")
+		}
+		ipnListenURLs[x.CoinPayments.IPNListenURL] = true
 	}
 
 	if cfg.Mail != nil {
@@ -239,6 +516,9 @@ func checkCoinPaymentsConfig(cfg *coinPaymentsConfig) error {
 	if cfg.IPNSecret == "" {
 		return errors.New("configure ipn_secret")
 	}
+	if cfg.SubscriptionPacketDays < 0 {
+		return errors.New("configure subscription_packet_days as a non-negative number")
+	}
 
 	if m := fractionRegexp.FindStringSubmatch(cfg.SubscriptionPacket); len(m) == 3 {
 		subscriptionPacketModelNumber, err := strconv.ParseInt(m[1], 10, 0)
@@ -271,5 +551,11 @@ func checkMailConfig(cfg *mailConfig) error {
 	if cfg.ListenAddress == "" {
 		return errors.New("configure listen_address")
 	}
+	if cfg.Workers < 0 {
+		return errors.New("configure workers as a non-negative number")
+	}
+	if cfg.Workers == 0 {
+		cfg.Workers = 1
+	}
 	return nil
 }
@@ -38,11 +38,12 @@ var testTranslations = lib.Translations{
 
 type testWorker struct {
 	worker
-	status lib.StatusKind
+	status   lib.StatusKind
+	checkErr error
 }
 
-func (w *testWorker) testCheckModel(*lib.Client, string, [][2]string, bool, map[string]string) lib.StatusKind {
-	return w.status
+func (w *testWorker) testCheckModel(*lib.Client, string, [][2]string, bool, map[string]string) (lib.StatusKind, error) {
+	return w.status, w.checkErr
 }
 
 func newTestWorker() *testWorker {
@@ -50,12 +51,20 @@ func newTestWorker() *testWorker {
 	checkErr(err)
 	w := &testWorker{
 		worker: worker{
-			bots:      nil,
-			db:        db,
-			cfg:       &testConfig,
-			clients:   nil,
-			tr:        map[string]*lib.Translations{"test": &testTranslations},
-			durations: map[string]queryDurationsData{},
+			bots:            nil,
+			db:              db,
+			cfg:             &testConfig,
+			clients:         nil,
+			headers:         lib.NewHeadersRotation(nil, nil),
+			tr:              map[string]*lib.Translations{"test": &testTranslations},
+			durations:       map[string]queryDurationsData{},
+			watches:         map[subscription]lib.StatusKind{},
+			recentUpdates:   map[string]*recentUpdates{},
+			pendingFeedback: map[chat]feedbackRequest{},
+			lastRemovals:    map[chat]removal{},
+			lastSearches:    map[chat]int{},
+			addsInProgress:  map[chat]bool{},
+			languages:       map[int64]string{},
 		},
 	}
 	w.checkModel = w.testCheckModel
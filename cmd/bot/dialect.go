@@ -0,0 +1,97 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// dialect adapts queries written for SQLite to the database driver,
+// the zero value is the SQLite dialect
+type dialect struct {
+	postgres bool
+}
+
+const (
+	driverSQLite   = "sqlite3"
+	driverPostgres = "postgres"
+)
+
+var (
+	insertOrIgnoreRegexp = regexp.MustCompile(`(?i)\binsert\s+or\s+ignore\s+into\b`)
+	ddlRegexp            = regexp.MustCompile(`(?i)^\s*(create|alter)\s+table\b`)
+	serialIDRegexp       = regexp.MustCompile(`(?i)\bid\s+integer\s+primary\s+key\b`)
+	integerRegexp        = regexp.MustCompile(`(?i)\binteger\b`)
+)
+
+func newDialect(driver string) dialect {
+	return dialect{postgres: driver == driverPostgres}
+}
+
+// rebind rewrites a query for the database,
+// SQLite queries are returned unchanged
+func (d dialect) rebind(query string) string {
+	if !d.postgres {
+		return query
+	}
+	if ddlRegexp.MatchString(query) {
+		// Telegram chat IDs do not fit into 32-bit PostgreSQL integers
+		query = serialIDRegexp.ReplaceAllString(query, "id bigserial primary key")
+		query = integerRegexp.ReplaceAllString(query, "bigint")
+	}
+	if insertOrIgnoreRegexp.MatchString(query) {
+		query = insertOrIgnoreRegexp.ReplaceAllString(query, "insert into")
+		query = strings.TrimRight(query, " \t\n;") + " on conflict do nothing"
+	}
+	query = strings.Replace(query, "last_insert_rowid()", "lastval()", -1)
+	return placeholders(query)
+}
+
+// placeholders replaces question marks outside of string literals with numbered PostgreSQL placeholders
+func placeholders(query string) string {
+	var b strings.Builder
+	n := 0
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case r == '?' && !quoted:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// args converts query arguments for the database,
+// PostgreSQL does not accept booleans for integer columns
+func (d dialect) args(args []interface{}) []interface{} {
+	if !d.postgres {
+		return args
+	}
+	result := make([]interface{}, len(args))
+	for i, a := range args {
+		if b, ok := a.(bool); ok {
+			if b {
+				result[i] = 1
+			} else {
+				result[i] = 0
+			}
+			continue
+		}
+		result[i] = a
+	}
+	return result
+}
+
+// rowCount returns a query counting rows of the table fast,
+// the result is approximate
+func (d dialect) rowCount(table string) string {
+	if d.postgres {
+		return "select coalesce(max(reltuples), 0)::bigint from pg_class where relname='" + table + "'"
+	}
+	return "select max(_rowid_) from " + table
+}
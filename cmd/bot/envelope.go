@@ -16,13 +16,35 @@ type env struct {
 	ch    chan<- *env
 }
 
-// Close implements smtpd.Envelope.Close
-func (e *env) Close() error {
+// mailAddress is a mail address not coming from an SMTP connection
+type mailAddress string
+
+// Email implements smtpd.MailAddress.Email
+func (a mailAddress) Email() string {
+	return string(a)
+}
+
+// Hostname implements smtpd.MailAddress.Hostname
+func (a mailAddress) Hostname() string {
+	_, host := splitAddress(string(a))
+	return host
+}
+
+// parse parses the received data of the envelope
+func (e *env) parse() error {
 	mime, err := enmime.ReadEnvelope(bytes.NewReader(e.data))
 	if err != nil {
 		return err
 	}
 	e.mime = mime
+	return nil
+}
+
+// Close implements smtpd.Envelope.Close
+func (e *env) Close() error {
+	if err := e.parse(); err != nil {
+		return err
+	}
 	e.ch <- e
 	return nil
 }
@@ -7,22 +7,31 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"html"
 	"image"
+	"io"
 	"io/ioutil"
+	"math"
 	"math/rand"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"path"
+	"regexp"
 	"runtime"
+	"runtime/debug"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"text/template"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	_ "image/gif"
 	_ "image/jpeg"
@@ -33,6 +42,7 @@ import (
 	"github.com/bcmk/siren/payments"
 	tg "github.com/bcmk/telegram-bot-api"
 	"github.com/google/uuid"
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -54,17 +64,38 @@ type timeDiff struct {
 	Nanoseconds int
 }
 
+type notificationKind int
+
+const (
+	statusNotification notificationKind = iota
+	displayNameNotification
+)
+
 type notification struct {
+	kind            notificationKind
+	endpoint        string
+	chatID          int64
+	modelID         string
+	status          lib.StatusKind
+	timeDiff        *timeDiff
+	sessionDuration *timeDiff
+	displayName     [2]string
+	imageLink       bool // the image is sent as a link with a preview instead of an uploaded photo
+	reply           bool // the notification answers a command of the chat
+	alias           string
+}
+
+type subscription struct {
 	endpoint string
 	chatID   int64
 	modelID  string
-	status   lib.StatusKind
-	timeDiff *timeDiff
 }
 
 type model struct {
-	modelID string
-	status  lib.StatusKind
+	modelID       string
+	status        lib.StatusKind
+	notifyOnline  bool
+	notifyOffline bool
 }
 
 type statusChange struct {
@@ -81,9 +112,23 @@ type statRequest struct {
 }
 
 type ipnRequest struct {
-	writer  http.ResponseWriter
-	request *http.Request
-	done    chan bool
+	coinPayments *coinPaymentsConfig
+	writer       http.ResponseWriter
+	request      *http.Request
+	done         chan bool
+}
+
+type successRing struct {
+	results []bool
+	pos     int
+	count   int
+}
+
+// recentUpdates keeps a bounded number of the most recent update IDs
+type recentUpdates struct {
+	ids  []int
+	pos  int
+	seen map[int]bool
 }
 
 type queryDurationsData struct {
@@ -91,6 +136,18 @@ type queryDurationsData struct {
 	count int
 }
 
+// cachedImage is a downloaded image reused for image_cache_seconds
+type cachedImage struct {
+	data       []byte
+	downloaded time.Time
+}
+
+// cycleChanges is the number of status changes in an update cycle
+type cycleChanges struct {
+	changes   int
+	confirmed int
+}
+
 type user struct {
 	chatID               int64
 	maxModels            int
@@ -98,25 +155,71 @@ type user struct {
 	blacklist            bool
 	showImages           bool
 	offlineNotifications bool
+	snooze               bool
+	resumeTimestamp      int
+	dailyNotifications   int
+	muteUntil            int
+	imageLimit           int
+	quietFrom            int
+	quietTo              int
+	timezone             string
+	minOnlineMinutes     int
+	paused               bool
+	imageLinks           bool
+}
+
+// subscriber is a chat subscribed to a model with the settings the notifications of the subscription depend on
+type subscriber struct {
+	chatID               int64
+	offlineNotifications bool
+	snooze               bool
+	notifyOnline         bool
+	notifyOffline        bool
+}
+
+// startParameterRegexp matches start parameters Telegram allows in deep links
+var startParameterRegexp = regexp.MustCompile(`^[A-Za-z0-9_\-]{1,64}$`)
+
+// maxAliasLength is the maximum number of characters in a model alias
+const maxAliasLength = 32
+
+// muteForever is the mute_until value of the chats muted until unmute command
+const muteForever = -1
+
+// muted returns true if notifications for the user are silenced
+func (u user) muted(now int) bool {
+	return u.muteUntil == muteForever || u.muteUntil > now
 }
 
 type worker struct {
 	clients                  []*lib.Client
+	headers                  *lib.HeadersRotation
 	bots                     map[string]*tg.BotAPI
 	db                       *sql.DB
+	dialect                  dialect
 	cfg                      *config
 	httpQueriesDuration      time.Duration
 	updatesDuration          time.Duration
 	changesInPeriod          int
 	confirmedChangesInPeriod int
+	onlineChangesInPeriod    int
+	offlineChangesInPeriod   int
+	recentCycles             []cycleChanges
+	confirmationFactor       int
 	ourOnline                map[string]bool
+	ourIdle                  map[string]bool
+	ourPrivate               map[string]bool
 	specialModels            map[string]bool
 	siteStatuses             map[string]statusChange
 	siteOnline               map[string]bool
+	siteIdle                 map[string]bool
+	sitePrivate              map[string]bool
 	tr                       map[string]*lib.Translations
 	tpl                      map[string]*template.Template
+	languageTr               map[string]map[string]*lib.Translations
+	languageTpl              map[string]map[string]*template.Template
 	modelIDPreprocessing     func(string) string
-	checkModel               func(client *lib.Client, modelID string, headers [][2]string, dbg bool, config map[string]string) lib.StatusKind
+	checkModel               func(client *lib.Client, modelID string, headers [][2]string, dbg bool, config map[string]string) (lib.StatusKind, error)
 	onlineModelsAPI          func(
 		endpoint string,
 		client *lib.Client,
@@ -133,14 +236,49 @@ type worker struct {
 	downloadErrors        []bool
 	downloadResultsPos    int
 	nextErrorReport       time.Time
-	coinPaymentsAPI       *payments.CoinPaymentsAPI
+	errorRateAlerted      bool
+	lastOnlineUpdate      time.Time
+	lastSuccessfulPoll    time.Time
+	stalenessReported     bool
+	unknownStatusReports  map[string]time.Time
+	coinPaymentsAPIs      map[string]*payments.CoinPaymentsAPI
 	mailTLS               *tls.Config
 	durations             map[string]queryDurationsData
 	images                map[string]string
+	imageCache            map[string]cachedImage
+	imageCacheHits        int
+	imageCacheMisses      int
+	categories            map[string]string
+	offlineConfirmations  map[string]int
+	languages             map[int64]string // the languages chosen by the users
+	displayNames          map[string]string
+	originalIDs           map[string]string
+	modelIDAliases        map[string]string // old model IDs mapped to the canonical ones
+	fallbackImageURL      *template.Template
+	freshImageURL         *template.Template
+	location              *time.Location
 	botNames              map[string]string
 	lowPriorityMsg        chan outgoingPacket
 	highPriorityMsg       chan outgoingPacket
 	outgoingMsgResults    chan msgSendResult
+	deliveryWebhook       chan msgSendResult
+	mailJobs              []chan mailJob
+	clientResults         map[*lib.Client]*successRing
+	recentUpdates         map[string]*recentUpdates
+	pendingFeedback       map[chat]feedbackRequest
+	lastRemovals          map[chat]removal
+	sendResults           []msgSendResult
+	imageUploads          chan struct{}
+	watches               map[subscription]lib.StatusKind
+	watchResults          chan watchResult
+	checkLists            map[chat]bool
+	checkListResults      chan checkListResult
+	auditInProgress       bool
+	auditResults          chan auditResult
+	lastSearches          map[chat]int
+	importResults         chan importResult
+	addsInProgress        map[chat]bool
+	addResults            chan addRequest
 }
 
 type incomingPacket struct {
@@ -149,9 +287,10 @@ type incomingPacket struct {
 }
 
 type outgoingPacket struct {
-	message   baseChattable
-	endpoint  string
-	requested time.Time
+	message     baseChattable
+	endpoint    string
+	requested   time.Time
+	broadcastID int64 // the broadcast the message belongs to, zero for other messages
 }
 
 type email struct {
@@ -160,14 +299,107 @@ type email struct {
 	email    string
 }
 
+type chat struct {
+	endpoint string
+	chatID   int64
+}
+
+// feedbackRequest is a feedback category awaiting the feedback text
+type feedbackRequest struct {
+	category string
+	expires  int
+}
+
+type modelCheck struct {
+	Model  string
+	Status string
+}
+
+type checkListResult struct {
+	chat   chat
+	checks []modelCheck
+}
+
+// auditResult is a result of checking the polled models for existence
+type auditResult struct {
+	endpoint string
+	total    int
+	checked  int
+	failed   int
+	notFound []string
+}
+
+type importResult struct {
+	chat    chat
+	data    []byte
+	special bool
+}
+
+type importLine struct {
+	Model  string
+	Result string
+}
+
+// addRequest is a list of models a chat subscribes to at once,
+// the models unknown to the bot are checked before subscribing
+type addRequest struct {
+	chat     chat
+	lines    []string                // the models as the user sent them
+	models   []string                // the preprocessed model IDs
+	imported bool                    // the models come from a file, the result is reported line by line
+	checks   map[string]checkedModel // the check results of the models unknown to the bot
+}
+
+// checkedModel is a result of checking a model unknown to the bot
+type checkedModel struct {
+	status lib.StatusKind
+	err    error
+}
+
+// removal is a removed subscription with its settings kept for undo
+type removal struct {
+	modelID       string
+	createdAt     int
+	notifyOnline  bool
+	notifyOffline bool
+	alias         string // empty if the model had no alias
+	tag           string // empty if the model had no tag
+	timestamp     int
+}
+
+type watchResult struct {
+	subscription subscription
+	status       lib.StatusKind
+	done         bool
+}
+
+type mailJob struct {
+	email email
+	env   *env
+}
+
 type appliedKind int
 
 const (
 	invalidReferral appliedKind = iota
 	followerExists
+	followerAlreadyReferred
 	referralApplied
 )
 
+type addKind int
+
+const (
+	modelAdded addKind = iota
+	modelInvalid
+	modelAlreadyAdded
+	modelLimitReached
+	modelNotFound
+	modelCheckFailed
+	modelAddError
+	modelNotChecked
+)
+
 const (
 	messageSent                = 200
 	messageBadRequest          = 400
@@ -181,12 +413,13 @@ const (
 )
 
 type msgSendResult struct {
-	priority  int
-	timestamp int
-	result    int
-	endpoint  string
-	chatID    int64
-	delay     int
+	priority    int
+	timestamp   int
+	result      int
+	endpoint    string
+	chatID      int64
+	delay       int
+	broadcastID int64
 }
 
 func newWorker() *worker {
@@ -203,9 +436,14 @@ func newWorker() *worker {
 		checkErr(err)
 	}
 
+	var proxy *url.URL
+	if cfg.ProxyURL != "" {
+		proxy, err = url.Parse(cfg.ProxyURL)
+		checkErr(err)
+	}
 	var clients []*lib.Client
 	for _, address := range cfg.SourceIPAddresses {
-		clients = append(clients, lib.HTTPClientWithTimeoutAndAddress(cfg.TimeoutSeconds, address, cfg.EnableCookies))
+		clients = append(clients, lib.HTTPClientWithProxy(cfg.TimeoutSeconds, address, cfg.EnableCookies, proxy))
 	}
 
 	telegramClient := lib.HTTPClientWithTimeoutAndAddress(cfg.TelegramTimeoutSeconds, "", false)
@@ -216,18 +454,20 @@ func newWorker() *worker {
 		bot, err = tg.NewBotAPIWithClient(p.BotToken, tg.APIEndpoint, telegramClient.Client)
 		checkErr(err)
 		bots[n] = bot
+		if p.Canary != nil {
+			bots[canaryEndpoint(n)] = bot
+		}
 	}
-	db, err := sql.Open("sqlite3", cfg.DBPath)
+	db, err := sql.Open(driverName(cfg), dataSourceName(cfg))
 	checkErr(err)
 	tr, tpl := lib.LoadAllTranslations(trsByEndpoint(cfg))
-	for _, t := range tpl {
-		template.Must(t.New("affiliate_link").Parse(cfg.AffiliateLink))
-	}
 	w := &worker{
 		bots:                 bots,
 		db:                   db,
+		dialect:              newDialect(driverName(cfg)),
 		cfg:                  cfg,
 		clients:              clients,
+		headers:              lib.NewHeadersRotation(cfg.Headers, cfg.UserAgents),
 		tr:                   tr,
 		tpl:                  tpl,
 		unsuccessfulRequests: make([]bool, cfg.errorDenominator),
@@ -235,83 +475,248 @@ func newWorker() *worker {
 		mailTLS:              mailTLS,
 		durations:            map[string]queryDurationsData{},
 		images:               map[string]string{},
+		imageCache:           map[string]cachedImage{},
+		categories:           map[string]string{},
+		displayNames:         map[string]string{},
+		originalIDs:          map[string]string{},
+		unknownStatusReports: map[string]time.Time{},
 		botNames:             map[string]string{},
 		lowPriorityMsg:       make(chan outgoingPacket, 10000),
 		highPriorityMsg:      make(chan outgoingPacket, 10000),
 		outgoingMsgResults:   make(chan msgSendResult),
+		clientResults:        map[*lib.Client]*successRing{},
+		recentUpdates:        map[string]*recentUpdates{},
+		pendingFeedback:      map[chat]feedbackRequest{},
+		lastRemovals:         map[chat]removal{},
+		watches:              map[subscription]lib.StatusKind{},
+		watchResults:         make(chan watchResult),
+		checkLists:           map[chat]bool{},
+		lastSearches:         map[chat]int{},
+		checkListResults:     make(chan checkListResult),
+		auditResults:         make(chan auditResult),
+		importResults:        make(chan importResult),
+		addsInProgress:       map[chat]bool{},
+		languages:            map[int64]string{},
+		addResults:           make(chan addRequest),
+	}
+
+	if cfg.MaxConcurrentImageUploads > 0 {
+		w.imageUploads = make(chan struct{}, cfg.MaxConcurrentImageUploads)
+	}
+
+	w.location, err = time.LoadLocation(cfg.Timezone)
+	checkErr(err)
+
+	w.languageTr = map[string]map[string]*lib.Translations{}
+	w.languageTpl = map[string]map[string]*template.Template{}
+	for n, p := range cfg.Endpoints {
+		if len(p.CommandLanguages) != 0 {
+			w.languageTr[n], w.languageTpl[n] = lib.LoadAllTranslations(p.CommandLanguages)
+		}
+	}
+
+	for _, t := range tpl {
+		template.Must(t.New("affiliate_link").Funcs(template.FuncMap{"model_name": w.modelName}).Parse(cfg.AffiliateLink))
+	}
+	for _, langs := range w.languageTpl {
+		for _, t := range langs {
+			template.Must(t.New("affiliate_link").Funcs(template.FuncMap{"model_name": w.modelName}).Parse(cfg.AffiliateLink))
+		}
+	}
+
+	if cfg.FallbackImageURL != "" {
+		w.fallbackImageURL = template.Must(template.New("fallback_image_url").Parse(cfg.FallbackImageURL))
+	}
+	if cfg.FreshImageURL != "" {
+		w.freshImageURL = template.Must(template.New("fresh_image_url").Parse(cfg.FreshImageURL))
 	}
 
+	w.coinPaymentsAPIs = map[string]*payments.CoinPaymentsAPI{}
+	var sharedCoinPaymentsAPI *payments.CoinPaymentsAPI
 	if cp := cfg.CoinPayments; cp != nil {
-		w.coinPaymentsAPI = payments.NewCoinPaymentsAPI(cp.PublicKey, cp.PrivateKey, "https://"+cp.IPNListenURL, cfg.TimeoutSeconds, cfg.Debug)
-	}
-
-	switch cfg.Website {
-	case "test":
-		w.checkModel = lib.CheckModelTest
-		w.onlineModelsAPI = lib.TestOnlineAPI
-		w.modelIDPreprocessing = lib.CanonicalModelID
-	case "bongacams":
-		w.checkModel = lib.CheckModelBongaCams
-		w.onlineModelsAPI = lib.BongaCamsOnlineAPI
-		w.modelIDPreprocessing = lib.CanonicalModelID
-	case "chaturbate":
-		w.checkModel = lib.CheckModelChaturbate
-		w.onlineModelsAPI = lib.ChaturbateOnlineAPI
-		w.modelIDPreprocessing = lib.CanonicalModelID
-	case "stripchat":
-		w.checkModel = lib.CheckModelStripchat
-		w.onlineModelsAPI = lib.StripchatOnlineAPI
-		w.modelIDPreprocessing = lib.CanonicalModelID
-	case "livejasmin":
-		w.checkModel = lib.CheckModelLiveJasmin
-		w.onlineModelsAPI = lib.LiveJasminOnlineAPI
-		w.modelIDPreprocessing = lib.CanonicalModelID
-	case "camsoda":
-		w.checkModel = lib.CheckModelCamSoda
-		w.onlineModelsAPI = lib.CamSodaOnlineAPI
-		w.modelIDPreprocessing = lib.CanonicalModelID
-	case "flirt4free":
-		w.checkModel = lib.CheckModelFlirt4Free
-		w.onlineModelsAPI = lib.Flirt4FreeOnlineAPI
-		w.modelIDPreprocessing = lib.Flirt4FreeCanonicalModelID
-	default:
+		sharedCoinPaymentsAPI = payments.NewCoinPaymentsAPI(cp.PublicKey, cp.PrivateKey, "https://"+cp.IPNListenURL, cfg.TimeoutSeconds, cfg.Debug)
+	}
+	for n, p := range cfg.Endpoints {
+		if cp := p.CoinPayments; cp != nil {
+			w.coinPaymentsAPIs[n] = payments.NewCoinPaymentsAPI(cp.PublicKey, cp.PrivateKey, "https://"+cp.IPNListenURL, cfg.TimeoutSeconds, cfg.Debug)
+		} else if sharedCoinPaymentsAPI != nil {
+			w.coinPaymentsAPIs[n] = sharedCoinPaymentsAPI
+		}
+	}
+
+	site, ok := websites[cfg.Website]
+	if !ok {
 		panic("wrong website")
 	}
+	w.checkModel = site.checkModel
+	w.onlineModelsAPI = site.onlineModelsAPI
+	w.modelIDPreprocessing = func(modelID string) string { return w.canonicalModelID(site.modelIDPreprocessing(modelID)) }
 
 	return w
 }
 
+// retryingChecker returns the model checker retrying network errors,
+// it sleeps between the retries, so it is used only outside of the main loop
+func (w *worker) retryingChecker() lib.ModelChecker {
+	if w.cfg.CheckRetries == 0 {
+		return w.checkModel
+	}
+	return lib.RetryChecker(w.checkModel, w.cfg.CheckRetries, time.Duration(w.cfg.RetryBackoffMs)*time.Millisecond)
+}
+
+// website holds the functions checking the models of a website
+type website struct {
+	checkModel           lib.ModelChecker
+	onlineModelsAPI      func(endpoint string, client *lib.Client, headers [][2]string, debug bool, config map[string]string) (map[string]lib.OnlineModel, error)
+	modelIDPreprocessing func(string) string
+	checker              lib.CheckerKind // the default checker strategy
+}
+
+// websites are the supported websites by the name used in the configuration
+var websites = map[string]website{
+	"test":       {lib.CheckModelTest, lib.TestOnlineAPI, lib.CanonicalModelID, lib.CheckerAPI},
+	"bongacams":  {lib.CheckModelBongaCams, lib.BongaCamsOnlineAPI, lib.CanonicalModelID, lib.CheckerAPI},
+	"chaturbate": {lib.CheckModelChaturbate, lib.ChaturbateOnlineAPI, lib.CanonicalModelID, lib.CheckerAPI},
+	"stripchat":  {lib.CheckModelStripchat, lib.StripchatOnlineAPI, lib.CanonicalModelID, lib.CheckerAPI},
+	"livejasmin": {lib.CheckModelLiveJasmin, lib.LiveJasminOnlineAPI, lib.CanonicalModelID, lib.CheckerAPI},
+	"camsoda":    {lib.CheckModelCamSoda, lib.CamSodaOnlineAPI, lib.CanonicalModelID, lib.CheckerAPI},
+	"flirt4free": {lib.CheckModelFlirt4Free, lib.Flirt4FreeOnlineAPI, lib.Flirt4FreeCanonicalModelID, lib.CheckerAPI},
+	"myfreecams": {lib.CheckModelMyFreeCams, lib.MyFreeCamsOnlineAPI, lib.CanonicalModelID, lib.CheckerAPI},
+	"cam4":       {lib.CheckModelCam4, lib.Cam4OnlineAPI, lib.CanonicalModelID, lib.CheckerAPI},
+}
+
 func trsByEndpoint(cfg *config) map[string][]string {
 	result := make(map[string][]string)
 	for k, v := range cfg.Endpoints {
 		result[k] = v.Translation
+		if v.Canary != nil {
+			result[canaryEndpoint(k)] = v.Canary.Translation
+		}
 	}
 	return result
 }
 
-func (w *worker) setWebhook() {
+const canarySuffix = "-canary"
+
+// canaryEndpoint returns the name of the virtual endpoint
+// serving canary users of the endpoint
+func canaryEndpoint(endpoint string) string {
+	return endpoint + canarySuffix
+}
+
+// baseEndpoint returns the configured endpoint of the endpoint,
+// a canary endpoint resolves to the endpoint it serves canary users of
+func baseEndpoint(endpoint string) string {
+	return strings.TrimSuffix(endpoint, canarySuffix)
+}
+
+// mergeRemovedCanaries moves the records of the canary users back to the endpoint
+// if its canary is not configured anymore
+func (w *worker) mergeRemovedCanaries() {
+	for n, p := range w.cfg.Endpoints {
+		if p.Canary != nil || w.mustInt("select count(*) from canary_users where endpoint=?", n) == 0 {
+			continue
+		}
+		for _, line := range w.moveEndpoint(canaryEndpoint(n), n) {
+			linf("canary of endpoint %s is removed, %s", n, line)
+		}
+		w.mustExec("delete from canary_users where endpoint=?", n)
+	}
+}
+
+// coinPayments returns the CoinPayments configuration of the endpoint,
+// it falls back to the shared configuration and returns nil if payments are not configured
+func (w *worker) coinPayments(endpoint string) *coinPaymentsConfig {
+	if cp := w.cfg.Endpoints[baseEndpoint(endpoint)].CoinPayments; cp != nil {
+		return cp
+	}
+	return w.cfg.CoinPayments
+}
+
+// paymentsEnabled returns true if users of the endpoint can buy subscriptions
+func (w *worker) paymentsEnabled(endpoint string) bool {
+	return w.coinPayments(endpoint) != nil && w.cfg.Mail != nil
+}
+
+// routeEndpoint returns the canary endpoint for the chats served by the canary,
+// new chats are assigned to the canary by the hash of the chat ID
+func (w *worker) routeEndpoint(endpoint string, chatID int64) string {
+	canary := w.cfg.Endpoints[endpoint].Canary
+	if canary == nil {
+		return endpoint
+	}
+	if w.mustInt("select count(*) from canary_users where chat_id=? and endpoint=?", chatID, endpoint) != 0 {
+		return canaryEndpoint(endpoint)
+	}
+	if _, found := w.user(chatID); found {
+		return endpoint
+	}
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(strconv.FormatInt(chatID, 10)))
+	if float64(hash.Sum32()) >= canary.Fraction*float64(math.MaxUint32+1) {
+		return endpoint
+	}
+	w.mustExec("insert into canary_users (chat_id, endpoint) values (?,?)", chatID, endpoint)
+	linf("chat %d is served by the canary of endpoint %s", chatID, endpoint)
+	return canaryEndpoint(endpoint)
+}
+
+// forEachEndpoint runs the action for all endpoints with a bounded concurrency,
+// it waits for all actions and returns all the errors occurred
+func (w *worker) forEachEndpoint(action func(n string, p endpoint) error) error {
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	var messages []string
+	semaphore := make(chan struct{}, w.cfg.StartupConcurrency)
 	for n, p := range w.cfg.Endpoints {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(n string, p endpoint) {
+			defer func() { <-semaphore }()
+			defer wg.Done()
+			if err := action(n, p); err != nil {
+				mutex.Lock()
+				messages = append(messages, fmt.Sprintf("endpoint %s: %v", n, err))
+				mutex.Unlock()
+			}
+		}(n, p)
+	}
+	wg.Wait()
+	if len(messages) == 0 {
+		return nil
+	}
+	sort.Strings(messages)
+	return errors.New(strings.Join(messages, "; "))
+}
+
+func (w *worker) setWebhook() {
+	checkErr(w.forEachEndpoint(func(n string, p endpoint) error {
 		linf("setting webhook for endpoint %s...", n)
 		if p.WebhookDomain == "" {
-			continue
+			return nil
 		}
 		if p.CertificatePath == "" {
-			var _, err = w.bots[n].SetWebhook(tg.NewWebhook(path.Join(p.WebhookDomain, p.ListenPath)))
-			checkErr(err)
+			if _, err := w.bots[n].SetWebhook(tg.NewWebhook(path.Join(p.WebhookDomain, p.ListenPath))); err != nil {
+				return err
+			}
 		} else {
-			var _, err = w.bots[n].SetWebhook(tg.NewWebhookWithCert(path.Join(p.WebhookDomain, p.ListenPath), p.CertificatePath))
-			checkErr(err)
+			if _, err := w.bots[n].SetWebhook(tg.NewWebhookWithCert(path.Join(p.WebhookDomain, p.ListenPath), p.CertificatePath)); err != nil {
+				return err
+			}
 		}
 		info, err := w.bots[n].GetWebhookInfo()
-		checkErr(err)
+		if err != nil {
+			return err
+		}
 		if info.LastErrorDate != 0 {
-			linf("last webhook error time: %v", time.Unix(int64(info.LastErrorDate), 0))
+			linf("last webhook error time for endpoint %s: %v", n, time.Unix(int64(info.LastErrorDate), 0))
 		}
 		if info.LastErrorMessage != "" {
-			linf("last webhook error message: %s", info.LastErrorMessage)
+			linf("last webhook error message for endpoint %s: %s", n, info.LastErrorMessage)
 		}
-		linf("OK")
-	}
+		linf("webhook for endpoint %s is set", n)
+		return nil
+	}))
 }
 
 func (w *worker) removeWebhook() {
@@ -324,35 +729,73 @@ func (w *worker) removeWebhook() {
 }
 
 func (w *worker) initBotNames() {
-	for n := range w.cfg.Endpoints {
+	var mutex sync.Mutex
+	checkErr(w.forEachEndpoint(func(n string, p endpoint) error {
 		user, err := w.bots[n].GetMe()
-		checkErr(err)
+		if err != nil {
+			return err
+		}
 		linf("bot name for endpoint %s: %s", n, user.UserName)
+		mutex.Lock()
+		defer mutex.Unlock()
 		w.botNames[n] = user.UserName
-	}
+		if p.Canary != nil {
+			w.botNames[canaryEndpoint(n)] = user.UserName
+		}
+		return nil
+	}))
 }
 
 func (w *worker) setCommands() {
-	for n := range w.cfg.Endpoints {
-		text := templateToString(w.tpl[n], w.tr[n].RawCommands.Key, nil)
-		lines := strings.Split(text, "\n")
-		var commands []tg.BotCommand
-		for _, l := range lines {
-			pair := strings.SplitN(l, "-", 2)
-			if len(pair) != 2 {
-				checkErr(fmt.Errorf("unexpected command pair %q", l))
-			}
-			pair[0], pair[1] = strings.TrimSpace(pair[0]), strings.TrimSpace(pair[1])
-			commands = append(commands, tg.BotCommand{Command: pair[0], Description: pair[1]})
-			if w.cfg.Debug {
-				ldbg("command %s - %s", pair[0], pair[1])
+	checkErr(w.forEachEndpoint(func(n string, p endpoint) error {
+		linf("setting commands for endpoint %s...", n)
+		if err := w.setMyCommands(n, "", w.commands(w.tpl[n], w.tr[n])); err != nil {
+			return err
+		}
+		for lang := range p.CommandLanguages {
+			linf("setting commands for endpoint %s, language %s...", n, lang)
+			if err := w.setMyCommands(n, lang, w.commands(w.languageTpl[n][lang], w.languageTr[n][lang])); err != nil {
+				return fmt.Errorf("language %s: %v", lang, err)
 			}
 		}
-		linf("setting commands for endpoint %s...", n)
-		err := w.bots[n].SetMyCommands(commands)
-		checkErr(err)
-		linf("OK")
+		linf("commands for endpoint %s are set", n)
+		return nil
+	}))
+}
+
+func (w *worker) commands(tpl *template.Template, tr *lib.Translations) []tg.BotCommand {
+	text := templateToString(tpl, tr.RawCommands.Key, nil)
+	lines := strings.Split(text, "\n")
+	var commands []tg.BotCommand
+	for _, l := range lines {
+		pair := strings.SplitN(l, "-", 2)
+		if len(pair) != 2 {
+			checkErr(fmt.Errorf("unexpected command pair %q", l))
+		}
+		pair[0], pair[1] = strings.TrimSpace(pair[0]), strings.TrimSpace(pair[1])
+		commands = append(commands, tg.BotCommand{Command: pair[0], Description: pair[1]})
+		if w.cfg.Debug {
+			ldbg("command %s - %s", pair[0], pair[1])
+		}
+	}
+	return commands
+}
+
+// setMyCommands sets commands for a specific language code,
+// the default command list is set if the language code is empty
+func (w *worker) setMyCommands(endpoint string, lang string, commands []tg.BotCommand) error {
+	if lang == "" {
+		return w.bots[endpoint].SetMyCommands(commands)
 	}
+	data, err := json.Marshal(commands)
+	if err != nil {
+		return err
+	}
+	v := url.Values{}
+	v.Add("commands", string(data))
+	v.Add("language_code", lang)
+	_, err = w.bots[endpoint].MakeRequest("setMyCommands", v)
+	return err
 }
 
 func (w *worker) incrementBlock(endpoint string, chatID int64) {
@@ -367,6 +810,57 @@ func (w *worker) resetBlock(endpoint string, chatID int64) {
 	w.mustExec("update block set block=0 where endpoint=? and chat_id=?", endpoint, chatID)
 }
 
+// chatNotFound handles a chat Telegram cannot find, usually it means the chat is deleted,
+// it removes the subscriptions of the chat if configured, otherwise the chat is treated as blocked
+func (w *worker) chatNotFound(endpoint string, chatID int64) {
+	linf("chat %d is not found at endpoint %s", chatID, endpoint)
+	if w.cfg.PruneNotFoundChats {
+		w.mustExec("delete from signals where chat_id=? and endpoint=?", chatID, endpoint)
+		w.mustExec("delete from aliases where chat_id=? and endpoint=?", chatID, endpoint)
+		w.mustExec("delete from tags where chat_id=? and endpoint=?", chatID, endpoint)
+		return
+	}
+	w.mustExec(`
+		insert into block (endpoint, chat_id, block) values (?,?,?)
+		on conflict(chat_id, endpoint) do update set block=excluded.block`,
+		endpoint,
+		chatID,
+		w.cfg.BlockThreshold)
+}
+
+// probeBlocked sends a chat action to the chats that blocked the bot,
+// a successful send resets the block counter so the chat is polled again
+func (w *worker) probeBlocked(now int) {
+	query := w.mustQuery(`
+		select endpoint, chat_id from block
+		where block>=? and probed_at<=?
+		order by probed_at
+		limit ?`,
+		w.cfg.BlockThreshold,
+		now-w.cfg.BlockedProbeIntervalHours*3600,
+		w.cfg.BlockedProbesPerPeriod)
+	defer func() { checkErr(query.Close()) }()
+	var chats []chat
+	for query.Next() {
+		var c chat
+		checkErr(query.Scan(&c.endpoint, &c.chatID))
+		chats = append(chats, c)
+	}
+	checkErr(query.Err())
+	for _, c := range chats {
+		w.mustExec("update block set probed_at=? where endpoint=? and chat_id=?", now, c.endpoint, c.chatID)
+		w.enqueueMessage(w.lowPriorityMsg, c.endpoint, &chatActionConfig{tg.NewChatAction(c.chatID, tg.ChatTyping)})
+	}
+}
+
+// parseMode returns a parse mode overridden by the endpoint config
+func (w *worker) parseMode(endpoint string, parse lib.ParseKind) lib.ParseKind {
+	if w.cfg.Endpoints[baseEndpoint(endpoint)].ForceRawParse {
+		return lib.ParseRaw
+	}
+	return parse
+}
+
 func (w *worker) sendText(
 	queue chan outgoingPacket,
 	endpoint string,
@@ -379,6 +873,7 @@ func (w *worker) sendText(
 	msg := tg.NewMessage(chatID, text)
 	msg.DisableNotification = !notify
 	msg.DisableWebPagePreview = disablePreview
+	parse = w.parseMode(endpoint, parse)
 	switch parse {
 	case lib.ParseHTML, lib.ParseMarkdown:
 		msg.ParseMode = parse.String()
@@ -399,6 +894,7 @@ func (w *worker) sendImage(
 	msg := tg.NewPhotoUpload(chatID, fileBytes)
 	msg.Caption = text
 	msg.DisableNotification = !notify
+	parse = w.parseMode(endpoint, parse)
 	switch parse {
 	case lib.ParseHTML, lib.ParseMarkdown:
 		msg.ParseMode = parse.String()
@@ -407,10 +903,17 @@ func (w *worker) sendImage(
 }
 
 func (w *worker) enqueueMessage(queue chan outgoingPacket, endpoint string, msg baseChattable) {
+	w.enqueuePacket(queue, outgoingPacket{endpoint: endpoint, message: msg, requested: time.Now()})
+}
+
+// enqueuePacket returns false if the packet is dropped because the queue is full
+func (w *worker) enqueuePacket(queue chan outgoingPacket, packet outgoingPacket) bool {
 	select {
-	case queue <- outgoingPacket{endpoint: endpoint, message: msg, requested: time.Now()}:
+	case queue <- packet:
+		return true
 	default:
 		lerr("the outgoing message queue is full")
+		return false
 	}
 }
 
@@ -420,15 +923,16 @@ func (w *worker) sender(queue chan outgoingPacket, priority int) {
 		delay := 0
 	resend:
 		for {
-			result := w.sendMessageInternal(packet.endpoint, packet.message)
+			result := w.sendMessageLimited(packet.endpoint, packet.message)
 			delay = int(time.Since(packet.requested).Milliseconds())
 			w.outgoingMsgResults <- msgSendResult{
-				priority:  priority,
-				timestamp: now,
-				result:    result,
-				endpoint:  packet.endpoint,
-				chatID:    packet.message.baseChat().ChatID,
-				delay:     delay,
+				priority:    priority,
+				timestamp:   now,
+				result:      result,
+				endpoint:    packet.endpoint,
+				chatID:      packet.message.baseChat().ChatID,
+				delay:       delay,
+				broadcastID: packet.broadcastID,
 			}
 			switch result {
 			case messageTimeout:
@@ -448,6 +952,15 @@ func (w *worker) sender(queue chan outgoingPacket, priority int) {
 	}
 }
 
+// sendMessageLimited sends a message limiting the number of simultaneous image uploads
+func (w *worker) sendMessageLimited(endpoint string, msg baseChattable) int {
+	if _, ok := msg.(*photoConfig); ok && w.imageUploads != nil {
+		w.imageUploads <- struct{}{}
+		defer func() { <-w.imageUploads }()
+	}
+	return w.sendMessageInternal(endpoint, msg)
+}
+
 func (w *worker) sendMessageInternal(endpoint string, msg baseChattable) int {
 	chatID := msg.baseChat().ChatID
 	if _, err := w.bots[endpoint].Send(msg); err != nil {
@@ -515,7 +1028,8 @@ func (w *worker) sendTr(
 	translation *lib.Translation,
 	data map[string]interface{},
 ) {
-	tpl := w.tpl[endpoint]
+	tpl := w.chatTemplates(endpoint, chatID)
+	translation = w.chatTranslation(endpoint, chatID, translation)
 	text := templateToString(tpl, translation.Key, data)
 	w.sendText(queue, endpoint, chatID, notify, translation.DisablePreview, translation.Parse, text)
 }
@@ -529,11 +1043,53 @@ func (w *worker) sendTrImage(
 	data map[string]interface{},
 	image []byte,
 ) {
-	tpl := w.tpl[endpoint]
+	tpl := w.chatTemplates(endpoint, chatID)
+	translation = w.chatTranslation(endpoint, chatID, translation)
 	text := templateToString(tpl, translation.Key, data)
 	w.sendImage(queue, endpoint, chatID, notify, translation.Parse, text, image)
 }
 
+// chatTemplates returns the templates of the language preferred by the chat,
+// the endpoint templates are used if the chat has not chosen a language
+func (w *worker) chatTemplates(endpoint string, chatID int64) *template.Template {
+	langs := w.languageTpl[baseEndpoint(endpoint)]
+	if len(langs) == 0 {
+		return w.tpl[endpoint]
+	}
+	if tpl, found := langs[w.languages[chatID]]; found {
+		return tpl
+	}
+	return w.tpl[endpoint]
+}
+
+// chatTranslation returns the translation of the language preferred by the chat
+// having the same key as the given endpoint translation
+func (w *worker) chatTranslation(endpoint string, chatID int64, translation *lib.Translation) *lib.Translation {
+	tr, found := w.languageTr[baseEndpoint(endpoint)][w.languages[chatID]]
+	if !found {
+		return translation
+	}
+	if chosen := lib.Lookup(tr, translation.Key); chosen != nil {
+		return chosen
+	}
+	return translation
+}
+
+// queryLanguages returns the languages chosen by the users
+func (w *worker) queryLanguages() map[int64]string {
+	query := w.mustQuery("select chat_id, language from users where language!=''")
+	defer func() { checkErr(query.Close()) }()
+	languages := map[int64]string{}
+	for query.Next() {
+		var chatID int64
+		var lang string
+		checkErr(query.Scan(&chatID, &lang))
+		languages[chatID] = lang
+	}
+	checkErr(query.Err())
+	return languages
+}
+
 func (w *worker) createDatabase() {
 	linf("creating database if needed...")
 	for _, prelude := range w.cfg.SQLPrelude {
@@ -543,38 +1099,108 @@ func (w *worker) createDatabase() {
 	w.applyMigrations()
 }
 
+// driverName returns the database driver, SQLite by default
+func driverName(cfg *config) string {
+	if cfg.DBDriver == "" {
+		return driverSQLite
+	}
+	return cfg.DBDriver
+}
+
+// dataSourceName adds connection parameters to the database path,
+// they are applied to every connection in the pool unlike pragmas executed once,
+// the path is used as is for PostgreSQL
+func dataSourceName(cfg *config) string {
+	if driverName(cfg) == driverPostgres {
+		return cfg.DBPath
+	}
+	params := url.Values{}
+	if cfg.SQLBusyTimeoutMs > 0 {
+		params.Set("_busy_timeout", strconv.Itoa(cfg.SQLBusyTimeoutMs))
+	}
+	if cfg.SQLWAL {
+		params.Set("_journal_mode", "WAL")
+	}
+	if len(params) == 0 {
+		return cfg.DBPath
+	}
+	separator := "?"
+	if strings.Contains(cfg.DBPath, "?") {
+		separator = "&"
+	}
+	return cfg.DBPath + separator + params.Encode()
+}
+
+func (w *worker) logPragmas() {
+	if w.dialect.postgres {
+		return
+	}
+	var busyTimeout int
+	var journalMode string
+	checkErr(w.db.QueryRow("pragma busy_timeout").Scan(&busyTimeout))
+	checkErr(w.db.QueryRow("pragma journal_mode").Scan(&journalMode))
+	linf("database busy timeout: %d ms, journal mode: %s", busyTimeout, journalMode)
+}
+
 func (w *worker) initCache() {
 	start := time.Now()
 	w.siteStatuses = w.queryLastStatusChanges()
-	w.siteOnline = w.getLastOnlineModels()
-	w.ourOnline, w.specialModels = w.queryConfirmedModels()
+	w.siteOnline, w.siteIdle, w.sitePrivate = w.getLastOnlineModels()
+	w.ourOnline, w.ourIdle, w.ourPrivate, w.specialModels = w.queryConfirmedModels()
+	w.categories = w.queryCategories()
+	w.displayNames = w.queryDisplayNames()
+	w.originalIDs = w.queryOriginalIDs()
+	w.modelIDAliases = w.queryModelIDAliases()
+	w.offlineConfirmations = w.queryOfflineConfirmations()
+	w.languages = w.queryLanguages()
 	elapsed := time.Since(start)
 	linf("cache initialized in %d ms", elapsed.Milliseconds())
 }
 
-func (w *worker) getLastOnlineModels() map[string]bool {
-	res := map[string]bool{}
+// present reports whether a model is present on the website
+func present(status lib.StatusKind) bool {
+	return status == lib.StatusOnline || status == lib.StatusIdle || status == lib.StatusPrivate
+}
+
+func (w *worker) getLastOnlineModels() (online map[string]bool, idle map[string]bool, private map[string]bool) {
+	online = map[string]bool{}
+	idle = map[string]bool{}
+	private = map[string]bool{}
 	for k, v := range w.siteStatuses {
-		if v.status == lib.StatusOnline {
-			res[k] = true
+		if present(v.status) {
+			online[k] = true
+		}
+		if v.status == lib.StatusIdle {
+			idle[k] = true
+		}
+		if v.status == lib.StatusPrivate {
+			private[k] = true
 		}
 	}
-	return res
+	return
 }
 
+// lastSeenInfo returns the beginning and the end of the last session of a model
+// and the status preceding the session, consecutive statuses the model is present in make up a session,
+// the end is zero for a session in progress
 func (w *worker) lastSeenInfo(modelID string, now int) (begin int, end int, prevStatus lib.StatusKind) {
+	online, idle, private := lib.StatusOnline, lib.StatusIdle, lib.StatusPrivate
 	query := w.mustQuery(`
-		select timestamp, end, prev_status from (
+		select timestamp, (
+			select min(later.timestamp) from status_changes later
+			where later.model_id=changes.model_id and later.timestamp>changes.timestamp and later.status not in (?,?,?)
+		), prev_status from (
 			select
 				*,
-				lead(timestamp) over (order by timestamp) as end,
 				lag(status) over (order by timestamp) as prev_status
 			from status_changes
-			where model_id=?)
-		where status=?
+			where model_id=?) as changes
+		where status in (?,?,?) and (prev_status is null or prev_status not in (?,?,?))
 		order by timestamp desc limit 1`,
+		online, idle, private,
 		modelID,
-		lib.StatusOnline)
+		online, idle, private,
+		online, idle, private)
 	defer func() { checkErr(query.Close()) }()
 	if !query.Next() {
 		return 0, 0, lib.StatusUnknown
@@ -593,18 +1219,77 @@ func (w *worker) lastSeenInfo(modelID string, now int) (begin int, end int, prev
 	return begin, *maybeEnd, *maybePrevStatus
 }
 
-func (w *worker) confirmationSeconds(status lib.StatusKind) int {
+// confirmationSeconds returns the confirmation period of a status,
+// the offline confirmation period can be overridden for a model,
+// it is widened by the confirmation factor while the website is flapping
+func (w *worker) confirmationSeconds(modelID string, status lib.StatusKind) int {
+	seconds := 0
 	switch status {
 	case lib.StatusOnline:
-		return w.cfg.StatusConfirmationSeconds.Online
+		seconds = w.cfg.StatusConfirmationSeconds.Online
 	case lib.StatusOffline:
-		return w.cfg.StatusConfirmationSeconds.Offline
+		if override, ok := w.offlineConfirmations[modelID]; ok {
+			seconds = override
+		} else {
+			seconds = w.cfg.StatusConfirmationSeconds.Offline
+		}
 	case lib.StatusDenied:
-		return w.cfg.StatusConfirmationSeconds.Denied
+		seconds = w.cfg.StatusConfirmationSeconds.Denied
 	case lib.StatusNotFound:
-		return w.cfg.StatusConfirmationSeconds.NotFound
-	default:
-		return 0
+		seconds = w.cfg.StatusConfirmationSeconds.NotFound
+	case lib.StatusIdle:
+		seconds = w.cfg.StatusConfirmationSeconds.Idle
+	case lib.StatusPrivate:
+		seconds = w.cfg.StatusConfirmationSeconds.Private
+	}
+	if w.confirmationFactor > 1 {
+		seconds *= w.confirmationFactor
+	}
+	return seconds
+}
+
+// adaptConfirmation widens confirmation seconds if many status changes in recent cycles are not confirmed,
+// the factor is doubled while the website is flapping and halved back when it is stable
+func (w *worker) adaptConfirmation(changes, confirmed int) {
+	cfg := w.cfg.AdaptiveConfirmation
+	if cfg == nil {
+		return
+	}
+	w.recentCycles = append(w.recentCycles, cycleChanges{changes: changes, confirmed: confirmed})
+	if len(w.recentCycles) > cfg.Cycles {
+		w.recentCycles = w.recentCycles[len(w.recentCycles)-cfg.Cycles:]
+	}
+	total, totalConfirmed := 0, 0
+	for _, c := range w.recentCycles {
+		total += c.changes
+		totalConfirmed += c.confirmed
+	}
+	factor := w.confirmationFactor
+	if factor < 1 {
+		factor = 1
+	}
+	next := factor
+	flapping := 0.
+	if total > 0 && total >= cfg.MinChanges {
+		flapping = 1 - float64(totalConfirmed)/float64(total)
+	}
+	if flapping > cfg.FlappingThreshold {
+		next = factor * 2
+		if next > cfg.MaxFactor {
+			next = cfg.MaxFactor
+		}
+	} else if factor > 1 {
+		next = factor / 2
+	}
+	if next == factor {
+		return
+	}
+	w.confirmationFactor = next
+	w.recentCycles = nil
+	if next > factor {
+		linf("website is flapping, %.0f%% of status changes are not confirmed, confirmation factor is %d", flapping*100, next)
+	} else {
+		linf("website is stable, confirmation factor is %d", next)
 	}
 }
 
@@ -614,70 +1299,284 @@ func (w *worker) updateStatus(insertStatusChangeStmt, updateLastStatusChangeStmt
 		w.mustExecPrepared(insertStatusChange, insertStatusChangeStmt, next.modelID, next.status, next.timestamp)
 		w.mustExecPrepared(updateLastStatusChange, updateLastStatusChangeStmt, next.modelID, next.status, next.timestamp)
 		w.siteStatuses[next.modelID] = next
-		if next.status == lib.StatusOnline {
+		if present(next.status) {
 			w.siteOnline[next.modelID] = true
 		} else {
 			delete(w.siteOnline, next.modelID)
 		}
+		if next.status == lib.StatusIdle {
+			w.siteIdle[next.modelID] = true
+		} else {
+			delete(w.siteIdle, next.modelID)
+		}
+		if next.status == lib.StatusPrivate {
+			w.sitePrivate[next.modelID] = true
+		} else {
+			delete(w.sitePrivate, next.modelID)
+		}
 	}
 }
 
+// union returns all the elements of both slices without duplicates
+func union(xs, ys []string) []string {
+	result := append([]string{}, xs...)
+	set := map[string]bool{}
+	for _, x := range xs {
+		set[x] = true
+	}
+	for _, y := range ys {
+		if !set[y] {
+			set[y] = true
+			result = append(result, y)
+		}
+	}
+	return result
+}
+
 func (w *worker) confirm(updateModelStatusStmt *sql.Stmt, now int) []string {
 	all, _, _ := hashDiff(w.ourOnline, w.siteOnline)
+	idle, _, _ := hashDiff(w.ourIdle, w.siteIdle)
+	private, _, _ := hashDiff(w.ourPrivate, w.sitePrivate)
+	all = union(union(all, idle), private)
 	var confirmations []string
 	for _, c := range all {
 		statusChange := w.siteStatuses[c]
-		confirmationSeconds := w.confirmationSeconds(statusChange.status)
+		confirmationSeconds := w.confirmationSeconds(statusChange.modelID, statusChange.status)
 		durationConfirmed := confirmationSeconds == 0 || (now-statusChange.timestamp >= confirmationSeconds)
 		if durationConfirmed {
-			if statusChange.status == lib.StatusOnline {
-				w.ourOnline[statusChange.modelID] = true
-			} else {
-				delete(w.ourOnline, statusChange.modelID)
-			}
-			w.mustExecPrepared(updateModelStatus, updateModelStatusStmt, statusChange.modelID, statusChange.status)
+			w.confirmStatus(updateModelStatusStmt, statusChange)
 			confirmations = append(confirmations, statusChange.modelID)
 		}
 	}
 	return confirmations
 }
 
-func (w *worker) modelsToPoll() (models []string) {
-	modelsQuery := w.mustQuery(`
-		select distinct model_id from signals
-		left join block on signals.chat_id=block.chat_id and signals.endpoint=block.endpoint
-		where block.block is null or block.block<?
-		order by model_id`,
-		w.cfg.BlockThreshold)
-	defer func() { checkErr(modelsQuery.Close()) }()
-	for modelsQuery.Next() {
-		var modelID string
-		checkErr(modelsQuery.Scan(&modelID))
-		models = append(models, modelID)
+// confirmStatus makes the site status of the model our confirmed status
+func (w *worker) confirmStatus(updateModelStatusStmt *sql.Stmt, statusChange statusChange) {
+	if present(statusChange.status) {
+		w.ourOnline[statusChange.modelID] = true
+	} else {
+		delete(w.ourOnline, statusChange.modelID)
 	}
-	return
-}
-
-func (w *worker) usersForModels() (users map[string][]user, endpoints map[string][]string) {
-	users = map[string][]user{}
-	endpoints = make(map[string][]string)
+	if statusChange.status == lib.StatusIdle {
+		w.ourIdle[statusChange.modelID] = true
+	} else {
+		delete(w.ourIdle, statusChange.modelID)
+	}
+	if statusChange.status == lib.StatusPrivate {
+		w.ourPrivate[statusChange.modelID] = true
+	} else {
+		delete(w.ourPrivate, statusChange.modelID)
+	}
+	w.mustExecPrepared(updateModelStatus, updateModelStatusStmt, statusChange.modelID, statusChange.status)
+}
+
+// siteStatusConfirmed returns true if our confirmed status of the model matches its site status
+func (w *worker) siteStatusConfirmed(modelID string) bool {
+	status := w.siteStatuses[modelID].status
+	return present(status) == w.ourOnline[modelID] &&
+		(status == lib.StatusIdle) == w.ourIdle[modelID] &&
+		(status == lib.StatusPrivate) == w.ourPrivate[modelID]
+}
+
+// forceConfirm confirms the current site status of the model immediately ignoring confirmation seconds
+// and notifies the subscribers
+func (w *worker) forceConfirm(endpoint string, arguments string, now int) {
+	modelID := w.modelIDPreprocessing(arguments)
+	if !lib.ModelIDRegexp.MatchString(modelID) {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "usage: /force_confirm MODEL")
+		return
+	}
+	statusChange, found := w.siteStatuses[modelID]
+	if !found {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, fmt.Sprintf("model %s is not tracked", modelID))
+		return
+	}
+	if w.siteStatusConfirmed(modelID) {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, fmt.Sprintf("status %v of the model %s is already confirmed", statusChange.status, modelID))
+		return
+	}
+	tx, err := w.db.Begin()
+	checkErr(err)
+	updateModelStatusStmt := w.mustPrepare(tx, updateModelStatus)
+	w.confirmStatus(updateModelStatusStmt, statusChange)
+	checkErr(updateModelStatusStmt.Close())
+	checkErr(tx.Commit())
+	usersForModels, endpointsForModels := w.usersForModels()
+	notifications := w.confirmationNotifications([]string{modelID}, usersForModels, endpointsForModels, w.unsettledSubscriptions(now))
+	w.notifyOfStatuses(w.lowPriorityMsg, notifications)
+	linf("status %v of the model %s is confirmed manually", statusChange.status, modelID)
+	text := fmt.Sprintf("status %v of the model %s is confirmed, notifications: %d", statusChange.status, modelID, len(notifications))
+	w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, text)
+}
+
+// confirmInfo describes the confirmation state of a model
+func (w *worker) confirmInfo(modelID string, now int) []string {
+	statusChange, found := w.siteStatuses[modelID]
+	if !found {
+		return []string{fmt.Sprintf("model %s is not tracked", modelID)}
+	}
+	confirmationSeconds := w.confirmationSeconds(modelID, statusChange.status)
+	elapsed := now - statusChange.timestamp
+	confirmed := w.siteStatusConfirmed(modelID)
+	return []string{
+		fmt.Sprintf("site status: %v", statusChange.status),
+		fmt.Sprintf("recorded: %s UTC, %d seconds ago", time.Unix(int64(statusChange.timestamp), 0).UTC().Format("2006-01-02 15:04:05"), elapsed),
+		fmt.Sprintf("confirmation seconds: %d", confirmationSeconds),
+		fmt.Sprintf("confirmed online: %t", w.ourOnline[modelID]),
+		fmt.Sprintf("confirmed idle: %t", w.ourIdle[modelID]),
+		fmt.Sprintf("confirmed private: %t", w.ourPrivate[modelID]),
+		fmt.Sprintf("site status confirmed: %t", confirmed),
+	}
+}
+
+// loadedInfo describes the translations loaded for every endpoint and language
+func (w *worker) loadedInfo() []string {
+	describe := func(name string, files []string, tr *lib.Translations, tpl *template.Template) string {
+		status := "templates parsed"
+		if missing := lib.MissingTemplates(tr, tpl); len(missing) > 0 {
+			status = "missing templates: " + strings.Join(missing, ", ")
+		}
+		return fmt.Sprintf("%s: %s, %s", name, strings.Join(files, ", "), status)
+	}
+	var result []string
+	files := trsByEndpoint(w.cfg)
+	var endpoints []string
+	for n := range w.tr {
+		endpoints = append(endpoints, n)
+	}
+	sort.Strings(endpoints)
+	for _, n := range endpoints {
+		result = append(result, describe("endpoint "+n, files[n], w.tr[n], w.tpl[n]))
+		var langs []string
+		for lang := range w.languageTr[n] {
+			langs = append(langs, lang)
+		}
+		sort.Strings(langs)
+		for _, lang := range langs {
+			result = append(result, describe(
+				fmt.Sprintf("endpoint %s language %s", n, lang),
+				w.cfg.Endpoints[n].CommandLanguages[lang],
+				w.languageTr[n][lang],
+				w.languageTpl[n][lang]))
+		}
+	}
+	if len(result) == 0 {
+		result = append(result, "no translations loaded")
+	}
+	return result
+}
+
+// pollingInfo reports the configured poll interval and the timing of the last poll
+func (w *worker) pollingInfo(now time.Time) []string {
+	lastPoll := "never"
+	if !w.lastSuccessfulPoll.IsZero() {
+		lastPoll = fmt.Sprintf("%s, %v ago", w.lastSuccessfulPoll.UTC().Format(time.RFC3339), now.Sub(w.lastSuccessfulPoll).Truncate(time.Second))
+	}
+	return []string{
+		fmt.Sprintf("interval: %d ms", w.cfg.IntervalMs),
+		fmt.Sprintf("period: %d s", w.cfg.PeriodSeconds),
+		fmt.Sprintf("HTTP queries duration: %v", w.httpQueriesDuration),
+		fmt.Sprintf("updates duration: %v", w.updatesDuration),
+		fmt.Sprintf("last successful poll: %s", lastPoll),
+	}
+}
+
+// buildInfo describes the running build and the endpoints it serves
+func (w *worker) buildInfo() []string {
+	result := []string{
+		fmt.Sprintf("version: %s", version),
+		fmt.Sprintf("go version: %s", runtime.Version()),
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		result = append(result, fmt.Sprintf("module: %s %s %s", info.Main.Path, info.Main.Version, info.Main.Sum))
+		for _, d := range info.Deps {
+			result = append(result, fmt.Sprintf("dependency: %s %s", d.Path, d.Version))
+		}
+	} else {
+		result = append(result, "build info is not available")
+	}
+	var endpoints []string
+	for n := range w.cfg.Endpoints {
+		endpoints = append(endpoints, n)
+	}
+	sort.Strings(endpoints)
+	for _, n := range endpoints {
+		result = append(result, fmt.Sprintf("endpoint %s: bot @%s, canary: %t", n, w.botNames[n], w.cfg.Endpoints[n].Canary != nil))
+	}
+	return result
+}
+
+func (w *worker) modelsToPoll() (models []string) {
+	modelsQuery := w.mustQuery(`
+		select distinct model_id from signals
+		left join block on signals.chat_id=block.chat_id and signals.endpoint=block.endpoint
+		left join users on signals.chat_id=users.chat_id
+		where (block.block is null or block.block<?) and (users.paused is null or users.paused=0)
+		order by model_id`,
+		w.cfg.BlockThreshold)
+	defer func() { checkErr(modelsQuery.Close()) }()
+	for modelsQuery.Next() {
+		var modelID string
+		checkErr(modelsQuery.Scan(&modelID))
+		models = append(models, modelID)
+	}
+	return
+}
+
+// statusRequest returns the next request to the checker,
+// a polling checker gets all the models to poll
+func (w *worker) statusRequest() lib.StatusRequest {
+	request := lib.StatusRequest{SpecialModels: w.specialModels}
+	if w.cfg.checkerKind == lib.CheckerPolling {
+		request.Models = map[string]bool{}
+		for _, modelID := range w.modelsToPoll() {
+			request.Models[modelID] = true
+		}
+	}
+	return request
+}
+
+func (w *worker) usersForModels() (users map[string][]subscriber, endpoints map[string][]string) {
+	users = map[string][]subscriber{}
+	endpoints = make(map[string][]string)
 	chatsQuery := w.mustQuery(`
-		select signals.model_id, signals.chat_id, signals.endpoint, users.offline_notifications
+		select
+			signals.model_id, signals.chat_id, signals.endpoint, users.offline_notifications, users.snooze,
+			signals.notify_online, signals.notify_offline
 		from signals
-		join users on users.chat_id=signals.chat_id`)
+		join users on users.chat_id=signals.chat_id
+		where users.paused=0`)
 	defer func() { checkErr(chatsQuery.Close()) }()
 	for chatsQuery.Next() {
 		var modelID string
 		var chatID int64
 		var endpoint string
-		var offlineNotifications bool
-		checkErr(chatsQuery.Scan(&modelID, &chatID, &endpoint, &offlineNotifications))
-		users[modelID] = append(users[modelID], user{chatID: chatID, offlineNotifications: offlineNotifications})
+		var u subscriber
+		checkErr(chatsQuery.Scan(&modelID, &chatID, &endpoint, &u.offlineNotifications, &u.snooze, &u.notifyOnline, &u.notifyOffline))
+		u.chatID = chatID
+		users[modelID] = append(users[modelID], u)
 		endpoints[modelID] = append(endpoints[modelID], endpoint)
 	}
 	return
 }
 
+// unsettledSubscriptions returns subscriptions created during the settling period
+func (w *worker) unsettledSubscriptions(now int) map[subscription]bool {
+	result := map[subscription]bool{}
+	if w.cfg.SettlingPeriodSeconds == 0 {
+		return result
+	}
+	query := w.mustQuery("select endpoint, chat_id, model_id from signals where created_at>?", now-w.cfg.SettlingPeriodSeconds)
+	defer func() { checkErr(query.Close()) }()
+	for query.Next() {
+		var s subscription
+		checkErr(query.Scan(&s.endpoint, &s.chatID, &s.modelID))
+		result[s] = true
+	}
+	return result
+}
+
 func (w *worker) chatsForModel(modelID string) (chats []int64, endpoints []string) {
 	chatsQuery := w.mustQuery(`select chat_id, endpoint from signals where model_id=? order by chat_id`, modelID)
 	defer func() { checkErr(chatsQuery.Close()) }()
@@ -691,13 +1590,19 @@ func (w *worker) chatsForModel(modelID string) (chats []int64, endpoints []strin
 	return
 }
 
-func (w *worker) broadcastChats(endpoint string) (chats []int64) {
-	chatsQuery := w.mustQuery(`select distinct chat_id from signals where endpoint=? order by chat_id`, endpoint)
+// broadcastChats returns the chats of the endpoint including the ones served by its canary
+func (w *worker) broadcastChats(endpoint string) (chats []chat) {
+	chatsQuery := w.mustQuery(`
+		select distinct endpoint, chat_id from signals
+		where endpoint in (?, ?)
+		order by chat_id`,
+		endpoint,
+		canaryEndpoint(endpoint))
 	defer func() { checkErr(chatsQuery.Close()) }()
 	for chatsQuery.Next() {
-		var chatID int64
-		checkErr(chatsQuery.Scan(&chatID))
-		chats = append(chats, chatID)
+		var c chat
+		checkErr(chatsQuery.Scan(&c.endpoint, &c.chatID))
+		chats = append(chats, c)
 	}
 	return
 }
@@ -722,7 +1627,7 @@ func (w *worker) modelsForChat(endpoint string, chatID int64) []string {
 
 func (w *worker) statusesForChat(endpoint string, chatID int64) []model {
 	statusesQuery := w.mustQuery(`
-		select models.model_id, models.status
+		select models.model_id, models.status, signals.notify_online, signals.notify_offline
 		from models
 		join signals on signals.model_id=models.model_id
 		where signals.chat_id=? and signals.endpoint=?
@@ -732,175 +1637,1275 @@ func (w *worker) statusesForChat(endpoint string, chatID int64) []model {
 	defer func() { checkErr(statusesQuery.Close()) }()
 	var statuses []model
 	for statusesQuery.Next() {
-		var modelID string
-		var status lib.StatusKind
-		checkErr(statusesQuery.Scan(&modelID, &status))
-		statuses = append(statuses, model{modelID: modelID, status: status})
+		var m model
+		checkErr(statusesQuery.Scan(&m.modelID, &m.status, &m.notifyOnline, &m.notifyOffline))
+		statuses = append(statuses, m)
 	}
 	return statuses
 }
 
-func (w *worker) notifyOfStatuses(queue chan outgoingPacket, notifications []notification) {
-	models := map[string]bool{}
-	chats := map[int64]bool{}
+// throttleNotifications drops notifications of special models coming online or into a private show
+// notified less than their minimum interval ago, replies to commands are not throttled
+func (w *worker) throttleNotifications(notifications []notification, now int) []notification {
+	throttled := map[string]bool{}
+	notified := map[string]bool{}
+	var intervals map[string]throttling
 	for _, n := range notifications {
-		models[n.modelID] = true
-		chats[n.chatID] = true
-	}
-	images := map[string][]byte{}
-	users := map[int64]user{}
-	for m := range models {
-		if url := w.images[m]; url != "" {
-			images[m] = w.download(url)
+		if n.reply || !present(n.status) || !w.specialModels[n.modelID] || notified[n.modelID] || throttled[n.modelID] {
+			continue
 		}
-	}
-	for c := range chats {
-		users[c] = w.mustUser(c)
-	}
-	for _, n := range notifications {
-		var image []byte = nil
-		if users[n.chatID].showImages {
-			image = images[n.modelID]
+		if intervals == nil {
+			intervals = w.notificationIntervals()
 		}
-		w.notifyOfStatus(queue, n, image)
+		t, found := intervals[n.modelID]
+		if !found {
+			continue
+		}
+		if now-t.lastNotified < t.interval {
+			throttled[n.modelID] = true
+			continue
+		}
+		notified[n.modelID] = true
+		w.mustExec("update models set last_notified=? where model_id=?", now, n.modelID)
 	}
-}
-
-func (w *worker) notifyOfStatus(queue chan outgoingPacket, n notification, image []byte) {
-	if w.cfg.Debug {
-		ldbg("notifying of status of the model %s", n.modelID)
+	if len(throttled) == 0 {
+		return notifications
 	}
-	data := tplData{"model": n.modelID, "time_diff": n.timeDiff}
-	switch n.status {
-	case lib.StatusOnline:
-		if image == nil {
-			w.sendTr(queue, n.endpoint, n.chatID, true, w.tr[n.endpoint].Online, data)
-		} else {
-			w.sendTrImage(queue, n.endpoint, n.chatID, true, w.tr[n.endpoint].Online, data, image)
+	var result []notification
+	for _, n := range notifications {
+		if !n.reply && present(n.status) && throttled[n.modelID] {
+			if w.cfg.Debug {
+				ldbg("notification for the model %s is throttled", n.modelID)
+			}
+			continue
 		}
-	case lib.StatusOffline:
-		w.sendTr(queue, n.endpoint, n.chatID, false, w.tr[n.endpoint].Offline, data)
-	case lib.StatusDenied:
-		w.sendTr(queue, n.endpoint, n.chatID, false, w.tr[n.endpoint].Denied, data)
+		result = append(result, n)
 	}
-	w.mustExec("update users set reports=reports+1 where chat_id=?", n.chatID)
-}
-
-func (w *worker) subscriptionExists(endpoint string, chatID int64, modelID string) bool {
-	count := w.mustInt("select count(*) from signals where chat_id=? and model_id=? and endpoint=?", chatID, modelID, endpoint)
-	return count != 0
+	return result
 }
 
-func (w *worker) subscriptionsNumber(endpoint string, chatID int64) int {
-	return w.mustInt("select count(*) from signals where chat_id=? and endpoint=?", chatID, endpoint)
+// throttling is the notification interval of a model and the time it was last notified of
+type throttling struct {
+	interval     int
+	lastNotified int
 }
 
-func (w *worker) user(chatID int64) (user user, found bool) {
-	found = w.maybeRecord("select chat_id, max_models, reports, blacklist, show_images, offline_notifications from users where chat_id=?",
-		queryParams{chatID},
-		record{&user.chatID, &user.maxModels, &user.reports, &user.blacklist, &user.showImages, &user.offlineNotifications})
-	return
+// notificationIntervals returns the throttling of the models having notification intervals
+func (w *worker) notificationIntervals() map[string]throttling {
+	query := w.mustQuery("select model_id, notification_interval, last_notified from models where notification_interval>0")
+	defer func() { checkErr(query.Close()) }()
+	intervals := map[string]throttling{}
+	for query.Next() {
+		var modelID string
+		var t throttling
+		checkErr(query.Scan(&modelID, &t.interval, &t.lastNotified))
+		intervals[modelID] = t
+	}
+	checkErr(query.Err())
+	return intervals
 }
 
-func (w *worker) mustUser(chatID int64) (user user) {
-	user, found := w.user(chatID)
-	if !found {
-		checkErr(fmt.Errorf("user not found: %d", chatID))
+func (w *worker) dailyNotificationsLimit(u user) int {
+	if u.dailyNotifications > 0 {
+		return u.dailyNotifications
 	}
-	return
+	return w.cfg.DailyNotifications
 }
 
-func (w *worker) addUser(endpoint string, chatID int64) {
-	w.mustExec(`insert or ignore into users (chat_id, max_models) values (?, ?)`, chatID, w.cfg.MaxModels)
-	w.mustExec(`insert or ignore into emails (endpoint, chat_id, email) values (?, ?, ?)`, endpoint, chatID, uuid.New())
+// limitNotifications drops notifications exceeding daily limits of users,
+// replies to commands neither count nor are limited,
+// it returns the endpoints of the chats which have just reached their limits
+func (w *worker) limitNotifications(notifications []notification, now int) ([]notification, map[int64]string) {
+	w.mustExec("delete from sent_notifications where timestamp<=?", now-24*60*60)
+	limits := map[int64]int{}
+	counts := map[int64]int{}
+	reached := map[int64]string{}
+	var result []notification
+	for _, n := range notifications {
+		if n.reply {
+			result = append(result, n)
+			continue
+		}
+		limit, ok := limits[n.chatID]
+		if !ok {
+			limit = w.dailyNotificationsLimit(w.mustUser(n.chatID))
+			limits[n.chatID] = limit
+			if limit > 0 {
+				counts[n.chatID] = w.mustInt("select count(*) from sent_notifications where chat_id=?", n.chatID)
+			}
+		}
+		if limit == 0 {
+			result = append(result, n)
+			continue
+		}
+		if counts[n.chatID] >= limit {
+			if w.cfg.Debug {
+				ldbg("notification for the chat %d is over the daily limit", n.chatID)
+			}
+			continue
+		}
+		counts[n.chatID]++
+		w.mustExec("insert into sent_notifications (chat_id, timestamp) values (?,?)", n.chatID, now)
+		if counts[n.chatID] == limit {
+			reached[n.chatID] = n.endpoint
+		}
+		result = append(result, n)
+	}
+	return result, reached
 }
 
-func (w *worker) showWeek(endpoint string, chatID int64, modelID string) {
-	if modelID != "" {
-		w.showWeekForModel(endpoint, chatID, modelID)
-		return
+// stageNotifications spreads online notifications of popular models across the configured window,
+// a new status change of a model drops its staged notifications,
+// it returns the notifications to send immediately
+func (w *worker) stageNotifications(notifications []notification, now time.Time) []notification {
+	if w.cfg.SpreadSubscribersThreshold == 0 {
+		return notifications
 	}
-	models := w.modelsForChat(endpoint, chatID)
-	for _, m := range models {
-		w.showWeekForModel(endpoint, chatID, m)
+	changed := map[string]bool{}
+	counts := map[string]int{}
+	for _, n := range notifications {
+		if n.kind != statusNotification {
+			continue
+		}
+		changed[n.modelID] = true
+		if n.status == lib.StatusOnline {
+			counts[n.modelID]++
+		}
 	}
-	if len(models) == 0 {
-		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].ZeroSubscriptions, nil)
+	w.dropStaged(changed)
+	tx, err := w.db.Begin()
+	checkErr(err)
+	insertStaged := "insert into staged_notifications (endpoint, chat_id, model_id, status, release_at) values (?,?,?,?,?)"
+	insert := w.mustPrepare(tx, insertStaged)
+	positions := map[string]int{}
+	var immediate []notification
+	for _, n := range notifications {
+		count := counts[n.modelID]
+		if n.kind != statusNotification || n.status != lib.StatusOnline || count < w.cfg.SpreadSubscribersThreshold {
+			immediate = append(immediate, n)
+			continue
+		}
+		release := int(now.Unix()) + w.cfg.SpreadWindowSeconds*positions[n.modelID]/count
+		positions[n.modelID]++
+		w.mustExecPrepared(insertStaged, insert, n.endpoint, n.chatID, n.modelID, n.status, release)
 	}
+	checkErr(insert.Close())
+	checkErr(tx.Commit())
+	return immediate
+}
 
+// dropStaged drops the staged notifications of the models
+func (w *worker) dropStaged(models map[string]bool) {
+	query := w.mustQuery("select distinct model_id from staged_notifications")
+	var staged []string
+	for query.Next() {
+		var modelID string
+		checkErr(query.Scan(&modelID))
+		if models[modelID] {
+			staged = append(staged, modelID)
+		}
+	}
+	checkErr(query.Err())
+	checkErr(query.Close())
+	for _, modelID := range staged {
+		w.mustExec("delete from staged_notifications where model_id=?", modelID)
+	}
 }
 
-func (w *worker) showWeekForModel(endpoint string, chatID int64, modelID string) {
-	modelID = w.modelIDPreprocessing(modelID)
-	if !lib.ModelIDRegexp.MatchString(modelID) {
-		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].InvalidSymbols, tplData{"model": modelID})
-		return
+// releaseStaged returns staged notifications which are due,
+// the notifications not matching the current model status are dropped
+func (w *worker) releaseStaged(now time.Time) []notification {
+	query := w.mustQuery("select endpoint, chat_id, model_id, status from staged_notifications where release_at<=? order by release_at, id", now.Unix())
+	var released []notification
+	for query.Next() {
+		n := notification{kind: statusNotification}
+		checkErr(query.Scan(&n.endpoint, &n.chatID, &n.modelID, &n.status))
+		if present(n.status) != w.ourOnline[n.modelID] {
+			continue
+		}
+		n.timeDiff = w.modelTimeDiff(n.modelID, int(now.Unix()))
+		released = append(released, n)
 	}
-	hours, start := w.week(modelID)
-	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].Week, tplData{
-		"hours":   hours,
-		"weekday": int(start.UTC().Weekday()),
-		"model":   modelID,
-	})
+	checkErr(query.Err())
+	checkErr(query.Close())
+	w.mustExec("delete from staged_notifications where release_at<=?", now.Unix())
+	return released
 }
 
-func (w *worker) addModel(endpoint string, chatID int64, modelID string, now int) bool {
-	if modelID == "" {
-		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].SyntaxAdd, nil)
-		return false
+// dedupNotifications leaves only the first notification of each kind for a subscription
+func dedupNotifications(notifications []notification) []notification {
+	type key struct {
+		subscription
+		kind notificationKind
 	}
-	modelID = w.modelIDPreprocessing(modelID)
-	if !lib.ModelIDRegexp.MatchString(modelID) {
-		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].InvalidSymbols, tplData{"model": modelID})
-		return false
+	seen := map[key]bool{}
+	var result []notification
+	for _, n := range notifications {
+		k := key{subscription{endpoint: n.endpoint, chatID: n.chatID, modelID: n.modelID}, n.kind}
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		result = append(result, n)
 	}
+	return result
+}
 
-	if w.subscriptionExists(endpoint, chatID, modelID) {
-		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].AlreadyAdded, tplData{"model": modelID})
-		return false
+func (w *worker) notifyOfStatuses(queue chan outgoingPacket, notifications []notification) {
+	now := int(time.Now().Unix())
+	users := map[int64]user{}
+	for _, n := range notifications {
+		if _, ok := users[n.chatID]; !ok {
+			users[n.chatID] = w.mustUser(n.chatID)
+		}
 	}
-	subscriptionsNumber := w.subscriptionsNumber(endpoint, chatID)
-	user := w.mustUser(chatID)
-	if subscriptionsNumber >= user.maxModels {
-		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].NotEnoughSubscriptions, nil)
-		w.subscriptionUsage(endpoint, chatID, true)
-		return false
+	notifications = skipMuted(notifications, users, now)
+	notifications = w.holdQuiet(notifications, users, now)
+	notifications = dedupNotifications(notifications)
+	notifications = w.throttleNotifications(notifications, now)
+	notifications, reached := w.limitNotifications(notifications, now)
+	models := map[string]bool{}
+	for _, n := range notifications {
+		if !users[n.chatID].imageLinks {
+			models[n.modelID] = true
+		}
 	}
-	var confirmedStatus lib.StatusKind
-	if w.ourOnline[modelID] {
-		confirmedStatus = lib.StatusOnline
-	} else if _, ok := w.siteStatuses[modelID]; ok {
-		confirmedStatus = lib.StatusOffline
-	} else {
-		checkedStatus := w.checkModel(w.clients[0], modelID, w.cfg.Headers, w.cfg.Debug, w.cfg.SpecificConfig)
-		if checkedStatus == lib.StatusUnknown || checkedStatus == lib.StatusNotFound {
-			w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].AddError, tplData{"model": modelID})
-			return false
+	images := map[string][]byte{}
+	for m := range models {
+		images[m] = w.notificationImage(m, now)
+	}
+	sessions := map[string]*timeDiff{}
+	for _, n := range notifications {
+		if _, ok := sessions[n.modelID]; !ok && n.kind == statusNotification && n.status == lib.StatusOffline {
+			sessions[n.modelID] = w.sessionDuration(n.modelID, now)
 		}
-		confirmedStatus = lib.StatusOffline
 	}
-	w.mustExec("insert into signals (chat_id, model_id, endpoint) values (?,?,?)", chatID, modelID, endpoint)
-	w.mustExec("insert or ignore into models (model_id, status) values (?,?)", modelID, confirmedStatus)
-	subscriptionsNumber++
-	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].ModelAdded, tplData{"model": modelID})
-	w.notifyOfStatuses(w.highPriorityMsg, []notification{{
-		endpoint: endpoint,
-		chatID:   chatID,
-		modelID:  modelID,
-		status:   confirmedStatus,
-		timeDiff: w.modelTimeDiff(modelID, now)}})
-	if subscriptionsNumber >= user.maxModels-w.cfg.HeavyUserRemainder {
-		w.subscriptionUsage(endpoint, chatID, true)
+	var aliases map[subscription]string
+	if len(notifications) > 0 {
+		aliases = w.aliases()
+	}
+	imagesSent := map[int64]int{}
+	for _, n := range notifications {
+		if n.status == lib.StatusOffline {
+			n.sessionDuration = sessions[n.modelID]
+		}
+		n.alias = aliases[subscription{endpoint: n.endpoint, chatID: n.chatID, modelID: n.modelID}]
+		var image []byte = nil
+		if users[n.chatID].showImages && !imageLimitReached(users[n.chatID], imagesSent[n.chatID]) {
+			if users[n.chatID].imageLinks {
+				n.imageLink = w.previewLink(n.modelID) != ""
+			} else {
+				image = images[n.modelID]
+			}
+			if (image != nil || n.imageLink) && n.status == lib.StatusOnline {
+				imagesSent[n.chatID]++
+			}
+		}
+		w.notifyOfStatus(queue, n, image)
+	}
+	for chatID, endpoint := range reached {
+		data := tplData{"limit": w.dailyNotificationsLimit(users[chatID])}
+		w.sendTr(queue, endpoint, chatID, false, w.tr[endpoint].DailyNotificationsReached, data)
 	}
-	return true
 }
 
-func (w *worker) subscriptionUsage(endpoint string, chatID int64, ad bool) {
-	subscriptionsNumber := w.subscriptionsNumber(endpoint, chatID)
-	user := w.mustUser(chatID)
-	tr := w.tr[endpoint].SubscriptionUsage
-	if ad {
-		tr = w.tr[endpoint].SubscriptionUsageAd
+// imageLimitReached returns true if the user has received the maximum number of images in the current update
+func imageLimitReached(user user, imagesSent int) bool {
+	return user.imageLimit > 0 && imagesSent >= user.imageLimit
+}
+
+// quiet returns true if the user is inside quiet hours,
+// quiet hours are disabled if they start and end at the same hour
+func (w *worker) quiet(user user, now int) bool {
+	if user.quietFrom == user.quietTo {
+		return false
+	}
+	hour := time.Unix(int64(now), 0).In(w.userLocation(user)).Hour()
+	if user.quietFrom < user.quietTo {
+		return hour >= user.quietFrom && hour < user.quietTo
+	}
+	return hour >= user.quietFrom || hour < user.quietTo
+}
+
+// holdQuiet stores status notifications for the chats inside quiet hours
+// and returns the notifications to send now, replies to commands are never held
+func (w *worker) holdQuiet(notifications []notification, users map[int64]user, now int) []notification {
+	var result []notification
+	for _, n := range notifications {
+		if n.kind != statusNotification || n.reply || !w.quiet(users[n.chatID], now) {
+			result = append(result, n)
+			continue
+		}
+		w.mustExec("insert into held_notifications (endpoint, chat_id, model_id, status, timestamp) values (?,?,?,?,?)",
+			n.endpoint, n.chatID, n.modelID, n.status, now)
+	}
+	return result
+}
+
+// releaseHeld returns the latest held notifications of the chats whose quiet hours are over,
+// the notifications not matching the current model status are dropped
+func (w *worker) releaseHeld(now int) []notification {
+	query := w.mustQuery("select endpoint, chat_id, model_id, status from held_notifications order by timestamp, id")
+	defer func() { checkErr(query.Close()) }()
+	var held []notification
+	for query.Next() {
+		n := notification{kind: statusNotification}
+		checkErr(query.Scan(&n.endpoint, &n.chatID, &n.modelID, &n.status))
+		held = append(held, n)
+	}
+	users := map[int64]user{}
+	latest := map[subscription]notification{}
+	var order []subscription
+	for _, n := range held {
+		if _, ok := users[n.chatID]; !ok {
+			users[n.chatID] = w.mustUser(n.chatID)
+		}
+		if w.quiet(users[n.chatID], now) {
+			continue
+		}
+		s := subscription{endpoint: n.endpoint, chatID: n.chatID, modelID: n.modelID}
+		if _, ok := latest[s]; !ok {
+			order = append(order, s)
+		}
+		latest[s] = n
+	}
+	var result []notification
+	for _, s := range order {
+		w.mustExec("delete from held_notifications where endpoint=? and chat_id=? and model_id=?", s.endpoint, s.chatID, s.modelID)
+		n := latest[s]
+		// the models the bot has never seen are offline
+		current, _ := w.knownStatus(n.modelID)
+		if current == lib.StatusUnknown {
+			current = lib.StatusOffline
+		}
+		if n.status != current && n.status != lib.StatusDenied {
+			continue
+		}
+		n.timeDiff = w.modelTimeDiff(n.modelID, now)
+		result = append(result, n)
+	}
+	return result
+}
+
+func (w *worker) setQuietHours(endpoint string, chatID int64, arguments string) {
+	if arguments == "off" {
+		w.mustExec("update users set quiet_from=0, quiet_to=0 where chat_id=?", chatID)
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].OK, nil)
+		return
+	}
+	parts := strings.Fields(arguments)
+	if len(parts) != 2 {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].SyntaxQuietHours, nil)
+		return
+	}
+	from, errFrom := strconv.Atoi(parts[0])
+	to, errTo := strconv.Atoi(parts[1])
+	if errFrom != nil || errTo != nil || from < 0 || from > 23 || to < 0 || to > 23 || from == to {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].SyntaxQuietHours, nil)
+		return
+	}
+	w.mustExec("update users set quiet_from=?, quiet_to=? where chat_id=?", from, to, chatID)
+	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].OK, nil)
+}
+
+// setLanguage stores the translation preferred by the user,
+// available translations are listed if the language is unknown
+func (w *worker) setLanguage(endpoint string, chatID int64, arguments string) {
+	langs := w.languageTpl[baseEndpoint(endpoint)]
+	if arguments == "off" {
+		w.mustExec("update users set language='' where chat_id=?", chatID)
+		delete(w.languages, chatID)
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].OK, nil)
+		return
+	}
+	if _, found := langs[arguments]; !found {
+		var codes []string
+		for lang := range langs {
+			codes = append(codes, lang)
+		}
+		sort.Strings(codes)
+		current := w.languages[chatID]
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].AvailableLanguages, tplData{"languages": codes, "current": current})
+		return
+	}
+	w.mustExec("update users set language=? where chat_id=?", arguments, chatID)
+	w.languages[chatID] = arguments
+	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].OK, nil)
+}
+
+// userLocation returns the timezone of the user,
+// the default location is used if the user has not set one
+func (w *worker) userLocation(user user) *time.Location {
+	if user.timezone != "" {
+		if location, err := time.LoadLocation(user.timezone); err == nil {
+			return location
+		}
+	}
+	if w.location != nil {
+		return w.location
+	}
+	return time.UTC
+}
+
+// setTimezone stores the IANA timezone of the user
+func (w *worker) setTimezone(endpoint string, chatID int64, arguments string) {
+	if arguments == "" || arguments == "Local" {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].SyntaxTimezone, nil)
+		return
+	}
+	if arguments == "off" {
+		w.mustExec("update users set timezone='' where chat_id=?", chatID)
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].OK, nil)
+		return
+	}
+	location, err := time.LoadLocation(arguments)
+	if err != nil {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].UnknownTimezone, tplData{"timezone": arguments})
+		return
+	}
+	w.mustExec("update users set timezone=? where chat_id=?", location.String(), chatID)
+	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].OK, nil)
+}
+
+// skipMuted drops notifications for muted chats except replies to commands
+func skipMuted(notifications []notification, users map[int64]user, now int) []notification {
+	var result []notification
+	for _, n := range notifications {
+		if n.reply || !users[n.chatID].muted(now) {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+// timeOfDay returns the name of the online notification variant for the time
+func (w *worker) timeOfDay(now time.Time) string {
+	location := w.location
+	if location == nil {
+		location = time.UTC
+	}
+	hour := now.In(location).Hour()
+	for _, v := range w.cfg.OnlineVariants {
+		if v.FromHour <= v.ToHour && hour >= v.FromHour && hour < v.ToHour {
+			return v.Name
+		}
+		if v.FromHour > v.ToHour && (hour >= v.FromHour || hour < v.ToHour) {
+			return v.Name
+		}
+	}
+	return ""
+}
+
+func (w *worker) notifyOfStatus(queue chan outgoingPacket, n notification, image []byte) {
+	if w.cfg.Debug {
+		ldbg("notifying of status of the model %s", n.modelID)
+	}
+	w.sendNotification(queue, n, image)
+	w.mustExec("update users set reports=reports+1 where chat_id=?", n.chatID)
+}
+
+// sendNotification renders a notification and sends it to the chat
+func (w *worker) sendNotification(queue chan outgoingPacket, n notification, image []byte) {
+	if n.kind == displayNameNotification {
+		data := tplData{
+			"model":    n.modelID,
+			"alias":    n.alias,
+			"old_name": n.displayName[0],
+			"new_name": n.displayName[1],
+		}
+		w.sendTr(queue, n.endpoint, n.chatID, false, w.tr[n.endpoint].DisplayNameChanged, data)
+		return
+	}
+	data := tplData{
+		"model":            n.modelID,
+		"alias":            n.alias,
+		"time_diff":        n.timeDiff,
+		"session_duration": n.sessionDuration,
+	}
+	switch n.status {
+	case lib.StatusOnline:
+		data["time_of_day"] = w.timeOfDay(time.Now())
+		data["image_url"] = w.imageLink(n.modelID)
+		if n.imageLink {
+			data["image_url"] = w.previewLink(n.modelID)
+			data["image_preview"] = true
+			tr := w.chatTranslation(n.endpoint, n.chatID, w.tr[n.endpoint].Online)
+			text := templateToString(w.chatTemplates(n.endpoint, n.chatID), tr.Key, data)
+			w.sendText(queue, n.endpoint, n.chatID, true, false, tr.Parse, text)
+		} else if image == nil {
+			w.sendTr(queue, n.endpoint, n.chatID, true, w.tr[n.endpoint].Online, data)
+		} else {
+			w.sendTrImage(queue, n.endpoint, n.chatID, true, w.tr[n.endpoint].Online, data, image)
+		}
+	case lib.StatusOffline:
+		w.sendTr(queue, n.endpoint, n.chatID, false, w.tr[n.endpoint].Offline, data)
+	case lib.StatusDenied:
+		w.sendTr(queue, n.endpoint, n.chatID, false, w.tr[n.endpoint].Denied, data)
+	case lib.StatusIdle:
+		w.sendTr(queue, n.endpoint, n.chatID, false, w.tr[n.endpoint].Idle, data)
+	case lib.StatusPrivate:
+		w.sendTr(queue, n.endpoint, n.chatID, false, w.tr[n.endpoint].Private, data)
+	}
+}
+
+// simulate sends a notification of the specified status of a model to admin
+// leaving the database intact
+func (w *worker) simulate(endpoint string, arguments string, now int) {
+	parts := strings.Fields(arguments)
+	if len(parts) != 2 {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "usage: simulate MODEL online|offline|idle|private|denied")
+		return
+	}
+	modelID := w.modelIDPreprocessing(parts[0])
+	if !lib.ModelIDRegexp.MatchString(modelID) {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "model ID is invalid")
+		return
+	}
+	var status lib.StatusKind
+	for _, s := range []lib.StatusKind{lib.StatusOnline, lib.StatusOffline, lib.StatusIdle, lib.StatusPrivate, lib.StatusDenied} {
+		if s.String() == parts[1] {
+			status = s
+		}
+	}
+	if status == lib.StatusUnknown {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "usage: simulate MODEL online|offline|idle|private|denied")
+		return
+	}
+	n := notification{
+		kind:     statusNotification,
+		endpoint: endpoint,
+		chatID:   w.cfg.AdminID,
+		modelID:  modelID,
+		status:   status,
+		timeDiff: w.modelTimeDiff(modelID, now),
+		alias:    w.alias(endpoint, w.cfg.AdminID, modelID),
+	}
+	if status == lib.StatusOffline {
+		n.sessionDuration = w.sessionDuration(modelID, now)
+	}
+	var image []byte
+	if status == lib.StatusOnline {
+		image = w.modelImage(modelID)
+	}
+	w.sendNotification(w.highPriorityMsg, n, image)
+}
+
+func (w *worker) subscriptionExists(endpoint string, chatID int64, modelID string) bool {
+	count := w.mustInt("select count(*) from signals where chat_id=? and model_id=? and endpoint=?", chatID, modelID, endpoint)
+	return count != 0
+}
+
+func (w *worker) subscriptionsNumber(endpoint string, chatID int64) int {
+	return w.mustInt("select count(*) from signals where chat_id=? and endpoint=?", chatID, endpoint)
+}
+
+func (w *worker) user(chatID int64) (user user, found bool) {
+	found = w.maybeRecord(`
+		select
+			chat_id, max_models, reports, blacklist, show_images, offline_notifications, snooze, resume_timestamp, daily_notifications,
+			mute_until, image_limit, quiet_from, quiet_to, timezone, min_online_minutes, paused, image_links
+		from users where chat_id=?`,
+		queryParams{chatID},
+		record{
+			&user.chatID,
+			&user.maxModels,
+			&user.reports,
+			&user.blacklist,
+			&user.showImages,
+			&user.offlineNotifications,
+			&user.snooze,
+			&user.resumeTimestamp,
+			&user.dailyNotifications,
+			&user.muteUntil,
+			&user.imageLimit,
+			&user.quietFrom,
+			&user.quietTo,
+			&user.timezone,
+			&user.minOnlineMinutes,
+			&user.paused,
+			&user.imageLinks,
+		})
+	return
+}
+
+func (w *worker) mustUser(chatID int64) (user user) {
+	user, found := w.user(chatID)
+	if !found {
+		checkErr(fmt.Errorf("user not found: %d", chatID))
+	}
+	return
+}
+
+func (w *worker) addUser(endpoint string, chatID int64) {
+	w.mustExec(`insert or ignore into users (chat_id, max_models) values (?, ?)`, chatID, w.cfg.MaxModels)
+	w.mustExec(`insert or ignore into emails (endpoint, chat_id, email) values (?, ?, ?)`, endpoint, chatID, uuid.New())
+}
+
+func (w *worker) showWeek(endpoint string, chatID int64, modelID string) {
+	if modelID != "" {
+		w.showWeekForModel(endpoint, chatID, modelID)
+		return
+	}
+	models := w.modelsForChat(endpoint, chatID)
+	for _, m := range models {
+		w.showWeekForModel(endpoint, chatID, m)
+	}
+	if len(models) == 0 {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].ZeroSubscriptions, nil)
+	}
+
+}
+
+func (w *worker) showWeekForModel(endpoint string, chatID int64, modelID string) {
+	modelID = w.modelIDPreprocessing(modelID)
+	if !lib.ModelIDRegexp.MatchString(modelID) {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].InvalidSymbols, tplData{"model": modelID})
+		return
+	}
+	user, _ := w.user(chatID)
+	location := w.userLocation(user)
+	hours, start := w.week(modelID, location)
+	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].Week, tplData{
+		"hours":    hours,
+		"weekday":  int(start.Weekday()),
+		"model":    modelID,
+		"alias":    w.alias(endpoint, chatID, modelID),
+		"timezone": location.String(),
+	})
+}
+
+func (w *worker) wasOnline(endpoint string, chatID int64, arguments string, now time.Time) {
+	parts := strings.Fields(arguments)
+	if len(parts) != 2 {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].SyntaxWasOnline, nil)
+		return
+	}
+	modelID := w.modelIDPreprocessing(parts[0])
+	if !lib.ModelIDRegexp.MatchString(modelID) {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].InvalidSymbols, tplData{"model": modelID})
+		return
+	}
+	user, _ := w.user(chatID)
+	location := w.userLocation(user)
+	day, err := time.ParseInLocation("2006-01-02", parts[1], location)
+	if err != nil || day.After(now) {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].SyntaxWasOnline, nil)
+		return
+	}
+	end := day.AddDate(0, 0, 1)
+	if end.After(now) {
+		end = now
+	}
+	hours := make([]bool, 24)
+	copy(hours, w.onlineHours(modelID, int(day.Unix()), int(end.Unix())))
+	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].WasOnline, tplData{
+		"hours":    hours,
+		"date":     day.Format("2006-01-02"),
+		"model":    modelID,
+		"timezone": location.String(),
+	})
+}
+
+func (w *worker) addModel(endpoint string, chatID int64, modelID string, now int) bool {
+	if modelID == "" {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].SyntaxAdd, nil)
+		return false
+	}
+	modelID = w.modelIDPreprocessing(modelID)
+	added, confirmedStatus := w.subscribe(endpoint, chatID, modelID, now)
+	switch added {
+	case modelInvalid:
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].InvalidSymbols, tplData{"model": modelID})
+		return false
+	case modelAlreadyAdded:
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].AlreadyAdded, tplData{"model": modelID})
+		return false
+	case modelLimitReached:
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].NotEnoughSubscriptions, nil)
+		w.subscriptionUsage(endpoint, chatID, true)
+		return false
+	case modelNotFound:
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].ModelNotFound, tplData{"model": modelID})
+		return false
+	case modelCheckFailed:
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].CheckFailed, tplData{"model": modelID})
+		return false
+	case modelAddError:
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].AddError, tplData{"model": modelID})
+		return false
+	}
+	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].ModelAdded, tplData{"model": modelID})
+	w.notifyOfStatuses(w.highPriorityMsg, []notification{{
+		endpoint: endpoint,
+		chatID:   chatID,
+		modelID:  modelID,
+		status:   confirmedStatus,
+		timeDiff: w.modelTimeDiff(modelID, now),
+		reply:    true}})
+	if w.subscriptionsNumber(endpoint, chatID) >= w.mustUser(chatID).maxModels-w.cfg.HeavyUserRemainder {
+		w.subscriptionUsage(endpoint, chatID, true)
+	}
+	return true
+}
+
+// addModels subscribes a chat to several models at once and replies with a single summary,
+// it stops when the subscription limit is reached and reports the rest as skipped
+func (w *worker) addModels(endpoint string, chatID int64, models []string, now int) {
+	if len(models) > maxAddedModels {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].TooManyModels, tplData{"max_models": maxAddedModels})
+		return
+	}
+	request := addRequest{chat: chat{endpoint: endpoint, chatID: chatID}, lines: models}
+	for _, m := range models {
+		request.models = append(request.models, w.modelIDPreprocessing(m))
+	}
+	w.startAdding(request, now)
+}
+
+// subscribe validates a preprocessed model ID, checks the model if it is not known yet
+// and subscribes the chat to it
+func (w *worker) subscribe(endpoint string, chatID int64, modelID string, now int) (addKind, lib.StatusKind) {
+	return w.subscribeChecked(endpoint, chatID, modelID, func() (checkedModel, bool) {
+		status, err := w.checkModel(w.clients[0], modelID, w.headers.Headers(), w.cfg.Debug, w.cfg.SpecificConfig)
+		return checkedModel{status: status, err: err}, true
+	}, now)
+}
+
+// knownStatus returns the status of a model known to the bot
+func (w *worker) knownStatus(modelID string) (lib.StatusKind, bool) {
+	switch {
+	case w.ourIdle[modelID]:
+		return lib.StatusIdle, true
+	case w.ourPrivate[modelID]:
+		return lib.StatusPrivate, true
+	case w.ourOnline[modelID]:
+		return lib.StatusOnline, true
+	}
+	if _, ok := w.siteStatuses[modelID]; ok {
+		return lib.StatusOffline, true
+	}
+	return lib.StatusUnknown, false
+}
+
+// subscribeChecked subscribes the chat to a model like subscribe,
+// the check function returns the check result of a model unknown to the bot or false if it was not checked
+func (w *worker) subscribeChecked(endpoint string, chatID int64, modelID string, check func() (checkedModel, bool), now int) (addKind, lib.StatusKind) {
+	if !lib.ModelIDRegexp.MatchString(modelID) {
+		return modelInvalid, lib.StatusUnknown
+	}
+	if w.subscriptionExists(endpoint, chatID, modelID) {
+		return modelAlreadyAdded, lib.StatusUnknown
+	}
+	if w.subscriptionsNumber(endpoint, chatID) >= w.mustUser(chatID).maxModels {
+		return modelLimitReached, lib.StatusUnknown
+	}
+	confirmedStatus, known := w.knownStatus(modelID)
+	if !known {
+		checked, ok := check()
+		if !ok {
+			return modelNotChecked, lib.StatusUnknown
+		}
+		var checkError *lib.CheckError
+		var unknownStatus *lib.UnknownStatusError
+		if errors.As(checked.err, &unknownStatus) {
+			w.reportUnknownStatus(unknownStatus.Raw, time.Unix(int64(now), 0))
+		}
+		switch {
+		case checked.status == lib.StatusNotFound:
+			return modelNotFound, checked.status
+		case errors.As(checked.err, &checkError) && checkError.Kind == lib.CheckNetworkError:
+			return modelCheckFailed, checked.status
+		case checked.status == lib.StatusUnknown:
+			return modelAddError, checked.status
+		}
+		confirmedStatus = lib.StatusOffline
+	}
+	w.mustExec("insert into signals (chat_id, model_id, endpoint, created_at) values (?,?,?,?)", chatID, modelID, endpoint, now)
+	w.mustExec("insert or ignore into models (model_id, status) values (?,?)", modelID, confirmedStatus)
+	return modelAdded, confirmedStatus
+}
+
+func (w *worker) watch(endpoint string, chatID int64, modelID string) {
+	if modelID == "" {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].SyntaxWatch, nil)
+		return
+	}
+	modelID = w.modelIDPreprocessing(modelID)
+	if !lib.ModelIDRegexp.MatchString(modelID) {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].InvalidSymbols, tplData{"model": modelID})
+		return
+	}
+	for s := range w.watches {
+		if s.endpoint == endpoint && s.chatID == chatID {
+			w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].AlreadyWatching, tplData{"model": s.modelID})
+			return
+		}
+	}
+	if len(w.watches) >= w.cfg.MaxWatches {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].TooManyWatches, nil)
+		return
+	}
+	s := subscription{endpoint: endpoint, chatID: chatID, modelID: modelID}
+	w.watches[s] = lib.StatusUnknown
+	deadline := time.Now().Add(time.Duration(w.cfg.WatchMinutes) * time.Minute)
+	go w.watchModel(s, deadline)
+	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].Watching, tplData{"model": modelID, "minutes": w.cfg.WatchMinutes})
+}
+
+// watchModel checks a single model every watch period until the deadline,
+// every status is sent to watchResults and a done result follows the last one
+func (w *worker) watchModel(s subscription, deadline time.Time) {
+	checkModel := w.retryingChecker()
+	for time.Now().Before(deadline) {
+		status, _ := checkModel(w.clients[0], s.modelID, w.headers.Headers(), w.cfg.Debug, w.cfg.SpecificConfig)
+		w.watchResults <- watchResult{subscription: s, status: status}
+		time.Sleep(time.Duration(w.cfg.WatchPeriodSeconds) * time.Second)
+	}
+	w.watchResults <- watchResult{subscription: s, done: true}
+}
+
+func (w *worker) processWatchResult(r watchResult, now int) {
+	if r.done {
+		delete(w.watches, r.subscription)
+		w.sendTr(w.highPriorityMsg, r.subscription.endpoint, r.subscription.chatID, false, w.tr[r.subscription.endpoint].WatchFinished, tplData{"model": r.subscription.modelID})
+		return
+	}
+	if r.status == lib.StatusPrivate && !w.cfg.PrivateStatus || r.status == lib.StatusIdle && !w.cfg.IdleStatus {
+		r.status = lib.StatusOnline
+	}
+	if r.status != lib.StatusOnline && r.status != lib.StatusOffline && r.status != lib.StatusIdle && r.status != lib.StatusPrivate {
+		return
+	}
+	prev := w.watches[r.subscription]
+	w.watches[r.subscription] = r.status
+	if prev == lib.StatusUnknown || prev == r.status {
+		return
+	}
+	w.notifyOfStatuses(w.highPriorityMsg, []notification{{
+		endpoint: r.subscription.endpoint,
+		chatID:   r.subscription.chatID,
+		modelID:  r.subscription.modelID,
+		status:   r.status,
+		timeDiff: w.modelTimeDiff(r.subscription.modelID, now)}})
+}
+
+var checkListSeparators = regexp.MustCompile(`[\s,]+`)
+
+// parseCheckList splits a list of models separated by commas or whitespace
+func parseCheckList(text string) (models []string) {
+	seen := map[string]bool{}
+	for _, m := range checkListSeparators.Split(text, -1) {
+		if m != "" && !seen[m] {
+			seen[m] = true
+			models = append(models, m)
+		}
+	}
+	return
+}
+
+func (w *worker) checkList(endpoint string, chatID int64, arguments string) {
+	models := parseCheckList(arguments)
+	if len(models) == 0 {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].SyntaxCheckList, tplData{"max_check_list": w.cfg.MaxCheckList})
+		return
+	}
+	if len(models) > w.cfg.MaxCheckList {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].CheckListTooLong, tplData{"max_check_list": w.cfg.MaxCheckList})
+		return
+	}
+	c := chat{endpoint: endpoint, chatID: chatID}
+	if w.checkLists[c] {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].CheckListInProgress, nil)
+		return
+	}
+	w.checkLists[c] = true
+	for i := range models {
+		models[i] = w.modelIDPreprocessing(models[i])
+	}
+	go w.checkModels(c, models)
+	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].CheckListStarted, tplData{"count": len(models)})
+}
+
+// search finds online models containing the substring in the cache of online models,
+// a chat cannot search more often than once per search interval
+func (w *worker) search(endpoint string, chatID int64, arguments string, now int) {
+	query := strings.ToLower(strings.TrimSpace(arguments))
+	if query == "" {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].SyntaxSearch, nil)
+		return
+	}
+	c := chat{endpoint: endpoint, chatID: chatID}
+	if last, ok := w.lastSearches[c]; ok && now-last < w.cfg.SearchIntervalSeconds {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].SearchTooOften, tplData{"seconds": w.cfg.SearchIntervalSeconds - now + last})
+		return
+	}
+	w.lastSearches[c] = now
+	var found []string
+	for modelID := range w.siteOnline {
+		if strings.Contains(strings.ToLower(modelID), query) {
+			found = append(found, modelID)
+		}
+	}
+	if len(found) == 0 {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].NoSearchResults, nil)
+		return
+	}
+	sort.Strings(found)
+	more := len(found) > w.cfg.MaxSearchResults
+	if more {
+		found = found[:w.cfg.MaxSearchResults]
+	}
+	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].SearchResults, tplData{"models": found, "more": more})
+}
+
+// checkModels checks the models of a /check list one by one respecting the query interval,
+// invalid model IDs are not queried, all statuses are sent to checkListResults at once
+func (w *worker) checkModels(c chat, models []string) {
+	checkModel := w.retryingChecker()
+	var checks []modelCheck
+	for i, m := range models {
+		if !lib.ModelIDRegexp.MatchString(m) {
+			checks = append(checks, modelCheck{Model: m, Status: "invalid"})
+			continue
+		}
+		if i > 0 {
+			time.Sleep(time.Duration(w.cfg.IntervalMs) * time.Millisecond)
+		}
+		status, _ := checkModel(w.clients[i%len(w.clients)], m, w.headers.Headers(), w.cfg.Debug, w.cfg.SpecificConfig)
+		checks = append(checks, modelCheck{Model: m, Status: status.String()})
+	}
+	w.checkListResults <- checkListResult{chat: c, checks: checks}
+}
+
+// startAudit starts checking all the polled models to find the ones not existing on the website anymore
+func (w *worker) startAudit(endpoint string, now time.Time) {
+	if w.auditInProgress {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "audit is already in progress")
+		return
+	}
+	w.auditInProgress = true
+	models := w.modelsToPoll()
+	deadline := now.Add(time.Duration(w.cfg.AuditMaxMinutes) * time.Minute)
+	go w.auditModels(endpoint, models, deadline)
+	text := fmt.Sprintf("audit of %d models started, workers: %d, time limit: %d minutes", len(models), w.cfg.AuditWorkers, w.cfg.AuditMaxMinutes)
+	w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, text)
+}
+
+// auditModels checks the models by audit_workers workers respecting the query interval,
+// it stops at the deadline and sends the models not found to auditResults
+func (w *worker) auditModels(endpoint string, models []string, deadline time.Time) {
+	type check struct {
+		modelID string
+		status  lib.StatusKind
+	}
+	checkModel := w.retryingChecker()
+	jobs := make(chan string)
+	checks := make(chan check)
+	var wg sync.WaitGroup
+	for i := 0; i < w.cfg.AuditWorkers; i++ {
+		wg.Add(1)
+		go func(client *lib.Client) {
+			defer wg.Done()
+			for modelID := range jobs {
+				status, _ := checkModel(client, modelID, w.headers.Headers(), w.cfg.Debug, w.cfg.SpecificConfig)
+				checks <- check{modelID: modelID, status: status}
+				time.Sleep(time.Duration(w.cfg.IntervalMs) * time.Millisecond)
+			}
+		}(w.clients[i%len(w.clients)])
+	}
+	go func() {
+		defer close(jobs)
+		for _, modelID := range models {
+			if time.Now().After(deadline) {
+				return
+			}
+			jobs <- modelID
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(checks)
+	}()
+	result := auditResult{endpoint: endpoint, total: len(models)}
+	for c := range checks {
+		result.checked++
+		switch c.status {
+		case lib.StatusNotFound:
+			result.notFound = append(result.notFound, c.modelID)
+		case lib.StatusUnknown:
+			result.failed++
+		}
+	}
+	sort.Strings(result.notFound)
+	w.auditResults <- result
+}
+
+func (w *worker) processAuditResult(r auditResult) {
+	w.auditInProgress = false
+	lines := []string{fmt.Sprintf("audit is finished, checked: %d of %d, not found: %d, failed: %d", r.checked, r.total, len(r.notFound), r.failed)}
+	if r.checked < r.total {
+		lines = append(lines, "the time limit is reached")
+	}
+	lines = append(lines, r.notFound...)
+	for _, part := range splitMessage(strings.Join(lines, "\n"), maxMessageLength) {
+		w.sendText(w.highPriorityMsg, r.endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, part)
+	}
+	linf("audit is finished, not found models: %d", len(r.notFound))
+}
+
+func (w *worker) processCheckListResult(r checkListResult) {
+	delete(w.checkLists, r.chat)
+	w.sendTr(w.highPriorityMsg, r.chat.endpoint, r.chat.chatID, false, w.tr[r.chat.endpoint].CheckList, tplData{"checks": r.checks})
+}
+
+// maxImportSize is the maximum size of an imported subscription list
+const maxImportSize = 64 * 1024
+
+// exportModels sends the subscription list of the chat as a text file, one model per line
+func (w *worker) exportModels(endpoint string, chatID int64) {
+	models := w.modelsForChat(endpoint, chatID)
+	if len(models) == 0 {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].ZeroSubscriptions, nil)
+		return
+	}
+	b := tg.FileBytes{Name: "models.txt", Bytes: []byte(strings.Join(models, "\n") + "\n")}
+	msg := tg.NewDocumentUpload(chatID, b)
+	w.enqueueMessage(w.highPriorityMsg, endpoint, &documentConfig{msg})
+}
+
+// importModels starts downloading a subscription list the user replied to
+func (w *worker) importModels(endpoint string, chatID int64, document *tg.Document) {
+	if document.FileSize > maxImportSize {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].SyntaxImport, tplData{"max_size_kb": maxImportSize / 1024})
+		return
+	}
+	go w.downloadImport(chat{endpoint: endpoint, chatID: chatID}, document.FileID, false)
+}
+
+// importSpecialModels marks the models listed in the document as special
+func (w *worker) importSpecialModels(endpoint string, document *tg.Document) {
+	if document.FileSize > maxImportSize {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, fmt.Sprintf("the file exceeds %d KB", maxImportSize/1024))
+		return
+	}
+	go w.downloadImport(chat{endpoint: endpoint, chatID: w.cfg.AdminID}, document.FileID, true)
+}
+
+// downloadImport downloads a subscription list sent as a file,
+// the result sent to importResults has no data if the download fails or the file is too large
+func (w *worker) downloadImport(c chat, fileID string, special bool) {
+	bot := w.bots[c.endpoint]
+	url, err := bot.GetFileDirectURL(fileID)
+	if err != nil {
+		lerr("cannot get the file URL, %v", err)
+		w.importResults <- importResult{chat: c, special: special}
+		return
+	}
+	req, err := http.NewRequest("GET", url, nil)
+	checkErr(err)
+	resp, err := bot.Client.Do(req)
+	if err != nil {
+		lerr("cannot download the file, %v", err)
+		w.importResults <- importResult{chat: c, special: special}
+		return
+	}
+	defer func() { checkErr(resp.Body.Close()) }()
+	if resp.StatusCode != 200 {
+		lerr("cannot download the file, status code %d", resp.StatusCode)
+		w.importResults <- importResult{chat: c, special: special}
+		return
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxImportSize+1))
+	if err != nil || len(data) > maxImportSize {
+		lerr("cannot read the file, %v", err)
+		w.importResults <- importResult{chat: c, special: special}
+		return
+	}
+	w.importResults <- importResult{chat: c, data: data, special: special}
+}
+
+// maxAddedModels is the maximum number of models added by a single command or import
+const maxAddedModels = 100
+
+// processImportResult starts subscribing the chat to the models of a downloaded list
+func (w *worker) processImportResult(r importResult, now int) {
+	endpoint, chatID := r.chat.endpoint, r.chat.chatID
+	if r.special {
+		w.processSpecialImport(endpoint, r.data)
+		return
+	}
+	if r.data == nil {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].ImportFailed, nil)
+		return
+	}
+	request := addRequest{chat: r.chat, imported: true}
+	for _, line := range strings.Split(string(r.data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		request.lines = append(request.lines, line)
+		request.models = append(request.models, w.modelIDPreprocessing(line))
+	}
+	if len(request.lines) == 0 {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].ImportFailed, nil)
+		return
+	}
+	if len(request.lines) > maxAddedModels {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].TooManyModels, tplData{"max_models": maxAddedModels})
+		return
+	}
+	w.startAdding(request, now)
+}
+
+// startAdding subscribes the chat to the models of the request,
+// the models unknown to the bot are checked by checkAddedModels first
+// but not more of them than the chat has room for
+func (w *worker) startAdding(r addRequest, now int) {
+	endpoint, chatID := r.chat.endpoint, r.chat.chatID
+	subscribed := map[string]bool{}
+	for _, m := range w.modelsForChat(endpoint, chatID) {
+		subscribed[m] = true
+	}
+	room := w.mustUser(chatID).maxModels - len(subscribed)
+	var unknown []string
+	for _, m := range r.models {
+		if subscribed[m] || !lib.ModelIDRegexp.MatchString(m) {
+			continue
+		}
+		subscribed[m] = true
+		if _, known := w.knownStatus(m); known {
+			room--
+		} else {
+			unknown = append(unknown, m)
+		}
+	}
+	if len(unknown) == 0 || room <= 0 {
+		w.processAddResult(r, now)
+		return
+	}
+	if w.addsInProgress[r.chat] {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].CheckListInProgress, nil)
+		return
+	}
+	w.addsInProgress[r.chat] = true
+	go w.checkAddedModels(r, unknown, room)
+	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].CheckListStarted, tplData{"count": len(unknown)})
+}
+
+// checkAddedModels checks the models unknown to the bot one by one respecting the query interval,
+// it stops as soon as the found models fill the room left to the chat
+func (w *worker) checkAddedModels(r addRequest, models []string, room int) {
+	checkModel := w.retryingChecker()
+	r.checks = map[string]checkedModel{}
+	found := 0
+	for i, m := range models {
+		if found == room {
+			break
+		}
+		if i > 0 {
+			time.Sleep(time.Duration(w.cfg.IntervalMs) * time.Millisecond)
+		}
+		status, err := checkModel(w.clients[i%len(w.clients)], m, w.headers.Headers(), w.cfg.Debug, w.cfg.SpecificConfig)
+		r.checks[m] = checkedModel{status: status, err: err}
+		if err == nil && status != lib.StatusNotFound && status != lib.StatusUnknown {
+			found++
+		}
+	}
+	w.addResults <- r
+}
+
+// processAddResult subscribes the chat to the models of a checked request,
+// it reports a result for every line of an imported file and a summary otherwise
+func (w *worker) processAddResult(r addRequest, now int) {
+	delete(w.addsInProgress, r.chat)
+	endpoint, chatID := r.chat.endpoint, r.chat.chatID
+	var lines []importLine
+	limitReached := false
+	for i, modelID := range r.models {
+		if limitReached {
+			lines = append(lines, importLine{Model: r.lines[i], Result: "skipped"})
+			continue
+		}
+		added, _ := w.subscribeChecked(endpoint, chatID, modelID, func() (checkedModel, bool) {
+			checked, ok := r.checks[modelID]
+			return checked, ok
+		}, now)
+		result := "failed"
+		switch added {
+		case modelAdded:
+			result = "added"
+		case modelInvalid:
+			result = "invalid"
+		case modelAlreadyAdded:
+			result = "already added"
+		case modelLimitReached, modelNotChecked:
+			limitReached = true
+			result = "skipped"
+		case modelNotFound:
+			result = "not found"
+		}
+		lines = append(lines, importLine{Model: r.lines[i], Result: result})
+	}
+	if r.imported {
+		tr := w.chatTranslation(endpoint, chatID, w.tr[endpoint].ImportResult)
+		text := templateToString(w.chatTemplates(endpoint, chatID), tr.Key, tplData{"lines": lines})
+		for _, part := range splitMessage(text, maxMessageLength) {
+			w.sendText(w.highPriorityMsg, endpoint, chatID, false, tr.DisablePreview, tr.Parse, part)
+		}
+		if limitReached {
+			w.subscriptionUsage(endpoint, chatID, true)
+		}
+		return
+	}
+	summary := map[string][]string{}
+	for i, l := range lines {
+		key := strings.Replace(l.Result, " ", "_", -1)
+		summary[key] = append(summary[key], r.models[i])
+	}
+	data := tplData{}
+	for k, v := range summary {
+		data[k] = v
+	}
+	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].ModelsAdded, data)
+	if limitReached || len(summary["added"]) > 0 && w.subscriptionsNumber(endpoint, chatID) >= w.mustUser(chatID).maxModels-w.cfg.HeavyUserRemainder {
+		w.subscriptionUsage(endpoint, chatID, true)
+	}
+}
+
+// processSpecialImport marks the models of the downloaded file as special
+// and reports the result of every line to admin
+func (w *worker) processSpecialImport(endpoint string, data []byte) {
+	if data == nil {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "cannot download the file")
+		return
+	}
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if modelID, ok := w.markSpecial(line); ok {
+			lines = append(lines, modelID+" — OK")
+		} else {
+			lines = append(lines, line+" — invalid")
+		}
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "the file contains no models")
+	}
+	for _, part := range splitMessage(strings.Join(lines, "\n"), maxMessageLength) {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, part)
+	}
+}
+
+func (w *worker) subscriptionUsage(endpoint string, chatID int64, ad bool) {
+	subscriptionsNumber := w.subscriptionsNumber(endpoint, chatID)
+	user := w.mustUser(chatID)
+	tr := w.tr[endpoint].SubscriptionUsage
+	if ad {
+		tr = w.tr[endpoint].SubscriptionUsageAd
 	}
 	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, tr, tplData{
 		"subscriptions_used":  subscriptionsNumber,
@@ -910,17 +2915,19 @@ func (w *worker) subscriptionUsage(endpoint string, chatID int64, ad bool) {
 func (w *worker) wantMore(endpoint string, chatID int64) {
 	w.showReferral(endpoint, chatID)
 
-	if w.cfg.CoinPayments == nil || w.cfg.Mail == nil {
+	if !w.paymentsEnabled(endpoint) {
 		return
 	}
 
-	tpl := w.tpl[endpoint]
+	cp := w.coinPayments(endpoint)
+	tpl := w.chatTemplates(endpoint, chatID)
 	text := templateToString(tpl, w.tr[endpoint].BuyAd.Key, tplData{
-		"price":                   w.cfg.CoinPayments.subscriptionPacketPrice,
-		"number_of_subscriptions": w.cfg.CoinPayments.subscriptionPacketModelNumber,
+		"price":                   cp.subscriptionPacketPrice,
+		"number_of_subscriptions": cp.subscriptionPacketModelNumber,
+		"days":                    cp.SubscriptionPacketDays,
 	})
 	buttonText := templateToString(tpl, w.tr[endpoint].BuyButton.Key, tplData{
-		"number_of_subscriptions": w.cfg.CoinPayments.subscriptionPacketModelNumber,
+		"number_of_subscriptions": cp.subscriptionPacketModelNumber,
 	})
 
 	buttons := [][]tg.InlineKeyboardButton{{tg.NewInlineKeyboardButtonData(buttonText, "buy")}}
@@ -930,29 +2937,200 @@ func (w *worker) wantMore(endpoint string, chatID int64) {
 	w.enqueueMessage(w.highPriorityMsg, endpoint, &messageConfig{msg})
 }
 
-func (w *worker) settings(endpoint string, chatID int64) {
+func (w *worker) settings(endpoint string, chatID int64, now int) {
 	subscriptionsNumber := w.subscriptionsNumber(endpoint, chatID)
 	user := w.mustUser(chatID)
 	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].Settings, tplData{
 		"subscriptions_used":              subscriptionsNumber,
 		"total_subscriptions":             user.maxModels,
 		"show_images":                     user.showImages,
+		"image_limit":                     user.imageLimit,
+		"image_links":                     user.imageLinks,
+		"offline_notifications_supported": w.cfg.OfflineNotifications,
+		"offline_notifications":           user.offlineNotifications,
+		"vacation_until":                  vacationUntil(user),
+		"muted":                           user.muted(now),
+		"mute_remaining":                  muteRemaining(user, now),
+		"quiet_from":                      user.quietFrom,
+		"quiet_to":                        user.quietTo,
+		"timezone":                        w.userLocation(user).String(),
+		"min_online_minutes":              user.minOnlineMinutes,
+		"paused":                          user.paused,
+	})
+}
+
+// statusMe shows all the active suppressions of the notifications of the user
+func (w *worker) statusMe(endpoint string, chatID int64, now int) {
+	user := w.mustUser(chatID)
+	var vacationRemaining *timeDiff
+	if user.snooze && user.resumeTimestamp > now {
+		diff := calcTimeDiff(time.Unix(int64(now), 0), time.Unix(int64(user.resumeTimestamp), 0))
+		vacationRemaining = &diff
+	}
+	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].StatusMe, tplData{
+		"vacation_remaining":              vacationRemaining,
+		"muted":                           user.muted(now),
+		"mute_remaining":                  muteRemaining(user, now),
+		"quiet_from":                      user.quietFrom,
+		"quiet_to":                        user.quietTo,
+		"quiet_remaining":                 w.quietRemaining(user, now),
 		"offline_notifications_supported": w.cfg.OfflineNotifications,
 		"offline_notifications":           user.offlineNotifications,
+		"online_disabled": w.mustInt(
+			"select count(*) from signals where endpoint=? and chat_id=? and notify_online=0", endpoint, chatID),
+		"offline_disabled": w.mustInt(
+			"select count(*) from signals where endpoint=? and chat_id=? and notify_offline=0", endpoint, chatID),
 	})
 }
 
-func (w *worker) enableImages(endpoint string, chatID int64, showImages bool) {
-	w.mustExec("update users set show_images=? where chat_id=?", showImages, chatID)
+// quietRemaining returns the remaining time of the current quiet hours or nil if the user is not inside quiet hours
+func (w *worker) quietRemaining(user user, now int) *timeDiff {
+	if !w.quiet(user, now) {
+		return nil
+	}
+	current := time.Unix(int64(now), 0).In(w.userLocation(user))
+	end := time.Date(current.Year(), current.Month(), current.Day(), user.quietTo, 0, 0, 0, current.Location())
+	if !end.After(current) {
+		end = end.AddDate(0, 0, 1)
+	}
+	diff := calcTimeDiff(current, end)
+	return &diff
+}
+
+// muteRemaining returns the remaining mute time or nil if the user is muted until unmute command
+func muteRemaining(user user, now int) *timeDiff {
+	if user.muteUntil <= now {
+		return nil
+	}
+	diff := calcTimeDiff(time.Unix(int64(now), 0), time.Unix(int64(user.muteUntil), 0))
+	return &diff
+}
+
+// mute silences notifications for the specified duration or until unmute command
+func (w *worker) mute(endpoint string, chatID int64, arguments string, now int) {
+	if arguments == "" {
+		w.mustExec("update users set mute_until=? where chat_id=?", muteForever, chatID)
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].Muted, nil)
+		return
+	}
+	duration, err := time.ParseDuration(arguments)
+	if err != nil || duration < time.Minute || duration > 365*24*time.Hour {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].SyntaxMute, nil)
+		return
+	}
+	until := now + int(duration.Seconds())
+	w.mustExec("update users set mute_until=? where chat_id=?", until, chatID)
+	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].Muted, tplData{
+		"until": time.Unix(int64(until), 0).UTC().Format("2006-01-02 15:04"),
+	})
+}
+
+func (w *worker) unmute(endpoint string, chatID int64) {
+	w.mustExec("update users set mute_until=0 where chat_id=?", chatID)
+	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].OK, nil)
+}
+
+func vacationUntil(user user) string {
+	if !user.snooze || user.resumeTimestamp == 0 {
+		return ""
+	}
+	return time.Unix(int64(user.resumeTimestamp), 0).UTC().Format("2006-01-02 15:04")
+}
+
+func (w *worker) vacation(endpoint string, chatID int64, arguments string, now int) {
+	days, err := strconv.Atoi(arguments)
+	if err != nil || days < 0 || days > 365 {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].SyntaxVacation, nil)
+		return
+	}
+	if days == 0 {
+		w.mustExec("update users set snooze=0, resume_timestamp=0 where chat_id=?", chatID)
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].OK, nil)
+		return
+	}
+	resume := now + days*24*60*60
+	w.mustExec("update users set snooze=1, resume_timestamp=? where chat_id=?", resume, chatID)
+	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].Vacation, tplData{
+		"until": time.Unix(int64(resume), 0).UTC().Format("2006-01-02 15:04"),
+	})
+}
+
+// pause stops checking the models of the user and notifying the user until resume command
+func (w *worker) pause(endpoint string, chatID int64) {
+	w.mustExec("update users set paused=1 where chat_id=?", chatID)
+	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].Paused, nil)
+}
+
+// resume clears the paused flag and shows the current statuses of the models
+func (w *worker) resume(endpoint string, chatID int64, now int) {
+	w.mustExec("update users set paused=0 where chat_id=?", chatID)
+	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].OK, nil)
+	w.listModels(endpoint, chatID, "", now)
+}
+
+func (w *worker) resumeVacations(now int) {
+	w.mustExec("update users set snooze=0, resume_timestamp=0 where snooze=1 and resume_timestamp!=0 and resume_timestamp<=?", now)
+}
+
+func (w *worker) enableImages(endpoint string, chatID int64, showImages bool) {
+	w.mustExec("update users set show_images=? where chat_id=?", showImages, chatID)
+	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].OK, nil)
+}
+
+func (w *worker) setImageLimit(endpoint string, chatID int64, arguments string) {
+	limit, err := strconv.Atoi(arguments)
+	if err != nil || limit < 0 || limit > 1000 {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].SyntaxImageLimit, nil)
+		return
+	}
+	w.mustExec("update users set image_limit=? where chat_id=?", limit, chatID)
+	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].OK, nil)
+}
+
+// setImageMode chooses whether images are uploaded with online notifications
+// or sent as links shown by a preview, links save the data of the users
+func (w *worker) setImageMode(endpoint string, chatID int64, arguments string) {
+	var links bool
+	switch strings.ToLower(strings.TrimSpace(arguments)) {
+	case "upload":
+		links = false
+	case "link":
+		links = true
+	default:
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].SyntaxImageMode, nil)
+		return
+	}
+	w.mustExec("update users set image_links=? where chat_id=?", links, chatID)
+	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].OK, nil)
+}
+
+// setMinOnline hides the models online for less than this number of minutes from the online list
+func (w *worker) setMinOnline(endpoint string, chatID int64, arguments string) {
+	minutes, err := strconv.Atoi(arguments)
+	if err != nil || minutes < 0 || minutes > 24*60 {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].SyntaxMinOnline, nil)
+		return
+	}
+	w.mustExec("update users set min_online_minutes=? where chat_id=?", minutes, chatID)
 	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].OK, nil)
 }
 
+// onlineLongEnough returns true if the model has been online for at least this number of minutes,
+// the models having unknown online time are treated as online long enough
+func (w *worker) onlineLongEnough(modelID string, minutes int, now int) bool {
+	if minutes == 0 {
+		return true
+	}
+	begin, end, _ := w.lastSeenInfo(modelID, now)
+	return begin == 0 || end != 0 || now-begin >= minutes*60
+}
+
 func (w *worker) enableOfflineNotifications(endpoint string, chatID int64, offlineNotifications bool) {
 	w.mustExec("update users set offline_notifications=? where chat_id=?", offlineNotifications, chatID)
 	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].OK, nil)
 }
 
-func (w *worker) removeModel(endpoint string, chatID int64, modelID string) {
+func (w *worker) removeModel(endpoint string, chatID int64, modelID string, now int) {
 	if modelID == "" {
 		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].SyntaxRemove, nil)
 		return
@@ -966,28 +3144,76 @@ func (w *worker) removeModel(endpoint string, chatID int64, modelID string) {
 		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].ModelNotInList, tplData{"model": modelID})
 		return
 	}
+	r := removal{modelID: modelID, timestamp: now}
+	w.maybeRecord("select created_at, notify_online, notify_offline from signals where chat_id=? and model_id=? and endpoint=?",
+		queryParams{chatID, modelID, endpoint},
+		record{&r.createdAt, &r.notifyOnline, &r.notifyOffline})
+	w.maybeRecord("select alias from aliases where chat_id=? and model_id=? and endpoint=?", queryParams{chatID, modelID, endpoint}, record{&r.alias})
+	w.maybeRecord("select tag from tags where chat_id=? and model_id=? and endpoint=?", queryParams{chatID, modelID, endpoint}, record{&r.tag})
 	w.mustExec("delete from signals where chat_id=? and model_id=? and endpoint=?", chatID, modelID, endpoint)
+	w.mustExec("delete from aliases where chat_id=? and model_id=? and endpoint=?", chatID, modelID, endpoint)
+	w.mustExec("delete from tags where chat_id=? and model_id=? and endpoint=?", chatID, modelID, endpoint)
+	w.lastRemovals[chat{endpoint: endpoint, chatID: chatID}] = r
 	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].ModelRemoved, tplData{"model": modelID})
 }
 
+// undoRemove restores the last removed subscription with its creation time, notification settings, alias and tag
+func (w *worker) undoRemove(endpoint string, chatID int64, now int) {
+	c := chat{endpoint: endpoint, chatID: chatID}
+	r, ok := w.lastRemovals[c]
+	if !ok || now-r.timestamp > w.cfg.UndoRemoveMinutes*60 {
+		delete(w.lastRemovals, c)
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].NothingToUndo, nil)
+		return
+	}
+	if w.subscriptionExists(endpoint, chatID, r.modelID) {
+		delete(w.lastRemovals, c)
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].AlreadyAdded, tplData{"model": r.modelID})
+		return
+	}
+	if w.subscriptionsNumber(endpoint, chatID) >= w.mustUser(chatID).maxModels {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].NotEnoughSubscriptions, nil)
+		return
+	}
+	delete(w.lastRemovals, c)
+	w.mustExec("insert into signals (chat_id, model_id, endpoint, created_at, notify_online, notify_offline) values (?,?,?,?,?,?)",
+		chatID, r.modelID, endpoint, r.createdAt, r.notifyOnline, r.notifyOffline)
+	if r.alias != "" {
+		w.mustExec("insert into aliases (endpoint, chat_id, model_id, alias) values (?,?,?,?)", endpoint, chatID, r.modelID, r.alias)
+	}
+	if r.tag != "" {
+		w.mustExec("insert into tags (endpoint, chat_id, model_id, tag) values (?,?,?,?)", endpoint, chatID, r.modelID, r.tag)
+	}
+	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].ModelRestored, tplData{"model": r.modelID})
+}
+
 func (w *worker) sureRemoveAll(endpoint string, chatID int64) {
 	w.mustExec("delete from signals where chat_id=? and endpoint=?", chatID, endpoint)
+	w.mustExec("delete from aliases where chat_id=? and endpoint=?", chatID, endpoint)
+	w.mustExec("delete from tags where chat_id=? and endpoint=?", chatID, endpoint)
 	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].AllModelsRemoved, nil)
 }
 
 func (w *worker) buy(endpoint string, chatID int64) {
+	cp := w.coinPayments(endpoint)
 	var buttons [][]tg.InlineKeyboardButton
-	for _, c := range w.cfg.CoinPayments.Currencies {
+	for _, c := range cp.Currencies {
 		buttons = append(buttons, []tg.InlineKeyboardButton{tg.NewInlineKeyboardButtonData(c, "buy_with "+c)})
 	}
 
 	user := w.mustUser(chatID)
+	tpl := w.chatTemplates(endpoint, chatID)
+	if w.cfg.TelegramPayments != nil {
+		cardText := templateToString(tpl, w.tr[endpoint].CardButton.Key, nil)
+		buttons = append(buttons, []tg.InlineKeyboardButton{tg.NewInlineKeyboardButtonData(cardText, "buy_by_card")})
+	}
 	keyboard := tg.NewInlineKeyboardMarkup(buttons...)
-	tpl := w.tpl[endpoint]
 	text := templateToString(tpl, w.tr[endpoint].SelectCurrency.Key, tplData{
-		"dollars":                 w.cfg.CoinPayments.subscriptionPacketPrice,
-		"number_of_subscriptions": w.cfg.CoinPayments.subscriptionPacketModelNumber,
-		"total_subscriptions":     user.maxModels + w.cfg.CoinPayments.subscriptionPacketModelNumber,
+		"dollars":                 w.packetPrice(endpoint, chatID),
+		"discount":                w.promoDiscount(chatID),
+		"number_of_subscriptions": cp.subscriptionPacketModelNumber,
+		"total_subscriptions":     user.maxModels + cp.subscriptionPacketModelNumber,
+		"card":                    w.cfg.TelegramPayments != nil,
 	})
 
 	msg := tg.NewMessage(chatID, text)
@@ -1007,109 +3233,744 @@ func (w *worker) transaction(uuid string) (status payments.StatusKind, chatID in
 	return
 }
 
-func (w *worker) buyWith(endpoint string, chatID int64, currency string) {
-	found := false
-	for _, c := range w.cfg.CoinPayments.Currencies {
-		if currency == c {
-			found = true
-			break
-		}
+// startPacket applies a finished transaction of an expiring subscription packet,
+// every packet adds its own subscriptions and expires on its own,
+// it returns the expiration time
+func (w *worker) startPacket(chatID int64, localID string, days int, now int) int {
+	expiresAt := now + days*24*60*60
+	w.mustExec("update transactions set expires_at=? where local_id=?", expiresAt, localID)
+	w.grantPacket(chatID, localID)
+	linf("packet %s of the chat %d expires at %d", localID, chatID, expiresAt)
+	return expiresAt
+}
+
+// grantPacket adds the subscriptions of a finished transaction to the user
+// and records them on the transaction to take exactly them back on expiration or revocation
+func (w *worker) grantPacket(chatID int64, localID string) {
+	w.mustExec("update transactions set granted=model_number where local_id=?", localID)
+	w.changeLimit(chatID, false, func() {
+		w.mustExec("update users set max_models = max_models + (select coalesce(sum(granted), 0) from transactions where local_id=?) where chat_id=?", localID, chatID)
+	})
+}
+
+// expirePackets warns the users about subscription packets expiring soon
+// and takes back the subscriptions of the expired ones
+func (w *worker) expirePackets(now int) {
+	type packet struct {
+		localID     string
+		chatID      int64
+		endpoint    string
+		modelNumber int
+		expiresAt   int
+	}
+	queryPackets := func(query string, args ...interface{}) (packets []packet) {
+		rows := w.mustQuery(query, args...)
+		defer func() { checkErr(rows.Close()) }()
+		for rows.Next() {
+			var p packet
+			checkErr(rows.Scan(&p.localID, &p.chatID, &p.endpoint, &p.modelNumber, &p.expiresAt))
+			packets = append(packets, p)
+		}
+		return
+	}
+	if w.cfg.PacketExpiryWarningHours > 0 {
+		expiring := queryPackets(`
+			select local_id, chat_id, endpoint, model_number, expires_at from transactions
+			where expires_at!=0 and expired=0 and expiry_warned=0 and expires_at>? and expires_at<=?`,
+			now,
+			now+w.cfg.PacketExpiryWarningHours*60*60)
+		for _, p := range expiring {
+			w.mustExec("update transactions set expiry_warned=1 where local_id=?", p.localID)
+			w.sendTr(w.lowPriorityMsg, p.endpoint, p.chatID, false, w.tr[p.endpoint].PacketExpiring, tplData{
+				"number_of_subscriptions": p.modelNumber,
+				"expires":                 time.Unix(int64(p.expiresAt), 0).UTC().Format("2006-01-02 15:04"),
+			})
+		}
+	}
+	expired := queryPackets(`
+		select local_id, chat_id, endpoint, granted, expires_at from transactions
+		where expires_at!=0 and expired=0 and expires_at<=?`,
+		now)
+	for _, p := range expired {
+		w.mustExec("update transactions set expired=1, granted=0 where local_id=?", p.localID)
+		w.changeLimit(p.chatID, true, func() {
+			w.mustExec("update users set max_models=max_models-? where chat_id=?", p.modelNumber, p.chatID)
+			w.mustExec("update users set max_models=0 where chat_id=? and max_models<0", p.chatID)
+		})
+		linf("packet %s of the chat %d is expired", p.localID, p.chatID)
+	}
+}
+
+// receiptData returns the receipt template data of the transaction
+func (w *worker) receiptData(localID string) (data tplData, found bool) {
+	var amount, currency string
+	var modelNumber, timestamp int
+	found = w.maybeRecord("select amount, currency, model_number, timestamp from transactions where local_id=?",
+		queryParams{localID},
+		record{&amount, &currency, &modelNumber, &timestamp})
+	if !found {
+		return nil, false
+	}
+	return tplData{
+		"transaction":  localID,
+		"amount":       amount,
+		"currency":     currency,
+		"model_number": modelNumber,
+		"date":         time.Unix(int64(timestamp), 0).UTC().Format("2006-01-02 15:04"),
+	}, true
+}
+
+// sendReceipt sends the receipt of the transaction,
+// it is also sent as a text document if receipt_documents is set
+func (w *worker) sendReceipt(endpoint string, chatID int64, localID string) {
+	data, found := w.receiptData(localID)
+	if !found {
+		lerr("cannot find transaction %s for the receipt", localID)
+		return
+	}
+	w.sendTr(w.lowPriorityMsg, endpoint, chatID, false, w.tr[endpoint].Receipt, data)
+	if !w.cfg.ReceiptDocuments {
+		return
+	}
+	text := templateToString(w.chatTemplates(endpoint, chatID), w.tr[endpoint].Receipt.Key, data)
+	b := tg.FileBytes{Name: "receipt-" + localID + ".txt", Bytes: []byte(text + "\n")}
+	msg := tg.NewDocumentUpload(chatID, b)
+	w.enqueueMessage(w.lowPriorityMsg, endpoint, &documentConfig{msg})
+}
+
+// transactionsPageSize is the number of transactions on a page of the transaction history
+const transactionsPageSize = 10
+
+// showTransactions sends a page of the transaction history of the owner chat, the latest transactions first,
+// the admin can inspect the history of any chat
+func (w *worker) showTransactions(endpoint string, chatID int64, owner int64, page int) {
+	count := w.mustInt("select count(*) from transactions where chat_id=?", owner)
+	pages := (count + transactionsPageSize - 1) / transactionsPageSize
+	if pages == 0 {
+		pages = 1
+	}
+	if page > pages {
+		page = pages
+	}
+	rows := w.mustQuery(`
+		select timestamp, currency, amount, status, model_number from transactions
+		where chat_id=?
+		order by timestamp desc
+		limit ? offset ?`,
+		owner,
+		transactionsPageSize,
+		(page-1)*transactionsPageSize)
+	defer func() { checkErr(rows.Close()) }()
+	var transactions []tplData
+	for rows.Next() {
+		var timestamp int64
+		var currency, amount string
+		var status payments.StatusKind
+		var modelNumber int
+		checkErr(rows.Scan(&timestamp, &currency, &amount, &status, &modelNumber))
+		transactions = append(transactions, tplData{
+			"date":         time.Unix(timestamp, 0).UTC().Format("2006-01-02 15:04"),
+			"currency":     currency,
+			"amount":       amount,
+			"status":       status.String(),
+			"model_number": modelNumber,
+		})
+	}
+	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].Transactions, tplData{
+		"transactions": transactions,
+		"page":         page,
+		"pages":        pages,
+	})
+}
+
+// revoke takes back the subscriptions granted by a finished transaction and not taken back by its expiration,
+// the limit of the user does not go below the default one unless it is already below it
+func (w *worker) revoke(endpoint string, arguments string) {
+	localID := strings.TrimSpace(arguments)
+	if localID == "" {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "usage: /revoke local_ID")
+		return
+	}
+	var status payments.StatusKind
+	var chatID int64
+	var transactionEndpoint string
+	var granted int
+	if !w.maybeRecord("select status, chat_id, endpoint, granted from transactions where local_id=?",
+		queryParams{localID},
+		record{&status, &chatID, &transactionEndpoint, &granted}) {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "transaction not found")
+		return
+	}
+	if status == payments.StatusRevoked {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "transaction is already revoked")
+		return
+	}
+	if status != payments.StatusFinished {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, fmt.Sprintf("transaction is %v, only finished ones can be revoked", status))
+		return
+	}
+	oldLimit := w.mustUser(chatID).maxModels
+	newLimit := oldLimit - granted
+	floor := w.cfg.MaxModels
+	if oldLimit < floor {
+		floor = oldLimit
+	}
+	if newLimit < floor {
+		newLimit = floor
+	}
+	w.mustExec("update transactions set status=?, expired=1, granted=0 where local_id=?", payments.StatusRevoked, localID)
+	w.changeLimit(chatID, false, func() {
+		w.mustExec("update users set max_models=? where chat_id=?", newLimit, chatID)
+	})
+	user := w.mustUser(chatID)
+	w.sendTr(w.lowPriorityMsg, transactionEndpoint, chatID, false, w.tr[transactionEndpoint].PaymentRevoked, tplData{
+		"transaction": localID,
+		"max_models":  user.maxModels,
+	})
+	linf("payment %s of the chat %d is revoked, subscriptions taken back: %d", localID, chatID, granted)
+	text := fmt.Sprintf("payment %s is revoked, chat %d can subscribe to %d models now", localID, chatID, user.maxModels)
+	w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, text)
+}
+
+// telegramPaymentCurrency is the currency of Telegram payments
+const telegramPaymentCurrency = "USD"
+
+// buyByCard sends an invoice paid by card inside Telegram for the subscription packet
+func (w *worker) buyByCard(endpoint string, chatID int64) {
+	cp := w.coinPayments(endpoint)
+	price := w.packetPrice(endpoint, chatID)
+	localID := uuid.New()
+	w.mustExec(`
+		insert into transactions (status, kind, local_id, chat_id, amount, timestamp, model_number, currency, endpoint)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		payments.StatusCreated,
+		"telegram",
+		localID,
+		chatID,
+		strconv.Itoa(price),
+		int(time.Now().Unix()),
+		cp.subscriptionPacketModelNumber,
+		telegramPaymentCurrency,
+		endpoint)
+	tpl := w.chatTemplates(endpoint, chatID)
+	data := tplData{"number_of_subscriptions": cp.subscriptionPacketModelNumber}
+	title := templateToString(tpl, w.tr[endpoint].InvoiceTitle.Key, data)
+	description := templateToString(tpl, w.tr[endpoint].InvoiceDescription.Key, data)
+	prices := []tg.LabeledPrice{{Label: title, Amount: price * 100}}
+	invoice := tg.NewInvoice(chatID, title, description, localID.String(), w.cfg.TelegramPayments.ProviderToken, "buy", telegramPaymentCurrency, &prices)
+	w.enqueueMessage(w.highPriorityMsg, endpoint, &invoiceConfig{invoice})
+}
+
+// checkTelegramPayment returns true if the payment matches a created transaction of a Telegram invoice
+func (w *worker) checkTelegramPayment(localID string, chatID int64, currency string, totalAmount int) bool {
+	var status payments.StatusKind
+	var kind, amount, transactionCurrency string
+	var transactionChatID int64
+	if !w.maybeRecord("select status, kind, chat_id, amount, currency from transactions where local_id=?",
+		queryParams{localID},
+		record{&status, &kind, &transactionChatID, &amount, &transactionCurrency}) {
+		return false
+	}
+	price, err := strconv.Atoi(amount)
+	return err == nil &&
+		kind == "telegram" &&
+		status == payments.StatusCreated &&
+		transactionChatID == chatID &&
+		transactionCurrency == currency &&
+		price*100 == totalAmount
+}
+
+// preCheckout confirms the payment of a Telegram invoice if the transaction is still awaiting it,
+// the answer is sent outside of the main loop like the other Telegram requests
+func (w *worker) preCheckout(endpoint string, query *tg.PreCheckoutQuery) {
+	chatID := int64(query.From.ID)
+	answer := tg.PreCheckoutConfig{PreCheckoutQueryID: query.ID, OK: true}
+	if !w.checkTelegramPayment(query.InvoicePayload, chatID, query.Currency, query.TotalAmount) {
+		lerr("unexpected pre-checkout query for the transaction %s", query.InvoicePayload)
+		answer.OK = false
+		answer.ErrorMessage = templateToString(w.chatTemplates(endpoint, chatID), w.tr[endpoint].TryToBuyLater.Key, nil)
+	}
+	bot := w.bots[endpoint]
+	go func() {
+		if _, err := bot.AnswerPreCheckoutQuery(answer); err != nil {
+			lerr("cannot answer pre-checkout query, %v", err)
+		}
+	}()
+}
+
+// successfulPayment applies a transaction paid by card inside Telegram
+func (w *worker) successfulPayment(endpoint string, chatID int64, payment *tg.SuccessfulPayment) {
+	if !w.checkTelegramPayment(payment.InvoicePayload, chatID, payment.Currency, payment.TotalAmount) {
+		lerr("unexpected successful payment for the transaction %s, charge %s", payment.InvoicePayload, payment.TelegramPaymentChargeID)
+		return
+	}
+	w.mustExec("update transactions set remote_id=? where local_id=?", payment.TelegramPaymentChargeID, payment.InvoicePayload)
+	w.completePayment(endpoint, chatID, payment.InvoicePayload)
+}
+
+// promoDiscount returns the discount in percent the user gets on the next subscription packet
+func (w *worker) promoDiscount(chatID int64) int {
+	discount := 0
+	w.maybeRecord("select promo_discount from users where chat_id=?", queryParams{chatID}, record{&discount})
+	return discount
+}
+
+// packetPrice returns the price of the subscription packet for the user in dollars
+// taking a redeemed discount code into account
+func (w *worker) packetPrice(endpoint string, chatID int64) int {
+	price := w.coinPayments(endpoint).subscriptionPacketPrice
+	price -= price * w.promoDiscount(chatID) / 100
+	if price < 1 {
+		price = 1
+	}
+	return price
+}
+
+// createPromo creates a promo code giving either a discount on the next subscription packet
+// or additional subscriptions right away,
+// zero maximum redemptions means unlimited ones, zero days means the code never expires
+func (w *worker) createPromo(endpoint string, arguments string, now int) {
+	parts := strings.Fields(arguments)
+	if len(parts) != 5 {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "usage: /promo_create code discount|bonus value max_redemptions days")
+		return
+	}
+	code := strings.ToLower(parts[0])
+	value, err := strconv.Atoi(parts[2])
+	if err != nil || value <= 0 {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "third argument is invalid")
+		return
+	}
+	var discount, bonus int
+	switch parts[1] {
+	case "discount":
+		if value >= 100 {
+			w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "discount should be less than 100 percent")
+			return
+		}
+		discount = value
+	case "bonus":
+		bonus = value
+	default:
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "second argument is invalid")
+		return
+	}
+	maxRedemptions, err := strconv.Atoi(parts[3])
+	if err != nil || maxRedemptions < 0 {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "fourth argument is invalid")
+		return
+	}
+	days, err := strconv.Atoi(parts[4])
+	if err != nil || days < 0 {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "fifth argument is invalid")
+		return
+	}
+	if w.mustInt("select count(*) from promo_codes where code=?", code) != 0 {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, fmt.Sprintf("promo code %s already exists", code))
+		return
+	}
+	expiresAt := 0
+	if days != 0 {
+		expiresAt = now + days*24*60*60
+	}
+	w.mustExec(`
+		insert into promo_codes (code, discount_percent, bonus_models, max_redemptions, expires_at)
+		values (?, ?, ?, ?, ?)`,
+		code, discount, bonus, maxRedemptions, expiresAt)
+	w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, fmt.Sprintf("promo code %s is created", code))
+}
+
+// redeemPromo applies a promo code, every user can redeem a code once,
+// a discount code lowers the price of the next subscription packet
+// and a bonus code adds subscriptions right away
+func (w *worker) redeemPromo(endpoint string, chatID int64, arguments string, now int) {
+	code := strings.ToLower(strings.TrimSpace(arguments))
+	if code == "" {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].SyntaxPromo, nil)
+		return
+	}
+	if w.mustInt("select count(*) from promo_redemptions where code=? and chat_id=?", code, chatID) != 0 {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].PromoAlreadyUsed, nil)
+		return
+	}
+	var discount, bonus, maxRedemptions, expiresAt, redemptions int
+	if !w.maybeRecord(
+		"select discount_percent, bonus_models, max_redemptions, expires_at, redemptions from promo_codes where code=?",
+		queryParams{code},
+		record{&discount, &bonus, &maxRedemptions, &expiresAt, &redemptions}) ||
+		(expiresAt != 0 && expiresAt <= now) ||
+		(maxRedemptions != 0 && redemptions >= maxRedemptions) ||
+		(discount != 0 && !w.paymentsEnabled(endpoint)) {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].PromoInvalid, nil)
+		return
+	}
+	w.mustExec("insert into promo_redemptions (code, chat_id, timestamp) values (?, ?, ?)", code, chatID, now)
+	w.mustExec("update promo_codes set redemptions=redemptions+1 where code=?", code)
+	if bonus != 0 {
+		w.changeLimit(chatID, true, func() {
+			w.mustExec("update users set max_models=max_models+? where chat_id=?", bonus, chatID)
+		})
+	} else {
+		w.mustExec("update users set promo_discount=? where chat_id=?", discount, chatID)
+	}
+	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].PromoApplied, tplData{
+		"discount":     discount,
+		"bonus_models": bonus,
+	})
+	linf("promo code %s is redeemed by the chat %d", code, chatID)
+}
+
+func (w *worker) buyWith(endpoint string, chatID int64, currency string) {
+	cp := w.coinPayments(endpoint)
+	found := false
+	for _, c := range cp.Currencies {
+		if currency == c {
+			found = true
+			break
+		}
+	}
+	if !found {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].UnknownCurrency, nil)
+		return
+	}
+
+	email := w.email(endpoint, chatID)
+	localID := uuid.New()
+	api := w.coinPaymentsAPIs[baseEndpoint(endpoint)]
+	transaction, err := api.CreateTransaction(w.packetPrice(endpoint, chatID), currency, email, localID.String())
+	if err != nil {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].TryToBuyLater, nil)
+		lerr("create transaction failed, %v", err)
+		return
+	}
+	kind := "coinpayments"
+	timestamp := int(time.Now().Unix())
+	w.mustExec(`
+		insert into transactions (
+			status,
+			kind,
+			local_id,
+			chat_id,
+			remote_id,
+			timeout,
+			amount,
+			address,
+			dest_tag,
+			status_url,
+			checkout_url,
+			timestamp,
+			model_number,
+			currency,
+			endpoint)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		payments.StatusCreated,
+		kind,
+		localID,
+		chatID,
+		transaction.TXNID,
+		transaction.Timeout,
+		transaction.Amount,
+		transaction.Address,
+		transaction.DestTag,
+		transaction.StatusURL,
+		transaction.CheckoutURL,
+		timestamp,
+		cp.subscriptionPacketModelNumber,
+		currency,
+		endpoint)
+
+	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].PayThis, tplData{
+		"price":    transaction.Amount,
+		"currency": currency,
+		"link":     transaction.CheckoutURL,
+	})
+}
+
+// calcTimeDiff calculates time difference ignoring summer time and leap seconds
+func calcTimeDiff(t1, t2 time.Time) timeDiff {
+	var diff timeDiff
+	day := int64(time.Hour * 24)
+	d := t2.Sub(t1).Nanoseconds()
+	diff.Days = int(d / day)
+	d -= int64(diff.Days) * day
+	diff.Hours = int(d / int64(time.Hour))
+	d -= int64(diff.Hours) * int64(time.Hour)
+	diff.Minutes = int(d / int64(time.Minute))
+	d -= int64(diff.Minutes) * int64(time.Minute)
+	diff.Seconds = int(d / int64(time.Second))
+	d -= int64(diff.Seconds) * int64(time.Second)
+	diff.Nanoseconds = int(d)
+	return diff
+}
+
+func (w *worker) listModels(endpoint string, chatID int64, tag string, now int) {
+	type data struct {
+		Model         string
+		Alias         string
+		TimeDiff      *timeDiff
+		NotifyOnline  bool
+		NotifyOffline bool
+	}
+	type group struct {
+		online, offline, denied []data
+	}
+	tag = strings.ToLower(tag)
+	statuses := w.statusesForChat(endpoint, chatID)
+	aliases := w.aliasesForChat(endpoint, chatID)
+	tags := w.tagsForChat(endpoint, chatID)
+	groups := map[string]*group{}
+	for _, s := range statuses {
+		if tag != "" && tags[s.modelID] != tag {
+			continue
+		}
+		data := data{
+			Model:         s.modelID,
+			Alias:         aliases[s.modelID],
+			TimeDiff:      w.modelTimeDiff(s.modelID, now),
+			NotifyOnline:  s.notifyOnline,
+			NotifyOffline: s.notifyOffline,
+		}
+		g := groups[tags[s.modelID]]
+		if g == nil {
+			g = &group{}
+			groups[tags[s.modelID]] = g
+		}
+		switch s.status {
+		case lib.StatusOnline, lib.StatusIdle, lib.StatusPrivate:
+			g.online = append(g.online, data)
+		case lib.StatusDenied:
+			g.denied = append(g.denied, data)
+		default:
+			g.offline = append(g.offline, data)
+		}
+	}
+	if tag != "" && len(groups) == 0 {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].NoTaggedModels, tplData{"tag": tag})
+		return
+	}
+	if len(groups) == 0 {
+		groups[""] = &group{}
+	}
+	var names []string
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		g := groups[name]
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].List, tplData{
+			"tag":     name,
+			"online":  g.online,
+			"offline": g.offline,
+			"denied":  g.denied,
+		})
+	}
+}
+
+// tagRegexp matches valid tag names
+var tagRegexp = regexp.MustCompile(`^[\p{L}\p{N}_\-]{1,32}$`)
+
+// tag puts a subscription into a tag group, an empty tag removes it from the group
+func (w *worker) tag(endpoint string, chatID int64, arguments string) {
+	parts := strings.Fields(arguments)
+	if len(parts) == 0 || len(parts) > 2 {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].SyntaxTag, nil)
+		return
+	}
+	modelID := w.modelIDPreprocessing(parts[0])
+	if !lib.ModelIDRegexp.MatchString(modelID) {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].InvalidSymbols, tplData{"model": modelID})
+		return
+	}
+	if !w.subscriptionExists(endpoint, chatID, modelID) {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].ModelNotInList, tplData{"model": modelID})
+		return
+	}
+	if len(parts) == 1 {
+		w.mustExec("delete from tags where endpoint=? and chat_id=? and model_id=?", endpoint, chatID, modelID)
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].OK, nil)
+		return
+	}
+	tag := strings.ToLower(parts[1])
+	if !tagRegexp.MatchString(tag) {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].SyntaxTag, nil)
+		return
+	}
+	w.mustExec(`
+		insert into tags (endpoint, chat_id, model_id, tag) values (?,?,?,?)
+		on conflict(endpoint, chat_id, model_id) do update set tag=excluded.tag`,
+		endpoint, chatID, modelID, tag)
+	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].OK, nil)
+}
+
+// tagsForChat returns model tags assigned by the chat
+func (w *worker) tagsForChat(endpoint string, chatID int64) map[string]string {
+	query := w.mustQuery("select model_id, tag from tags where endpoint=? and chat_id=?", endpoint, chatID)
+	defer func() { checkErr(query.Close()) }()
+	tags := map[string]string{}
+	for query.Next() {
+		var modelID string
+		var tag string
+		checkErr(query.Scan(&modelID, &tag))
+		tags[modelID] = tag
+	}
+	return tags
+}
+
+// setModelNotifications enables or disables online or offline notifications for a subscription
+func (w *worker) setModelNotifications(endpoint string, chatID int64, arguments string) {
+	parts := strings.Fields(arguments)
+	if len(parts) != 3 || parts[1] != "online" && parts[1] != "offline" || parts[2] != "on" && parts[2] != "off" {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].SyntaxNotify, nil)
+		return
+	}
+	modelID := w.modelIDPreprocessing(parts[0])
+	if !lib.ModelIDRegexp.MatchString(modelID) {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].InvalidSymbols, tplData{"model": modelID})
+		return
+	}
+	if !w.subscriptionExists(endpoint, chatID, modelID) {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].ModelNotInList, tplData{"model": modelID})
+		return
+	}
+	column := "notify_online"
+	if parts[1] == "offline" {
+		column = "notify_offline"
+	}
+	w.mustExec("update signals set "+column+"=? where endpoint=? and chat_id=? and model_id=?", parts[2] == "on", endpoint, chatID, modelID)
+	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].OK, nil)
+}
+
+// rename sets a per-subscription alias of a model, an empty alias removes it
+func (w *worker) rename(endpoint string, chatID int64, arguments string) {
+	parts := strings.SplitN(strings.TrimSpace(arguments), " ", 2)
+	if parts[0] == "" {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].SyntaxRename, nil)
+		return
 	}
-	if !found {
-		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].UnknownCurrency, nil)
+	modelID := w.modelIDPreprocessing(parts[0])
+	if !lib.ModelIDRegexp.MatchString(modelID) {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].InvalidSymbols, tplData{"model": modelID})
 		return
 	}
-
-	email := w.email(endpoint, chatID)
-	localID := uuid.New()
-	transaction, err := w.coinPaymentsAPI.CreateTransaction(w.cfg.CoinPayments.subscriptionPacketPrice, currency, email, localID.String())
-	if err != nil {
-		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].TryToBuyLater, nil)
-		lerr("create transaction failed, %v", err)
+	if !w.subscriptionExists(endpoint, chatID, modelID) {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].ModelNotInList, tplData{"model": modelID})
 		return
 	}
-	kind := "coinpayments"
-	timestamp := int(time.Now().Unix())
-	w.mustExec(`
-		insert into transactions (
-			status,
-			kind,
-			local_id,
-			chat_id,
-			remote_id,
-			timeout,
-			amount,
-			address,
-			dest_tag,
-			status_url,
-			checkout_url,
-			timestamp,
-			model_number,
-			currency,
-			endpoint)
-		values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		payments.StatusCreated,
-		kind,
-		localID,
-		chatID,
-		transaction.TXNID,
-		transaction.Timeout,
-		transaction.Amount,
-		transaction.Address,
-		transaction.DestTag,
-		transaction.StatusURL,
-		transaction.CheckoutURL,
-		timestamp,
-		w.cfg.CoinPayments.subscriptionPacketModelNumber,
-		currency,
-		endpoint)
+	alias := ""
+	if len(parts) == 2 {
+		alias = strings.Join(strings.Fields(parts[1]), " ")
+	}
+	if utf8.RuneCountInString(alias) > maxAliasLength {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].SyntaxRename, nil)
+		return
+	}
+	if alias == "" {
+		w.mustExec("delete from aliases where endpoint=? and chat_id=? and model_id=?", endpoint, chatID, modelID)
+	} else {
+		w.mustExec(`
+			insert into aliases (endpoint, chat_id, model_id, alias) values (?,?,?,?)
+			on conflict(endpoint, chat_id, model_id) do update set alias=excluded.alias`,
+			endpoint, chatID, modelID, alias)
+	}
+	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].OK, nil)
+}
 
-	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].PayThis, tplData{
-		"price":    transaction.Amount,
-		"currency": currency,
-		"link":     transaction.CheckoutURL,
-	})
+// alias returns an alias the chat assigned to a model or an empty string
+func (w *worker) alias(endpoint string, chatID int64, modelID string) string {
+	var alias string
+	w.maybeRecord("select alias from aliases where endpoint=? and chat_id=? and model_id=?", queryParams{endpoint, chatID, modelID}, record{&alias})
+	return alias
 }
 
-// calcTimeDiff calculates time difference ignoring summer time and leap seconds
-func calcTimeDiff(t1, t2 time.Time) timeDiff {
-	var diff timeDiff
-	day := int64(time.Hour * 24)
-	d := t2.Sub(t1).Nanoseconds()
-	diff.Days = int(d / day)
-	d -= int64(diff.Days) * day
-	diff.Hours = int(d / int64(time.Hour))
-	d -= int64(diff.Hours) * int64(time.Hour)
-	diff.Minutes = int(d / int64(time.Minute))
-	d -= int64(diff.Minutes) * int64(time.Minute)
-	diff.Seconds = int(d / int64(time.Second))
-	d -= int64(diff.Seconds) * int64(time.Second)
-	diff.Nanoseconds = int(d)
-	return diff
+// aliases returns model aliases assigned by all chats
+func (w *worker) aliases() map[subscription]string {
+	query := w.mustQuery("select endpoint, chat_id, model_id, alias from aliases")
+	defer func() { checkErr(query.Close()) }()
+	aliases := map[subscription]string{}
+	for query.Next() {
+		var s subscription
+		var alias string
+		checkErr(query.Scan(&s.endpoint, &s.chatID, &s.modelID, &alias))
+		aliases[s] = alias
+	}
+	checkErr(query.Err())
+	return aliases
+}
+
+// aliasesForChat returns model aliases assigned by the chat
+func (w *worker) aliasesForChat(endpoint string, chatID int64) map[string]string {
+	query := w.mustQuery("select model_id, alias from aliases where endpoint=? and chat_id=?", endpoint, chatID)
+	defer func() { checkErr(query.Close()) }()
+	aliases := map[string]string{}
+	for query.Next() {
+		var modelID string
+		var alias string
+		checkErr(query.Scan(&modelID, &alias))
+		aliases[modelID] = alias
+	}
+	return aliases
 }
 
-func (w *worker) listModels(endpoint string, chatID int64, now int) {
+func (w *worker) listModelsByCategory(endpoint string, chatID int64, now int) {
 	type data struct {
 		Model    string
 		TimeDiff *timeDiff
 	}
+	type category struct {
+		Category string
+		Models   []data
+	}
 	statuses := w.statusesForChat(endpoint, chatID)
-	var online, offline, denied []data
+	var offline, denied []data
+	byCategory := map[string][]data{}
+	var names []string
 	for _, s := range statuses {
 		data := data{
 			Model:    s.modelID,
 			TimeDiff: w.modelTimeDiff(s.modelID, now),
 		}
 		switch s.status {
-		case lib.StatusOnline:
-			online = append(online, data)
+		case lib.StatusOnline, lib.StatusIdle, lib.StatusPrivate:
+			c := w.categories[s.modelID]
+			if _, ok := byCategory[c]; !ok {
+				names = append(names, c)
+			}
+			byCategory[c] = append(byCategory[c], data)
 		case lib.StatusDenied:
 			denied = append(denied, data)
 		default:
 			offline = append(offline, data)
 		}
 	}
-	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].List, tplData{"online": online, "offline": offline, "denied": denied})
+	sort.Slice(names, func(i, j int) bool {
+		if names[i] == "" || names[j] == "" {
+			return names[j] == ""
+		}
+		return names[i] < names[j]
+	})
+	var categories []category
+	for _, c := range names {
+		categories = append(categories, category{Category: c, Models: byCategory[c]})
+	}
+	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].ListByCategory, tplData{
+		"categories": categories,
+		"offline":    offline,
+		"denied":     denied})
+}
+
+// sessionDuration returns the duration of the last online session of a model,
+// nil is returned if the beginning of the session is unknown
+func (w *worker) sessionDuration(modelID string, now int) *timeDiff {
+	begin, end, _ := w.lastSeenInfo(modelID, now)
+	if begin == 0 {
+		return nil
+	}
+	if end == 0 {
+		end = now
+	}
+	timeDiff := calcTimeDiff(time.Unix(int64(begin), 0), time.Unix(int64(end), 0))
+	return &timeDiff
 }
 
 func (w *worker) modelTimeDiff(modelID string, now int) *timeDiff {
@@ -1131,7 +3992,12 @@ func (w *worker) downloadSuccess(success bool) {
 }
 
 func (w *worker) download(url string) []byte {
-	resp, err := w.clients[0].Client.Get(url)
+	req, err := http.NewRequest("GET", url, nil)
+	checkErr(err)
+	if userAgent := w.headers.UserAgent(); userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+	resp, err := w.clients[0].Client.Do(req)
 	if err != nil {
 		if w.cfg.Debug {
 			ldbg("cannot make image query")
@@ -1169,11 +4035,95 @@ func (w *worker) download(url string) []byte {
 	return data
 }
 
+// cachedDownload downloads an image reusing the one downloaded less than image_cache_seconds ago
+func (w *worker) cachedDownload(url string, now time.Time) []byte {
+	if w.cfg.ImageCacheSeconds == 0 {
+		return w.download(url)
+	}
+	if cached, ok := w.imageCache[url]; ok {
+		if now.Sub(cached.downloaded) < time.Duration(w.cfg.ImageCacheSeconds)*time.Second {
+			w.imageCacheHits++
+			return cached.data
+		}
+		delete(w.imageCache, url)
+	}
+	w.imageCacheMisses++
+	data := w.download(url)
+	if data != nil {
+		w.imageCache[url] = cachedImage{data: data, downloaded: now}
+	}
+	return data
+}
+
+// pruneImageCache removes expired images from the cache
+func (w *worker) pruneImageCache(now time.Time) {
+	for url, cached := range w.imageCache {
+		if now.Sub(cached.downloaded) >= time.Duration(w.cfg.ImageCacheSeconds)*time.Second {
+			delete(w.imageCache, url)
+		}
+	}
+}
+
+// modelImage downloads a model image,
+// the fallback URL is tried for special models if the primary one fails
+func (w *worker) modelImage(modelID string) []byte {
+	now := time.Now()
+	var image []byte
+	if url := w.images[modelID]; url != "" {
+		image = w.cachedDownload(url, now)
+	}
+	if image == nil && w.specialModels[modelID] && w.fallbackImageURL != nil {
+		if w.cfg.Debug {
+			ldbg("trying fallback image for the model %s", modelID)
+		}
+		image = w.cachedDownload(templateToString(w.fallbackImageURL, "fallback_image_url", tplData{"model": modelID}), now)
+	}
+	return image
+}
+
+// notificationImage downloads a fresh image of a special model right before a notification,
+// the image from the last poll is used for other models or if the fresh one cannot be downloaded
+func (w *worker) notificationImage(modelID string, now int) []byte {
+	if w.specialModels[modelID] && w.freshImageURL != nil {
+		if image := w.download(templateToString(w.freshImageURL, "fresh_image_url", tplData{"model": modelID, "timestamp": now})); image != nil {
+			return image
+		}
+		if w.cfg.Debug {
+			ldbg("cannot download fresh image for the model %s", modelID)
+		}
+	}
+	return w.modelImage(modelID)
+}
+
+// imageLink returns the link to the image of the model through the image proxy,
+// it returns an empty string if the proxy is not configured or the image is unknown
+func (w *worker) imageLink(modelID string) string {
+	image := w.images[modelID]
+	if w.cfg.ImageProxyURL == "" || image == "" {
+		return ""
+	}
+	separator := "?"
+	if strings.Contains(w.cfg.ImageProxyURL, "?") {
+		separator = "&"
+	}
+	return w.cfg.ImageProxyURL + separator + "u=" + url.QueryEscape(image)
+}
+
+// previewLink returns the link to the image of the model for the users receiving images as links,
+// the original image URL is used if the image proxy is not configured
+func (w *worker) previewLink(modelID string) string {
+	if link := w.imageLink(modelID); link != "" {
+		return link
+	}
+	return w.images[modelID]
+}
+
 func (w *worker) listOnlineModels(endpoint string, chatID int64, now int) {
 	statuses := w.statusesForChat(endpoint, chatID)
+	user, _ := w.user(chatID)
 	var online []model
 	for _, s := range statuses {
-		if s.status == lib.StatusOnline {
+		if present(s.status) && w.onlineLongEnough(s.modelID, user.minOnlineMinutes, now) {
 			online = append(online, s)
 		}
 	}
@@ -1182,13 +4132,20 @@ func (w *worker) listOnlineModels(endpoint string, chatID int64, now int) {
 		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].TooManySubscriptionsForPics, data)
 		return
 	}
-	for _, s := range online {
-		imageURL := w.images[s.modelID]
-		var image []byte
-		if imageURL != "" {
-			image = w.download(imageURL)
+	aliases := w.aliasesForChat(endpoint, chatID)
+	tags := w.tagsForChat(endpoint, chatID)
+	sort.SliceStable(online, func(i, j int) bool { return tags[online[i].modelID] < tags[online[j].modelID] })
+	for i, s := range online {
+		if tag := tags[s.modelID]; tag != "" && (i == 0 || tags[online[i-1].modelID] != tag) {
+			w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].TagHeader, tplData{"tag": tag})
+		}
+		image := w.modelImage(s.modelID)
+		data := tplData{
+			"model":     s.modelID,
+			"alias":     aliases[s.modelID],
+			"time_diff": w.modelTimeDiff(s.modelID, now),
+			"image_url": w.imageLink(s.modelID),
 		}
-		data := tplData{"model": s.modelID, "time_diff": w.modelTimeDiff(s.modelID, now)}
 		if image == nil {
 			w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].Online, data)
 		} else {
@@ -1200,75 +4157,368 @@ func (w *worker) listOnlineModels(endpoint string, chatID int64, now int) {
 	}
 }
 
-func (w *worker) week(modelID string) ([]bool, time.Time) {
-	now := time.Now()
-	nowTimestamp := int(now.Unix())
-	today := now.Truncate(24 * time.Hour)
-	start := today.Add(-6 * 24 * time.Hour)
-	weekTimestamp := int(start.Unix())
-	query := w.mustQuery(`
-		select status, timestamp, prev_status, prev_timestamp
-		from(
-			select
-				*,
-				lag(status) over (order by timestamp) as prev_status,
-				lag(timestamp) over (order by timestamp) as prev_timestamp
-			from status_changes
-			where model_id=?)
-		where timestamp>=?
-		order by timestamp`,
-		modelID,
-		weekTimestamp)
+// week returns the hours the model was online since the local midnight six days ago
+func (w *worker) week(modelID string, location *time.Location) ([]bool, time.Time) {
+	now := time.Now().In(location)
+	start := time.Date(now.Year(), now.Month(), now.Day()-6, 0, 0, 0, 0, location)
+	return w.onlineHours(modelID, int(start.Unix()), int(now.Unix())), start
+}
+
+// onlineHours returns the hours from start to end when the model was online, idle or in a private show
+func (w *worker) onlineHours(modelID string, start int, end int) []bool {
 	var changes []statusChange
-	first := true
+	var prev statusChange
+	if w.maybeRecord(
+		"select status, timestamp from status_changes where model_id=? and timestamp<? order by timestamp desc limit 1",
+		queryParams{modelID, start},
+		record{&prev.status, &prev.timestamp},
+	) {
+		changes = append(changes, prev)
+	}
+	query := w.mustQuery(
+		"select status, timestamp from status_changes where model_id=? and timestamp>=? and timestamp<? order by timestamp",
+		modelID,
+		start,
+		end)
+	defer func() { checkErr(query.Close()) }()
 	for query.Next() {
 		var change statusChange
-		var firstStatus *lib.StatusKind
-		var firstTimestamp *int
-		checkErr(query.Scan(&change.status, &change.timestamp, &firstStatus, &firstTimestamp))
-		if first && firstStatus != nil && firstTimestamp != nil {
-			changes = append(changes, statusChange{status: *firstStatus, timestamp: *firstTimestamp})
-			first = false
-		}
+		checkErr(query.Scan(&change.status, &change.timestamp))
 		changes = append(changes, change)
 	}
 
-	changes = append(changes, statusChange{timestamp: nowTimestamp})
-	hours := make([]bool, (nowTimestamp-weekTimestamp+3599)/3600)
+	changes = append(changes, statusChange{timestamp: end})
+	hours := make([]bool, (end-start+3599)/3600)
 	for i, c := range changes[:len(changes)-1] {
-		if c.status == lib.StatusOnline {
-			begin := (c.timestamp - weekTimestamp) / 3600
+		if present(c.status) {
+			begin := (c.timestamp - start) / 3600
 			if begin < 0 {
 				begin = 0
 			}
-			end := (changes[i+1].timestamp - weekTimestamp + 3599) / 3600
+			end := (changes[i+1].timestamp - start + 3599) / 3600
 			for j := begin; j < end; j++ {
 				hours[j] = true
 			}
 		}
 	}
-	return hours, start
+	return hours
+}
+
+// mostActiveHour returns the UTC hour a model was online most often in the previous 7 days
+func (w *worker) mostActiveHour(modelID string) (hour int, found bool) {
+	hours, _ := w.week(modelID, time.UTC)
+	var counts [24]int
+	for i, online := range hours {
+		if online {
+			counts[i%24]++
+		}
+	}
+	for i, c := range counts {
+		if c > counts[hour] {
+			hour = i
+		}
+	}
+	return hour, counts[hour] > 0
+}
+
+// nextReminder returns the timestamp of the next reminder before the specified UTC hour
+func nextReminder(hour int, leadMinutes int, now int) int {
+	day := time.Unix(int64(now), 0).UTC().Truncate(24 * time.Hour)
+	next := day.Add(time.Duration(hour)*time.Hour - time.Duration(leadMinutes)*time.Minute)
+	for int(next.Unix()) <= now {
+		next = next.Add(24 * time.Hour)
+	}
+	return int(next.Unix())
+}
+
+func (w *worker) remind(endpoint string, chatID int64, modelID string, now int) {
+	if modelID == "" {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].SyntaxRemind, nil)
+		return
+	}
+	modelID = w.modelIDPreprocessing(modelID)
+	if !lib.ModelIDRegexp.MatchString(modelID) {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].InvalidSymbols, tplData{"model": modelID})
+		return
+	}
+	exists := w.mustInt("select count(*) from reminders where endpoint=? and chat_id=? and model_id=?", endpoint, chatID, modelID) != 0
+	if !exists && w.mustInt("select count(*) from reminders where endpoint=? and chat_id=?", endpoint, chatID) >= w.cfg.MaxReminders {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].TooManyReminders, tplData{"max_reminders": w.cfg.MaxReminders})
+		return
+	}
+	hour, found := w.mostActiveHour(modelID)
+	if !found {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].NoSchedule, tplData{"model": modelID})
+		return
+	}
+	w.mustExec(`
+		insert into reminders (endpoint, chat_id, model_id, hour, next_timestamp) values (?,?,?,?,?)
+		on conflict(endpoint, chat_id, model_id) do update set hour=excluded.hour, next_timestamp=excluded.next_timestamp`,
+		endpoint,
+		chatID,
+		modelID,
+		hour,
+		nextReminder(hour, w.cfg.ReminderLeadMinutes, now))
+	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].ReminderSet, tplData{"model": modelID, "hour": hour})
+}
+
+func (w *worker) unremind(endpoint string, chatID int64, modelID string) {
+	modelID = w.modelIDPreprocessing(modelID)
+	result, err := w.db.Exec(w.dialect.rebind("delete from reminders where endpoint=? and chat_id=? and model_id=?"), endpoint, chatID, modelID)
+	checkErr(err)
+	removed, err := result.RowsAffected()
+	checkErr(err)
+	if removed == 0 {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].SyntaxRemind, nil)
+		return
+	}
+	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].OK, nil)
+}
+
+type reminder struct {
+	endpoint string
+	chatID   int64
+	modelID  string
+	hour     int
+}
+
+func (w *worker) dueReminders(now int) (reminders []reminder) {
+	query := w.mustQuery("select endpoint, chat_id, model_id, hour from reminders where next_timestamp<=?", now)
+	defer func() { checkErr(query.Close()) }()
+	for query.Next() {
+		var r reminder
+		checkErr(query.Scan(&r.endpoint, &r.chatID, &r.modelID, &r.hour))
+		reminders = append(reminders, r)
+	}
+	return
+}
+
+// sendReminders sends due reminders and reschedules them
+// to the most active hour of a model computed anew
+func (w *worker) sendReminders(now int) {
+	for _, r := range w.dueReminders(now) {
+		if _, ok := w.tr[r.endpoint]; !ok {
+			lerr("unknown endpoint for reminder: %s", r.endpoint)
+			continue
+		}
+		w.sendTr(w.lowPriorityMsg, r.endpoint, r.chatID, true, w.tr[r.endpoint].Reminder, tplData{"model": r.modelID, "hour": r.hour})
+		hour, found := w.mostActiveHour(r.modelID)
+		if !found {
+			hour = r.hour
+		}
+		w.mustExec("update reminders set hour=?, next_timestamp=? where endpoint=? and chat_id=? and model_id=?",
+			hour,
+			nextReminder(hour, w.cfg.ReminderLeadMinutes, now),
+			r.endpoint,
+			r.chatID,
+			r.modelID)
+	}
+}
+
+var feedbackCategories = []string{"bug", "request", "payment", "other"}
+
+// feedbackPromptSeconds is the time the bot waits for the feedback text after a category is selected
+const feedbackPromptSeconds = 10 * 60
+
+// askFeedbackCategory shows feedback categories as inline buttons
+func (w *worker) askFeedbackCategory(endpoint string, chatID int64) {
+	tpl := w.chatTemplates(endpoint, chatID)
+	var buttons [][]tg.InlineKeyboardButton
+	for _, c := range feedbackCategories {
+		buttonText := templateToString(tpl, w.tr[endpoint].FeedbackCategoryButton.Key, tplData{"category": c})
+		buttons = append(buttons, []tg.InlineKeyboardButton{tg.NewInlineKeyboardButtonData(buttonText, "feedback_category "+c)})
+	}
+	text := templateToString(tpl, w.tr[endpoint].FeedbackCategories.Key, nil)
+	msg := tg.NewMessage(chatID, text)
+	msg.ReplyMarkup = tg.NewInlineKeyboardMarkup(buttons...)
+	w.enqueueMessage(w.highPriorityMsg, endpoint, &messageConfig{msg})
+}
+
+// selectFeedbackCategory remembers the category until the next message of the chat
+// or until the prompt expires
+func (w *worker) selectFeedbackCategory(endpoint string, chatID int64, category string, now int) {
+	valid := false
+	for _, c := range feedbackCategories {
+		valid = valid || c == category
+	}
+	if !valid {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].SyntaxFeedback, nil)
+		return
+	}
+	w.pendingFeedback[chat{endpoint: endpoint, chatID: chatID}] = feedbackRequest{category: category, expires: now + feedbackPromptSeconds}
+	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].FeedbackPrompt, nil)
+}
+
+// dropExpiredFeedbackRequests forgets the feedback categories of the chats that have not sent the text in time
+func (w *worker) dropExpiredFeedbackRequests(now int) {
+	for k, v := range w.pendingFeedback {
+		if v.expires <= now {
+			delete(w.pendingFeedback, k)
+		}
+	}
 }
 
-func (w *worker) feedback(endpoint string, chatID int64, text string) {
+func (w *worker) feedback(endpoint string, chatID int64, category string, text string, now int) {
 	if text == "" {
 		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].SyntaxFeedback, nil)
 		return
 	}
-	w.mustExec("insert into feedback (endpoint, chat_id, text) values (?, ?, ?)", endpoint, chatID, text)
+	w.mustExec("insert into feedback (endpoint, chat_id, text, category, timestamp) values (?, ?, ?, ?, ?)", endpoint, chatID, text, category, now)
 	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].Feedback, nil)
 	user := w.mustUser(chatID)
 	if !user.blacklist {
-		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, true, true, lib.ParseRaw, fmt.Sprintf("Feedback from %d: %s", chatID, text))
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, true, true, lib.ParseRaw, fmt.Sprintf("Feedback from %d [%s]: %s", chatID, category, text))
+	}
+}
+
+// limit returns the maximum number of subscriptions of the user,
+// the users not created yet get the default one
+func (w *worker) limit(chatID int64) int {
+	if user, found := w.user(chatID); found {
+		return user.maxModels
+	}
+	return w.cfg.MaxModels
+}
+
+// userEndpoints returns the endpoints the user has started the bot on
+func (w *worker) userEndpoints(chatID int64) (endpoints []string) {
+	rows := w.mustQuery("select endpoint from emails where chat_id=? order by endpoint", chatID)
+	defer func() { checkErr(rows.Close()) }()
+	for rows.Next() {
+		var endpoint string
+		checkErr(rows.Scan(&endpoint))
+		endpoints = append(endpoints, endpoint)
+	}
+	checkErr(rows.Err())
+	return
+}
+
+// changeLimit applies a change of the maximum number of subscriptions of the user,
+// every change of the limit goes through it,
+// it tells the user the old and the new limits on every endpoint of the user
+// unless the caller reports the change by its own message like completed and revoked payments do
+func (w *worker) changeLimit(chatID int64, report bool, change func()) {
+	oldLimit := w.limit(chatID)
+	change()
+	newLimit := w.limit(chatID)
+	if newLimit == oldLimit || !report {
+		return
+	}
+	for _, endpoint := range w.userEndpoints(chatID) {
+		if w.tr[endpoint] == nil {
+			continue
+		}
+		w.sendTr(w.lowPriorityMsg, endpoint, chatID, false, w.tr[endpoint].LimitChanged, tplData{
+			"old_limit": oldLimit,
+			"new_limit": newLimit,
+		})
+	}
+}
+
+func (w *worker) setLimit(chatID int64, maxModels int) {
+	w.changeLimit(chatID, true, func() {
+		w.mustExec(`
+			insert into users (chat_id, max_models) values (?, ?)
+			on conflict(chat_id) do update set max_models=excluded.max_models, temp_limit_until=0`,
+			chatID,
+			maxModels)
+	})
+}
+
+// tempLimit sets the maximum number of subscriptions of a user for some hours,
+// extending an active temporary limit keeps the limit it reverts to
+func (w *worker) tempLimit(endpoint string, arguments string, now int) {
+	parts := strings.Fields(arguments)
+	if len(parts) != 3 {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "usage: /temp_limit chat_ID max_models hours")
+		return
+	}
+	who, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "first argument is invalid")
+		return
+	}
+	maxModels, err := strconv.Atoi(parts[1])
+	if err != nil || maxModels < 0 {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "second argument is invalid")
+		return
+	}
+	hours, err := strconv.Atoi(parts[2])
+	if err != nil || hours <= 0 {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "third argument is invalid")
+		return
+	}
+	original := w.cfg.MaxModels
+	var current, until int
+	if w.maybeRecord("select max_models, temp_limit_until, temp_limit_original from users where chat_id=?",
+		queryParams{who},
+		record{&current, &until, &original}) && until == 0 {
+		original = current
+	}
+	until = now + hours*60*60
+	w.changeLimit(who, true, func() {
+		w.mustExec(`
+			insert into users (chat_id, max_models, temp_limit_until, temp_limit_original, temp_limit_granted) values (?,?,?,?,?)
+			on conflict(chat_id) do update set
+				max_models=excluded.max_models,
+				temp_limit_until=excluded.temp_limit_until,
+				temp_limit_original=excluded.temp_limit_original,
+				temp_limit_granted=excluded.temp_limit_granted`,
+			who, maxModels, until, original, maxModels)
+	})
+	text := fmt.Sprintf("chat %d can subscribe to %d models until %s, then the limit reverts to %d",
+		who, maxModels, time.Unix(int64(until), 0).UTC().Format("2006-01-02 15:04 UTC"), original)
+	w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, text)
+}
+
+// revertTempLimits restores the limits of expired temporary limits
+// keeping subscriptions the users got meanwhile by referrals or payments
+func (w *worker) revertTempLimits(now int) {
+	query := w.mustQuery(`
+		select chat_id, max_models - temp_limit_granted + temp_limit_original
+		from users where temp_limit_until != 0 and temp_limit_until <= ?`,
+		now)
+	type revert struct {
+		chatID    int64
+		maxModels int
+	}
+	var reverts []revert
+	for query.Next() {
+		var r revert
+		checkErr(query.Scan(&r.chatID, &r.maxModels))
+		if r.maxModels < 0 {
+			r.maxModels = 0
+		}
+		reverts = append(reverts, r)
+	}
+	checkErr(query.Close())
+	for _, r := range reverts {
+		w.changeLimit(r.chatID, true, func() {
+			w.mustExec("update users set max_models=?, temp_limit_until=0 where chat_id=?", r.maxModels, r.chatID)
+		})
+		text := fmt.Sprintf("temporary limit of chat %d expired, the limit reverted to %d", r.chatID, r.maxModels)
+		w.sendText(w.highPriorityMsg, w.cfg.AdminEndpoint, w.cfg.AdminID, false, true, lib.ParseRaw, text)
 	}
 }
 
-func (w *worker) setLimit(chatID int64, maxModels int) {
-	w.mustExec(`
-		insert into users (chat_id, max_models) values (?, ?)
-		on conflict(chat_id) do update set max_models=excluded.max_models`,
-		chatID,
-		maxModels)
+func (w *worker) setDailyNotifications(endpoint string, arguments string) {
+	parts := strings.Fields(arguments)
+	if len(parts) != 2 {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "usage: /daily_notifications chat_ID number")
+		return
+	}
+	who, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "first argument is invalid")
+		return
+	}
+	limit, err := strconv.Atoi(parts[1])
+	if err != nil || limit < 0 {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "second argument is invalid")
+		return
+	}
+	w.mustExec("update users set daily_notifications=? where chat_id=?", limit, who)
+	w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "OK")
 }
 
 func (w *worker) unsuccessfulRequestsCount() int {
@@ -1305,7 +4555,7 @@ func (w *worker) reports() int {
 
 func (w *worker) interactions(endpoint string) map[int]int {
 	timestamp := time.Now().Add(time.Hour * -24).Unix()
-	query := w.mustQuery("select result, count(*) from interactions where endpoint=? and timestamp>? group by result", endpoint, timestamp)
+	query := w.mustQuery("select result, count(*) from interactions where endpoint in (?, ?) and timestamp>? group by result", endpoint, canaryEndpoint(endpoint), timestamp)
 	defer func() { checkErr(query.Close()) }()
 	results := map[int]int{}
 	for query.Next() {
@@ -1318,11 +4568,11 @@ func (w *worker) interactions(endpoint string) map[int]int {
 }
 
 func (w *worker) usersCount(endpoint string) int {
-	return w.mustInt("select count(distinct chat_id) from signals where endpoint=?", endpoint)
+	return w.mustInt("select count(distinct chat_id) from signals where endpoint in (?, ?)", endpoint, canaryEndpoint(endpoint))
 }
 
 func (w *worker) groupsCount(endpoint string) int {
-	return w.mustInt("select count(distinct chat_id) from signals where endpoint=? and chat_id < 0", endpoint)
+	return w.mustInt("select count(distinct chat_id) from signals where endpoint in (?, ?) and chat_id < 0", endpoint, canaryEndpoint(endpoint))
 }
 
 func (w *worker) activeUsersOnEndpointCount(endpoint string) int {
@@ -1330,8 +4580,9 @@ func (w *worker) activeUsersOnEndpointCount(endpoint string) int {
 		select count(distinct signals.chat_id)
 		from signals
 		left join block on signals.chat_id=block.chat_id and signals.endpoint=block.endpoint
-		where (block.block is null or block.block = 0) and signals.endpoint=?`,
-		endpoint)
+		where (block.block is null or block.block = 0) and signals.endpoint in (?, ?)`,
+		endpoint,
+		canaryEndpoint(endpoint))
 }
 
 func (w *worker) activeUsersTotalCount() int {
@@ -1343,7 +4594,7 @@ func (w *worker) activeUsersTotalCount() int {
 }
 
 func (w *worker) modelsCount(endpoint string) int {
-	return w.mustInt("select count(distinct model_id) from signals where endpoint=?", endpoint)
+	return w.mustInt("select count(distinct model_id) from signals where endpoint in (?, ?)", endpoint, canaryEndpoint(endpoint))
 }
 
 func (w *worker) modelsToPollOnEndpointCount(endpoint string) int {
@@ -1351,9 +4602,10 @@ func (w *worker) modelsToPollOnEndpointCount(endpoint string) int {
 		select count(distinct signals.model_id)
 		from signals
 		left join block on signals.chat_id=block.chat_id and signals.endpoint=block.endpoint
-		where (block.block is null or block.block < ?) and signals.endpoint=?`,
+		where (block.block is null or block.block < ?) and signals.endpoint in (?, ?)`,
 		w.cfg.BlockThreshold,
-		endpoint)
+		endpoint,
+		canaryEndpoint(endpoint))
 }
 
 func (w *worker) modelsToPollTotalCount() int {
@@ -1366,7 +4618,7 @@ func (w *worker) modelsToPollTotalCount() int {
 }
 
 func (w *worker) statusChangesCount() int {
-	return w.mustInt("select max(_rowid_) from status_changes")
+	return w.mustInt(w.dialect.rowCount("status_changes"))
 }
 
 func (w *worker) heavyUsersCount(endpoint string) int {
@@ -1374,19 +4626,20 @@ func (w *worker) heavyUsersCount(endpoint string) int {
 		select count(*) from (
 			select 1 from signals
 			left join block on signals.chat_id=block.chat_id and signals.endpoint=block.endpoint
-			where (block.block is null or block.block = 0) and signals.endpoint=?
+			where (block.block is null or block.block = 0) and signals.endpoint in (?, ?)
 			group by signals.chat_id
 			having count(*) >= ?);`,
 		endpoint,
+		canaryEndpoint(endpoint),
 		w.cfg.MaxModels-w.cfg.HeavyUserRemainder)
 }
 
 func (w *worker) transactionsOnEndpoint(endpoint string) int {
-	return w.mustInt("select count(*) from transactions where endpoint=?", endpoint)
+	return w.mustInt("select count(*) from transactions where endpoint in (?, ?)", endpoint, canaryEndpoint(endpoint))
 }
 
 func (w *worker) transactionsOnEndpointFinished(endpoint string) int {
-	return w.mustInt("select count(*) from transactions where endpoint=? and status=?", endpoint, payments.StatusFinished)
+	return w.mustInt("select count(*) from transactions where endpoint in (?, ?) and status=?", endpoint, canaryEndpoint(endpoint), payments.StatusFinished)
 }
 
 func (w *worker) statStrings(endpoint string) []string {
@@ -1411,90 +4664,825 @@ func (w *worker) statStrings(endpoint string) []string {
 		fmt.Sprintf("Model referrals: %d", stat.ModelReferralsCount),
 		fmt.Sprintf("Changes in period: %d", stat.ChangesInPeriod),
 		fmt.Sprintf("Confirmed changes in period: %d", stat.ConfirmedChangesInPeriod),
+		fmt.Sprintf("Came online in period: %d", stat.OnlineChangesInPeriod),
+		fmt.Sprintf("Went offline in period: %d", stat.OfflineChangesInPeriod),
+		fmt.Sprintf("Confirmation factor: %d", stat.ConfirmationFactor),
+	}
+}
+
+func (w *worker) stat(endpoint string) {
+	w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, true, true, lib.ParseRaw, strings.Join(w.statStrings(endpoint), "\n"))
+}
+
+func (w *worker) performanceStat(endpoint string) {
+	durations := w.durations
+	var queries []string
+	for x := range durations {
+		queries = append(queries, x)
+	}
+	sort.SliceStable(queries, func(i, j int) bool {
+		return durations[queries[i]].total() > durations[queries[j]].total()
+	})
+	for _, x := range queries {
+		lines := []string{
+			fmt.Sprintf("<b>Desc</b>: %s", html.EscapeString(x)),
+			fmt.Sprintf("<b>Total</b>: %d", int(durations[x].avg*float64(durations[x].count)*1000.)),
+			fmt.Sprintf("<b>Avg</b>: %d", int(durations[x].avg*1000.)),
+			fmt.Sprintf("<b>Count</b>: %d", durations[x].count),
+		}
+		entry := strings.Join(lines, "\n")
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseHTML, entry)
+	}
+}
+
+// maxMessageLength is the maximum number of characters in a Telegram message
+const maxMessageLength = 4096
+
+// maxBroadcastParts is the maximum number of messages a broadcast text is split into
+const maxBroadcastParts = 5
+
+// splitMessage splits the text into parts fitting into a Telegram message,
+// it splits at line breaks if possible
+func splitMessage(text string, limit int) []string {
+	var parts []string
+	runes := []rune(text)
+	for len(runes) > limit {
+		end := limit
+		for i := limit; i > 0; i-- {
+			if runes[i] == '\n' {
+				end = i
+				break
+			}
+		}
+		parts = append(parts, string(runes[:end]))
+		runes = runes[end:]
+		for len(runes) > 0 && runes[0] == '\n' {
+			runes = runes[1:]
+		}
+	}
+	if len(runes) > 0 {
+		parts = append(parts, string(runes))
+	}
+	return parts
+}
+
+// broadcast sends the text to every chat of the endpoint,
+// the text exceeding the message length is sent as several sequential messages
+func (w *worker) broadcast(endpoint string, text string) {
+	if text == "" {
+		return
+	}
+	parts := splitMessage(text, maxMessageLength)
+	if len(parts) > maxBroadcastParts {
+		text := fmt.Sprintf("the text is too long, the maximum is %d characters", maxBroadcastParts*maxMessageLength)
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, text)
+		return
+	}
+	if w.cfg.Debug {
+		ldbg("broadcasting")
+	}
+	now := int(time.Now().Unix())
+	chats := w.broadcastChats(endpoint)
+	tx, err := w.db.Begin()
+	checkErr(err)
+	_, err = tx.Exec(w.dialect.rebind("insert into broadcasts (endpoint, text, total, started_at, reported_at) values (?, ?, ?, ?, ?)"),
+		endpoint, text, len(chats), now, now)
+	checkErr(err)
+	var id int64
+	checkErr(tx.QueryRow("select max(id) from broadcasts").Scan(&id))
+	stmt := w.mustPrepare(tx, "insert into broadcast_chats (broadcast_id, endpoint, chat_id) values (?, ?, ?)")
+	for _, c := range chats {
+		w.mustExecPrepared("insert into broadcast_chats", stmt, id, c.endpoint, c.chatID)
+	}
+	checkErr(stmt.Close())
+	checkErr(tx.Commit())
+	result := fmt.Sprintf("broadcast %d is started, chats: %d", id, len(chats))
+	if len(parts) > 1 {
+		result += fmt.Sprintf(", the text is split into %d messages", len(parts))
+	}
+	w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, result)
+	w.feedBroadcasts(now)
+}
+
+// broadcast chat states
+const (
+	broadcastPending = iota
+	broadcastQueued
+	broadcastSent
+	broadcastFailed
+	broadcastBlocked
+)
+
+// broadcastProgress returns the progress of the broadcast
+func (w *worker) broadcastProgress(id int64) (progress string, pending int, queued int) {
+	var total int
+	var canceled, finished bool
+	w.maybeRecord("select total, canceled, finished from broadcasts where id=?", queryParams{id}, record{&total, &canceled, &finished})
+	counts := map[int]int{}
+	rows := w.mustQuery("select state, count(*) from broadcast_chats where broadcast_id=? group by state", id)
+	defer func() { checkErr(rows.Close()) }()
+	for rows.Next() {
+		var state, count int
+		checkErr(rows.Scan(&state, &count))
+		counts[state] = count
+	}
+	state := "in progress"
+	switch {
+	case canceled:
+		state = "canceled"
+	case finished:
+		state = "finished"
+	}
+	progress = fmt.Sprintf("broadcast %d is %s, sent: %d, failed: %d, blocked: %d, pending: %d of %d",
+		id, state, counts[broadcastSent], counts[broadcastFailed], counts[broadcastBlocked], counts[broadcastPending]+counts[broadcastQueued], total)
+	return progress, counts[broadcastPending], counts[broadcastQueued]
+}
+
+// feedBroadcasts queues the next batch of every running broadcast once its previous batch is sent,
+// so that broadcasts do not flood the outgoing queue,
+// it also reports the progress of the broadcasts to the admin
+func (w *worker) feedBroadcasts(now int) {
+	type running struct {
+		id         int64
+		endpoint   string
+		text       string
+		reportedAt int
+	}
+	var broadcasts []running
+	rows := w.mustQuery("select id, endpoint, text, reported_at from broadcasts where canceled=0 and finished=0 order by id")
+	for rows.Next() {
+		var b running
+		checkErr(rows.Scan(&b.id, &b.endpoint, &b.text, &b.reportedAt))
+		broadcasts = append(broadcasts, b)
+	}
+	checkErr(rows.Close())
+	for _, b := range broadcasts {
+		progress, pending, queued := w.broadcastProgress(b.id)
+		if pending == 0 && queued == 0 {
+			w.mustExec("update broadcasts set finished=1 where id=?", b.id)
+			progress, _, _ = w.broadcastProgress(b.id)
+			w.sendText(w.highPriorityMsg, b.endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, progress)
+			linf("broadcast %d is finished", b.id)
+			continue
+		}
+		if now-b.reportedAt >= w.cfg.BroadcastProgressSeconds {
+			w.mustExec("update broadcasts set reported_at=? where id=?", now, b.id)
+			w.sendText(w.highPriorityMsg, b.endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, progress)
+		}
+		if queued > 0 {
+			continue
+		}
+		parts := splitMessage(b.text, maxMessageLength)
+		batch := w.cfg.BroadcastBatchSize
+		if room := (cap(w.lowPriorityMsg) - len(w.lowPriorityMsg)) / len(parts); room < batch {
+			batch = room
+		}
+		var chats []chat
+		chatsQuery := w.mustQuery("select endpoint, chat_id from broadcast_chats where broadcast_id=? and state=? order by chat_id limit ?", b.id, broadcastPending, batch)
+		for chatsQuery.Next() {
+			var c chat
+			checkErr(chatsQuery.Scan(&c.endpoint, &c.chatID))
+			chats = append(chats, c)
+		}
+		checkErr(chatsQuery.Close())
+	feed:
+		for _, c := range chats {
+			// other senders share the queue, a chat is fed only if all the parts fit
+			if cap(w.lowPriorityMsg)-len(w.lowPriorityMsg) < len(parts) {
+				break
+			}
+			for _, part := range parts {
+				msg := tg.NewMessage(c.chatID, part)
+				queued := w.enqueuePacket(w.lowPriorityMsg, outgoingPacket{
+					endpoint:    c.endpoint,
+					message:     &messageConfig{msg},
+					requested:   time.Now(),
+					broadcastID: b.id,
+				})
+				if !queued {
+					// the chat stays pending and gets the broadcast with the next batch
+					break feed
+				}
+			}
+			w.mustExec("update broadcast_chats set state=? where broadcast_id=? and chat_id=?", broadcastQueued, b.id, c.chatID)
+		}
+	}
+}
+
+// processBroadcastResult records the final result of sending a broadcast message to a chat,
+// the results of the attempts to be retried are skipped,
+// the first final result of a chat counts if the text is split into several messages
+func (w *worker) processBroadcastResult(r msgSendResult) {
+	if r.broadcastID == 0 {
+		return
+	}
+	var state int
+	switch r.result {
+	case messageTimeout, messageUnknownNetworkError, messageTooManyRequests:
+		return
+	case messageSent:
+		state = broadcastSent
+	case messageBlocked:
+		state = broadcastBlocked
+	default:
+		state = broadcastFailed
+	}
+	w.mustExec("update broadcast_chats set state=? where broadcast_id=? and chat_id=? and state=?", state, r.broadcastID, r.chatID, broadcastQueued)
+}
+
+// cancelBroadcasts cancels the broadcast with the given ID or all running broadcasts of the endpoint,
+// the messages already queued are still sent
+func (w *worker) cancelBroadcasts(endpoint string, arguments string) {
+	var ids []int64
+	if arguments != "" {
+		id, err := strconv.ParseInt(arguments, 10, 64)
+		if err != nil {
+			w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "usage: /broadcast_cancel [broadcast_ID]")
+			return
+		}
+		if w.mustInt("select count(*) from broadcasts where id=? and endpoint=? and canceled=0 and finished=0", id, endpoint) != 0 {
+			ids = append(ids, id)
+		}
+	} else {
+		rows := w.mustQuery("select id from broadcasts where endpoint=? and canceled=0 and finished=0 order by id", endpoint)
+		for rows.Next() {
+			var id int64
+			checkErr(rows.Scan(&id))
+			ids = append(ids, id)
+		}
+		checkErr(rows.Close())
+	}
+	if len(ids) == 0 {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "no running broadcasts")
+		return
+	}
+	for _, id := range ids {
+		w.mustExec("update broadcasts set canceled=1 where id=?", id)
+		progress, _, _ := w.broadcastProgress(id)
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, progress)
+		linf("broadcast %d is canceled", id)
+	}
+}
+
+// resumeBroadcasts queues again the chats of running broadcasts whose messages were lost on restart
+func (w *worker) resumeBroadcasts() {
+	w.mustExec(`
+		update broadcast_chats set state=?
+		where state=? and broadcast_id in (select id from broadcasts where canceled=0 and finished=0)`,
+		broadcastPending,
+		broadcastQueued)
+}
+
+func parseScheduleTime(s string) (time.Time, error) {
+	if timestamp, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(timestamp, 0), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse time %q", s)
+}
+
+func (w *worker) scheduleBroadcast(endpoint string, arguments string, now int) {
+	parts := strings.SplitN(arguments, " ", 2)
+	if len(parts) < 2 || strings.TrimSpace(parts[1]) == "" {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "usage: /schedule_broadcast unix_time_or_YYYY-MM-DDTHH:MM text")
+		return
+	}
+	at, err := parseScheduleTime(parts[0])
+	if err != nil {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "first argument is invalid")
+		return
+	}
+	if int(at.Unix()) <= now {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "time is in the past")
+		return
+	}
+	if len(splitMessage(parts[1], maxMessageLength)) > maxBroadcastParts {
+		text := fmt.Sprintf("the text is too long, the maximum is %d characters", maxBroadcastParts*maxMessageLength)
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, text)
+		return
+	}
+	// the ID is read in the same transaction as the last inserted ID is tracked per connection
+	tx, err := w.db.Begin()
+	checkErr(err)
+	_, err = tx.Exec(w.dialect.rebind("insert into scheduled_broadcasts (endpoint, timestamp, text) values (?,?,?)"), endpoint, at.Unix(), parts[1])
+	checkErr(err)
+	var id int64
+	checkErr(tx.QueryRow(w.dialect.rebind("select last_insert_rowid()")).Scan(&id))
+	checkErr(tx.Commit())
+	audience := len(w.broadcastChats(endpoint))
+	text := fmt.Sprintf("Broadcast %d is scheduled at %s UTC, current audience: %d chats", id, at.UTC().Format("2006-01-02 15:04"), audience)
+	w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, text)
+}
+
+func (w *worker) scheduledBroadcasts(endpoint string) {
+	query := w.mustQuery("select id, timestamp, text from scheduled_broadcasts where endpoint=? order by timestamp", endpoint)
+	defer func() { checkErr(query.Close()) }()
+	var lines []string
+	for query.Next() {
+		var id int
+		var timestamp int64
+		var text string
+		checkErr(query.Scan(&id, &timestamp, &text))
+		lines = append(lines, fmt.Sprintf("%d: %s UTC: %s", id, time.Unix(timestamp, 0).UTC().Format("2006-01-02 15:04"), text))
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "no scheduled broadcasts")
+	}
+	w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, strings.Join(lines, "\n"))
+}
+
+// defaultFeedbackListSize is the number of feedback entries shown if the admin does not specify it
+const defaultFeedbackListSize = 10
+
+// feedbackList shows the latest feedback entries not handled yet, the oldest first
+func (w *worker) feedbackList(endpoint string, arguments string) {
+	n := defaultFeedbackListSize
+	if arguments != "" {
+		var err error
+		n, err = strconv.Atoi(arguments)
+		if err != nil || n <= 0 {
+			w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "usage: /feedback_list [N]")
+			return
+		}
+	}
+	query := w.mustQuery(`
+		select chat_id, category, text, timestamp from feedback
+		where endpoint=? and handled=0
+		order by timestamp desc limit ?`,
+		endpoint,
+		n)
+	defer func() { checkErr(query.Close()) }()
+	var lines []string
+	for query.Next() {
+		var chatID int64
+		var category, text string
+		var timestamp int64
+		checkErr(query.Scan(&chatID, &category, &text, &timestamp))
+		lines = append(lines, fmt.Sprintf("%d [%s] %s UTC: %s", chatID, category, time.Unix(timestamp, 0).UTC().Format("2006-01-02 15:04"), text))
+	}
+	if len(lines) == 0 {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "no feedback")
+		return
+	}
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	for _, part := range splitMessage(strings.Join(lines, "\n"), maxMessageLength) {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, part)
+	}
+}
+
+// feedbackClear marks all the feedback entries of the endpoint as handled
+func (w *worker) feedbackClear(endpoint string) {
+	count := w.mustInt("select count(*) from feedback where endpoint=? and handled=0", endpoint)
+	w.mustExec("update feedback set handled=1 where endpoint=? and handled=0", endpoint)
+	w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, fmt.Sprintf("feedback entries handled: %d", count))
+}
+
+func (w *worker) unschedule(endpoint string, arguments string) {
+	id, err := strconv.ParseInt(arguments, 10, 64)
+	if err != nil {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "first argument is invalid")
+		return
+	}
+	if w.mustInt("select count(*) from scheduled_broadcasts where id=? and endpoint=?", id, endpoint) == 0 {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "broadcast not found")
+		return
+	}
+	w.mustExec("delete from scheduled_broadcasts where id=? and endpoint=?", id, endpoint)
+	w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "OK")
+}
+
+func (w *worker) dueBroadcasts(now int) (ids []int64, endpoints []string, texts []string) {
+	query := w.mustQuery("select id, endpoint, text from scheduled_broadcasts where timestamp<=? order by timestamp", now)
+	defer func() { checkErr(query.Close()) }()
+	for query.Next() {
+		var id int64
+		var endpoint string
+		var text string
+		checkErr(query.Scan(&id, &endpoint, &text))
+		ids = append(ids, id)
+		endpoints = append(endpoints, endpoint)
+		texts = append(texts, text)
+	}
+	return
+}
+
+func (w *worker) fireScheduledBroadcasts(now int) {
+	ids, endpoints, texts := w.dueBroadcasts(now)
+	for i, id := range ids {
+		w.mustExec("delete from scheduled_broadcasts where id=?", id)
+		endpoint := baseEndpoint(endpoints[i])
+		if _, ok := w.cfg.Endpoints[endpoint]; !ok {
+			lerr("unknown endpoint for scheduled broadcast %d: %s", id, endpoints[i])
+			continue
+		}
+		linf("firing scheduled broadcast %d", id)
+		w.broadcast(endpoint, texts[i])
+	}
+}
+
+func (w *worker) direct(endpoint string, arguments string) {
+	parts := strings.SplitN(arguments, " ", 2)
+	if len(parts) < 2 {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "usage: /direct chatID text")
+		return
 	}
+	whom, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "first argument is invalid")
+		return
+	}
+	text := parts[1]
+	if text == "" {
+		return
+	}
+	w.sendText(w.highPriorityMsg, endpoint, whom, true, false, lib.ParseRaw, text)
+	w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "OK")
+}
+
+func (w *worker) blacklist(endpoint string, arguments string) {
+	whom, err := strconv.ParseInt(arguments, 10, 64)
+	if err != nil {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "first argument is invalid")
+		return
+	}
+	w.mustExec("update users set blacklist=1 where chat_id=?", whom)
+	w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "OK")
+}
+
+func (w *worker) addSpecialModel(endpoint string, modelID string) {
+	if _, ok := w.markSpecial(modelID); !ok {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "model ID is invalid")
+		return
+	}
+	w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "OK")
+}
+
+// canonicalModelID returns the canonical model ID if the admin has aliased the model ID
+func (w *worker) canonicalModelID(modelID string) string {
+	if canonicalID, ok := w.modelIDAliases[modelID]; ok {
+		linf("model ID alias is applied: %s -> %s", modelID, canonicalID)
+		return canonicalID
+	}
+	return modelID
+}
+
+// keepUnchecked reports the models a polling checker could not check in their previous statuses
+func (w *worker) keepUnchecked(onlineModels []lib.OnlineModel) []lib.OnlineModel {
+	var result []lib.OnlineModel
+	for _, m := range onlineModels {
+		if m.Unchecked {
+			if !w.siteOnline[m.ModelID] {
+				continue
+			}
+			m = lib.OnlineModel{ModelID: m.ModelID, Idle: w.siteIdle[m.ModelID], Private: w.sitePrivate[m.ModelID]}
+		}
+		result = append(result, m)
+	}
+	return result
+}
+
+// applyModelIDAliases reports the models the website still knows by an old ID by their canonical IDs,
+// an old ID is skipped if the canonical one is also reported
+func (w *worker) applyModelIDAliases(onlineModels []lib.OnlineModel) []lib.OnlineModel {
+	if len(w.modelIDAliases) == 0 {
+		return onlineModels
+	}
+	reported := map[string]bool{}
+	for _, m := range onlineModels {
+		reported[m.ModelID] = true
+	}
+	var result []lib.OnlineModel
+	for _, m := range onlineModels {
+		if canonicalID, ok := w.modelIDAliases[m.ModelID]; ok {
+			if reported[canonicalID] {
+				continue
+			}
+			linf("model ID alias is applied to the status update: %s -> %s", m.ModelID, canonicalID)
+			m.ModelID = canonicalID
+			reported[canonicalID] = true
+		}
+		result = append(result, m)
+	}
+	return result
+}
+
+// aliasModelID maps an old model ID to the canonical one,
+// the subscriptions of the old ID follow the canonical one,
+// the ones conflicting with existing subscriptions are dropped and their chats are reported
+func (w *worker) aliasModelID(endpoint string, arguments string) {
+	parts := strings.Fields(arguments)
+	if len(parts) != 2 {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "usage: /model_alias old_model_ID canonical_model_ID")
+		return
+	}
+	modelID := w.modelIDPreprocessing(parts[0])
+	canonicalID := w.modelIDPreprocessing(parts[1])
+	if !lib.ModelIDRegexp.MatchString(modelID) || !lib.ModelIDRegexp.MatchString(canonicalID) {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "model ID is invalid")
+		return
+	}
+	if modelID == canonicalID {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, fmt.Sprintf("model ID %s is already canonical", modelID))
+		return
+	}
+	tx, err := w.db.Begin()
+	checkErr(err)
+	_, err = tx.Exec(w.dialect.rebind(`
+		insert into model_id_aliases (model_id, canonical_id) values (?, ?)
+		on conflict(model_id) do update set canonical_id=excluded.canonical_id`),
+		modelID,
+		canonicalID)
+	checkErr(err)
+	_, err = tx.Exec(w.dialect.rebind("update model_id_aliases set canonical_id=? where canonical_id=?"), canonicalID, modelID)
+	checkErr(err)
+	lines := []string{fmt.Sprintf("model ID alias is added: %s -> %s", modelID, canonicalID)}
+	for _, table := range []string{"signals", "aliases", "tags", "reminders"} {
+		moved, dropped := w.moveRows(tx, table, "model_id", []string{"endpoint", "chat_id"}, modelID, canonicalID)
+		line := fmt.Sprintf("%s: %d moved, %d dropped as conflicting", table, moved, len(dropped))
+		if len(dropped) > 0 {
+			var chats []string
+			for _, chatID := range dropped {
+				chats = append(chats, strconv.FormatInt(chatID, 10))
+			}
+			line += ", chats: " + strings.Join(chats, ", ")
+		}
+		lines = append(lines, line)
+	}
+	checkErr(tx.Commit())
+	w.modelIDAliases = w.queryModelIDAliases()
+	w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, strings.Join(lines, "\n"))
 }
 
-func (w *worker) stat(endpoint string) {
-	w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, true, true, lib.ParseRaw, strings.Join(w.statStrings(endpoint), "\n"))
+// modelIDAliasesInfo lists the model ID aliases
+func (w *worker) modelIDAliasesInfo() []string {
+	var lines []string
+	for modelID, canonicalID := range w.modelIDAliases {
+		lines = append(lines, fmt.Sprintf("%s -> %s", modelID, canonicalID))
+	}
+	sort.Strings(lines)
+	if len(lines) == 0 {
+		lines = append(lines, "no model ID aliases")
+	}
+	return lines
 }
 
-func (w *worker) performanceStat(endpoint string) {
-	durations := w.durations
-	var queries []string
-	for x := range durations {
-		queries = append(queries, x)
-	}
-	sort.SliceStable(queries, func(i, j int) bool {
-		return durations[queries[i]].total() > durations[queries[j]].total()
-	})
-	for _, x := range queries {
-		lines := []string{
-			fmt.Sprintf("<b>Desc</b>: %s", html.EscapeString(x)),
-			fmt.Sprintf("<b>Total</b>: %d", int(durations[x].avg*float64(durations[x].count)*1000.)),
-			fmt.Sprintf("<b>Avg</b>: %d", int(durations[x].avg*1000.)),
-			fmt.Sprintf("<b>Count</b>: %d", durations[x].count),
-		}
-		entry := strings.Join(lines, "\n")
-		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseHTML, entry)
+// markSpecial marks the model as special in the database and the cache,
+// it returns the preprocessed model ID and false if the ID is invalid
+func (w *worker) markSpecial(modelID string) (string, bool) {
+	modelID = w.modelIDPreprocessing(modelID)
+	if !lib.ModelIDRegexp.MatchString(modelID) {
+		return modelID, false
 	}
+	w.mustExec(`
+		insert into models (model_id, special) values (?,?)
+		on conflict(model_id) do update set special=excluded.special`,
+		modelID,
+		true)
+	w.specialModels[modelID] = true
+	return modelID, true
 }
 
-func (w *worker) broadcast(endpoint string, text string) {
-	if text == "" {
+func (w *worker) setNotificationInterval(endpoint string, arguments string) {
+	parts := strings.Fields(arguments)
+	if len(parts) != 2 {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "usage: /notification_interval model_ID seconds")
 		return
 	}
-	if w.cfg.Debug {
-		ldbg("broadcasting")
+	modelID := w.modelIDPreprocessing(parts[0])
+	if !lib.ModelIDRegexp.MatchString(modelID) {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "model ID is invalid")
+		return
 	}
-	chats := w.broadcastChats(endpoint)
-	for _, chatID := range chats {
-		w.sendText(w.lowPriorityMsg, endpoint, chatID, true, false, lib.ParseRaw, text)
+	if !w.specialModels[modelID] {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "model is not special")
+		return
 	}
-	w.sendText(w.lowPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "OK")
+	interval, err := strconv.Atoi(parts[1])
+	if err != nil || interval < 0 {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "second argument is invalid")
+		return
+	}
+	w.mustExec("update models set notification_interval=? where model_id=?", interval, modelID)
+	w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "OK")
 }
 
-func (w *worker) direct(endpoint string, arguments string) {
-	parts := strings.SplitN(arguments, " ", 2)
-	if len(parts) < 2 {
-		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "usage: /direct chatID text")
+func (w *worker) setOfflineConfirmation(endpoint string, arguments string) {
+	parts := strings.Fields(arguments)
+	if len(parts) != 2 {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "usage: /offline_confirmation model_ID seconds")
 		return
 	}
-	whom, err := strconv.ParseInt(parts[0], 10, 64)
-	if err != nil {
-		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "first argument is invalid")
+	modelID := w.modelIDPreprocessing(parts[0])
+	if !lib.ModelIDRegexp.MatchString(modelID) {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "model ID is invalid")
 		return
 	}
-	text := parts[1]
-	if text == "" {
+	seconds, err := strconv.Atoi(parts[1])
+	if err != nil || seconds < 0 {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "second argument is invalid")
 		return
 	}
-	w.sendText(w.highPriorityMsg, endpoint, whom, true, false, lib.ParseRaw, text)
+	w.mustExec(`
+		insert into models (model_id, offline_confirmation_seconds) values (?,?)
+		on conflict(model_id) do update set offline_confirmation_seconds=excluded.offline_confirmation_seconds`,
+		modelID,
+		seconds)
+	if seconds == 0 {
+		delete(w.offlineConfirmations, modelID)
+	} else {
+		w.offlineConfirmations[modelID] = seconds
+	}
 	w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "OK")
 }
 
-func (w *worker) blacklist(endpoint string, arguments string) {
-	whom, err := strconv.ParseInt(arguments, 10, 64)
+func (w *worker) testIPN(endpoint string) {
+	cp := w.coinPayments(endpoint)
+	if cp == nil {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "CoinPayments is not configured")
+		return
+	}
+	r, err := payments.NewTestIPNRequest("https://"+cp.IPNListenURL, cp.IPNSecret)
 	if err != nil {
-		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "first argument is invalid")
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, fmt.Sprintf("cannot create IPN request, %v", err))
 		return
 	}
-	w.mustExec("update users set blacklist=1 where chat_id=?", whom)
-	w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "OK")
+	status, parsedCustom, err := payments.ParseIPN(r, cp.IPNSecret, w.cfg.Debug)
+	var text string
+	switch {
+	case err != nil:
+		text = fmt.Sprintf("IPN parsing failed, %v", err)
+	case status != payments.StatusFinished || parsedCustom != payments.TestIPNCustom:
+		text = fmt.Sprintf("IPN parsing returned unexpected result, status: %v, custom: %s", status, parsedCustom)
+	default:
+		text = fmt.Sprintf("IPN parsing OK, listen URL: %s", cp.IPNListenURL)
+	}
+	w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, text)
 }
 
-func (w *worker) addSpecialModel(endpoint string, modelID string) {
-	modelID = w.modelIDPreprocessing(modelID)
-	if !lib.ModelIDRegexp.MatchString(modelID) {
-		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "model ID is invalid")
+// dedupSubscriptions merges subscriptions becoming the same after model ID preprocessing
+func (w *worker) dedupSubscriptions() (merged int, renamed int) {
+	type key struct {
+		chatID   int64
+		modelID  string
+		endpoint string
+	}
+	tx, err := w.db.Begin()
+	checkErr(err)
+	query, err := tx.Query(w.dialect.rebind("select chat_id, model_id, endpoint from signals"))
+	checkErr(err)
+	groups := map[key][]string{}
+	for query.Next() {
+		var k key
+		checkErr(query.Scan(&k.chatID, &k.modelID, &k.endpoint))
+		modelID := k.modelID
+		k.modelID = w.modelIDPreprocessing(modelID)
+		groups[k] = append(groups[k], modelID)
+	}
+	checkErr(query.Close())
+	for k, modelIDs := range groups {
+		canonicalExists := false
+		for _, m := range modelIDs {
+			if m == k.modelID {
+				canonicalExists = true
+			}
+		}
+		for i, m := range modelIDs {
+			switch {
+			case m == k.modelID:
+			case !canonicalExists && i == 0:
+				_, err = tx.Exec(w.dialect.rebind("update signals set model_id=? where chat_id=? and model_id=? and endpoint=?"), k.modelID, k.chatID, m, k.endpoint)
+				checkErr(err)
+				_, err = tx.Exec(w.dialect.rebind("update aliases set model_id=? where chat_id=? and model_id=? and endpoint=?"), k.modelID, k.chatID, m, k.endpoint)
+				checkErr(err)
+				_, err = tx.Exec(w.dialect.rebind("update tags set model_id=? where chat_id=? and model_id=? and endpoint=?"), k.modelID, k.chatID, m, k.endpoint)
+				checkErr(err)
+				_, err = tx.Exec(w.dialect.rebind("insert or ignore into models (model_id) values (?)"), k.modelID)
+				checkErr(err)
+				renamed++
+			default:
+				_, err = tx.Exec(w.dialect.rebind("delete from signals where chat_id=? and model_id=? and endpoint=?"), k.chatID, m, k.endpoint)
+				checkErr(err)
+				_, err = tx.Exec(w.dialect.rebind("delete from aliases where chat_id=? and model_id=? and endpoint=?"), k.chatID, m, k.endpoint)
+				checkErr(err)
+				_, err = tx.Exec(w.dialect.rebind("delete from tags where chat_id=? and model_id=? and endpoint=?"), k.chatID, m, k.endpoint)
+				checkErr(err)
+				merged++
+			}
+		}
+	}
+	checkErr(tx.Commit())
+	return
+}
+
+// moveEndpoint reassigns the records of one endpoint to another in a single transaction,
+// the records already existing on the target endpoint win conflicts
+func (w *worker) moveEndpoint(from string, to string) []string {
+	tx, err := w.db.Begin()
+	checkErr(err)
+	var result []string
+	for _, t := range []struct {
+		table string
+		key   []string
+	}{
+		{"signals", []string{"chat_id", "model_id"}},
+		{"aliases", []string{"chat_id", "model_id"}},
+		{"tags", []string{"chat_id", "model_id"}},
+		{"emails", []string{"chat_id"}},
+		{"block", []string{"chat_id"}},
+		{"reminders", []string{"chat_id", "model_id"}},
+	} {
+		moved, dropped := w.moveRows(tx, t.table, "endpoint", t.key, from, to)
+		result = append(result, fmt.Sprintf("%s: %d moved, %d dropped as conflicting", t.table, moved, len(dropped)))
+	}
+	for _, table := range []string{"transactions", "held_notifications", "broadcast_chats"} {
+		updated, err := tx.Exec(w.dialect.rebind("update "+table+" set endpoint=? where endpoint=?"), to, from)
+		checkErr(err)
+		moved, err := updated.RowsAffected()
+		checkErr(err)
+		result = append(result, fmt.Sprintf("%s: %d moved", table, moved))
+	}
+	checkErr(tx.Commit())
+	return result
+}
+
+// gcModels deletes status tracking of the models nobody is subscribed to,
+// special models and models online at the moment are kept
+func (w *worker) gcModels() []string {
+	query := w.mustQuery(`
+		select model_id from models where special=0
+		union select model_id from last_status_changes
+		union select distinct model_id from status_changes
+		except select model_id from signals
+		except select model_id from models where special=1`)
+	var modelIDs []string
+	kept := 0
+	for query.Next() {
+		var modelID string
+		checkErr(query.Scan(&modelID))
+		if w.siteOnline[modelID] || w.ourOnline[modelID] {
+			kept++
+			continue
+		}
+		modelIDs = append(modelIDs, modelID)
+	}
+	checkErr(query.Close())
+	tx, err := w.db.Begin()
+	checkErr(err)
+	deleted := map[string]int64{}
+	tables := []string{"models", "last_status_changes", "status_changes"}
+	for _, table := range tables {
+		stmt := w.mustPrepare(tx, "delete from "+table+" where model_id=?")
+		for _, modelID := range modelIDs {
+			result, err := stmt.Exec(modelID)
+			checkErr(err)
+			affected, err := result.RowsAffected()
+			checkErr(err)
+			deleted[table] += affected
+		}
+		checkErr(stmt.Close())
+	}
+	checkErr(tx.Commit())
+	for _, modelID := range modelIDs {
+		delete(w.siteStatuses, modelID)
+		delete(w.ourIdle, modelID)
+		delete(w.ourPrivate, modelID)
+		delete(w.images, modelID)
+		delete(w.categories, modelID)
+		delete(w.displayNames, modelID)
+		delete(w.originalIDs, modelID)
+		delete(w.offlineConfirmations, modelID)
+	}
+	result := []string{fmt.Sprintf("models collected: %d, kept online: %d", len(modelIDs), kept)}
+	for _, table := range tables {
+		result = append(result, fmt.Sprintf("%s: %d deleted", table, deleted[table]))
+	}
+	return result
+}
+
+func (w *worker) moveEndpointCommand(endpoint string, arguments string) {
+	parts := strings.Fields(arguments)
+	if len(parts) != 2 {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "usage: /move_endpoint from to")
 		return
 	}
-	w.mustExec(`
-		insert into models (model_id, special) values (?,?)
-		on conflict(model_id) do update set special=excluded.special`,
-		modelID,
-		true)
-	w.specialModels[modelID] = true
-	w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "OK")
+	for _, e := range parts {
+		if _, ok := w.cfg.Endpoints[e]; !ok {
+			w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, fmt.Sprintf("unknown endpoint %s", e))
+			return
+		}
+	}
+	if parts[0] == parts[1] {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "endpoints are the same")
+		return
+	}
+	text := strings.Join(w.moveEndpoint(parts[0], parts[1]), "\n")
+	w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, text)
 }
 
 func (w *worker) serveEndpoints() {
@@ -1510,11 +5498,167 @@ func (w *worker) logConfig() {
 	linf("config: " + string(cfgString))
 }
 
+var sensitiveConfigKeys = map[string]bool{
+	"bot_token":      true,
+	"listen_path":    true, // the webhook path usually contains the bot token
+	"stat_password":  true,
+	"public_key":     true,
+	"private_key":    true,
+	"ipn_secret":     true,
+	"access_key":     true,
+	"provider_token": true,
+}
+
+func redact(x interface{}) interface{} {
+	switch x := x.(type) {
+	case map[string]interface{}:
+		for k, v := range x {
+			if sensitiveConfigKeys[k] {
+				x[k] = "REDACTED"
+			} else if k == "proxy_url" {
+				x[k] = redactURL(v)
+			} else {
+				x[k] = redact(v)
+			}
+		}
+	case []interface{}:
+		for i, v := range x {
+			x[i] = redact(v)
+		}
+	}
+	return x
+}
+
+// redactURL strips the credentials from a URL
+func redactURL(x interface{}) interface{} {
+	s, ok := x.(string)
+	if !ok {
+		return x
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return "REDACTED"
+	}
+	if u.User == nil {
+		return s
+	}
+	u.User = url.User("REDACTED")
+	return u.String()
+}
+
+func redactedConfig(cfg *config) []byte {
+	cfgString, err := json.Marshal(cfg)
+	checkErr(err)
+	var parsed interface{}
+	checkErr(json.Unmarshal(cfgString, &parsed))
+	cfgString, err = json.MarshalIndent(redact(parsed), "", "    ")
+	checkErr(err)
+	return cfgString
+}
+
+func (w *worker) sendConfig(endpoint string) {
+	b := tg.FileBytes{Name: "config.json", Bytes: redactedConfig(w.cfg)}
+	msg := tg.NewDocumentUpload(w.cfg.AdminID, b)
+	w.enqueueMessage(w.highPriorityMsg, endpoint, &documentConfig{msg})
+}
+
+// sendResultsRetentionMinutes is the maximum period the delivery stats are available for
+const sendResultsRetentionMinutes = 60
+
+// recordSendResult remembers the send result dropping the outdated ones
+func (w *worker) recordSendResult(r msgSendResult, now int) {
+	w.sendResults = append(w.sendResults, r)
+	i := 0
+	for i < len(w.sendResults) && w.sendResults[i].timestamp < now-sendResultsRetentionMinutes*60 {
+		i++
+	}
+	w.sendResults = w.sendResults[i:]
+}
+
+func (w *worker) deliveryStat(minutes int, now int) []string {
+	count, delaySum, maxDelay, tooManyRequests, timeouts, blocks := 0, 0, 0, 0, 0, 0
+	for _, r := range w.sendResults {
+		if r.timestamp < now-minutes*60 {
+			continue
+		}
+		count++
+		delaySum += r.delay
+		if r.delay > maxDelay {
+			maxDelay = r.delay
+		}
+		switch r.result {
+		case messageTooManyRequests:
+			tooManyRequests++
+		case messageTimeout:
+			timeouts++
+		case messageBlocked:
+			blocks++
+		}
+	}
+	avgDelay := 0
+	if count > 0 {
+		avgDelay = delaySum / count
+	}
+	return []string{
+		fmt.Sprintf("Period: %d min", minutes),
+		fmt.Sprintf("Send attempts: %d", count),
+		fmt.Sprintf("Average delay: %d ms", avgDelay),
+		fmt.Sprintf("Max delay: %d ms", maxDelay),
+		fmt.Sprintf("Too many requests: %d", tooManyRequests),
+		fmt.Sprintf("Timeouts: %d", timeouts),
+		fmt.Sprintf("Blocks: %d", blocks),
+	}
+}
+
+func (w *worker) delivery(endpoint string, arguments string) {
+	minutes := 10
+	if arguments != "" {
+		var err error
+		minutes, err = strconv.Atoi(arguments)
+		if err != nil || minutes <= 0 || minutes > sendResultsRetentionMinutes {
+			text := fmt.Sprintf("usage: /delivery [minutes], up to %d minutes", sendResultsRetentionMinutes)
+			w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, text)
+			return
+		}
+	}
+	text := strings.Join(w.deliveryStat(minutes, int(time.Now().Unix())), "\n")
+	w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, text)
+}
+
 func (w *worker) myEmail(endpoint string) {
 	email := w.email(endpoint, w.cfg.AdminID)
 	w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, true, true, lib.ParseRaw, email)
 }
 
+// testMailText is the sample email the admin receives on the test of mail forwarding
+const testMailText = "From: Siren <test@%[1]s>\r\n" +
+	"To: %[2]s\r\n" +
+	"Subject: Test mail\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"This is a test mail forwarded to you by the bot\r\n"
+
+// testMail runs a sample email addressed to the admin through the mail forwarding without SMTP delivery
+func (w *worker) testMail(endpoint string) {
+	if w.cfg.Mail == nil {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "mail is not configured")
+		return
+	}
+	address := w.email(endpoint, w.cfg.AdminID)
+	e := &env{
+		BasicEnvelope: &smtpd.BasicEnvelope{},
+		from:          mailAddress("test@" + w.cfg.Mail.Host),
+		data:          []byte(fmt.Sprintf(testMailText, w.cfg.Mail.Host, address)),
+		rcpts:         []smtpd.MailAddress{mailAddress(address)},
+	}
+	if err := e.parse(); err != nil {
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, fmt.Sprintf("cannot parse test mail, %v", err))
+		return
+	}
+	w.mailReceived(e)
+	w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, fmt.Sprintf("test mail is sent to %s", address))
+}
+
 func (w *worker) processAdminMessage(endpoint string, chatID int64, command, arguments string) bool {
 	switch command {
 	case "stat":
@@ -1526,9 +5670,64 @@ func (w *worker) processAdminMessage(endpoint string, chatID int64, command, arg
 	case "email":
 		w.myEmail(endpoint)
 		return true
+	case "config":
+		w.sendConfig(endpoint)
+		return true
+	case "sources":
+		w.sources(endpoint)
+		return true
+	case "test_ipn":
+		w.testIPN(endpoint)
+		return true
+	case "test_mail":
+		w.testMail(endpoint)
+		return true
 	case "broadcast":
 		w.broadcast(endpoint, arguments)
 		return true
+	case "broadcast_cancel":
+		w.cancelBroadcasts(endpoint, arguments)
+		return true
+	case "schedule_broadcast":
+		w.scheduleBroadcast(endpoint, arguments, int(time.Now().Unix()))
+		return true
+	case "scheduled":
+		w.scheduledBroadcasts(endpoint)
+		return true
+	case "unschedule":
+		w.unschedule(endpoint, arguments)
+		return true
+	case "transactions":
+		if arguments == "" {
+			return false
+		}
+		parts := strings.Fields(arguments)
+		owner, err := strconv.ParseInt(parts[0], 10, 64)
+		page := 1
+		if len(parts) > 1 {
+			page, err = strconv.Atoi(parts[1])
+		}
+		if err != nil || len(parts) > 2 || page <= 0 {
+			w.sendText(w.highPriorityMsg, endpoint, chatID, false, true, lib.ParseRaw, "usage: /transactions [chat_ID [page]]")
+			return true
+		}
+		w.showTransactions(endpoint, chatID, owner, page)
+		return true
+	case "revoke":
+		w.revoke(endpoint, arguments)
+		return true
+	case "model_alias":
+		w.aliasModelID(endpoint, arguments)
+		return true
+	case "model_aliases":
+		w.sendText(w.highPriorityMsg, endpoint, chatID, false, true, lib.ParseRaw, strings.Join(w.modelIDAliasesInfo(), "\n"))
+		return true
+	case "feedback_list":
+		w.feedbackList(endpoint, arguments)
+		return true
+	case "feedback_clear":
+		w.feedbackClear(endpoint)
+		return true
 	case "direct":
 		w.direct(endpoint, arguments)
 		return true
@@ -1538,6 +5737,60 @@ func (w *worker) processAdminMessage(endpoint string, chatID int64, command, arg
 	case "special":
 		w.addSpecialModel(endpoint, arguments)
 		return true
+	case "special_import":
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, "usage: reply to a file of model IDs with /special_import")
+		return true
+	case "notification_interval":
+		w.setNotificationInterval(endpoint, arguments)
+		return true
+	case "offline_confirmation":
+		w.setOfflineConfirmation(endpoint, arguments)
+		return true
+	case "daily_notifications":
+		w.setDailyNotifications(endpoint, arguments)
+		return true
+	case "delivery":
+		w.delivery(endpoint, arguments)
+		return true
+	case "move_endpoint":
+		w.moveEndpointCommand(endpoint, arguments)
+		return true
+	case "gc_models":
+		text := strings.Join(w.gcModels(), "\n")
+		w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, text)
+		return true
+	case "simulate":
+		w.simulate(endpoint, arguments, int(time.Now().Unix()))
+		return true
+	case "build":
+		w.sendText(w.highPriorityMsg, endpoint, chatID, false, true, lib.ParseRaw, strings.Join(w.buildInfo(), "\n"))
+		return true
+	case "loaded":
+		w.sendText(w.highPriorityMsg, endpoint, chatID, false, true, lib.ParseRaw, strings.Join(w.loadedInfo(), "\n"))
+		return true
+	case "audit_models":
+		w.startAudit(endpoint, time.Now())
+		return true
+	case "force_confirm":
+		w.forceConfirm(endpoint, arguments, int(time.Now().Unix()))
+		return true
+	case "promo_create":
+		w.createPromo(endpoint, arguments, int(time.Now().Unix()))
+		return true
+	case "polling":
+		text := strings.Join(w.pollingInfo(time.Now()), "\n")
+		w.sendText(w.highPriorityMsg, endpoint, chatID, false, true, lib.ParseRaw, text)
+		return true
+	case "confirm_info":
+		modelID := w.modelIDPreprocessing(arguments)
+		text := strings.Join(w.confirmInfo(modelID, int(time.Now().Unix())), "\n")
+		w.sendText(w.highPriorityMsg, endpoint, chatID, false, true, lib.ParseRaw, text)
+		return true
+	case "dedup_subscriptions":
+		merged, renamed := w.dedupSubscriptions()
+		text := fmt.Sprintf("merged: %d, renamed: %d", merged, renamed)
+		w.sendText(w.highPriorityMsg, endpoint, chatID, false, true, lib.ParseRaw, text)
+		return true
 	case "set_max_models":
 		parts := strings.Fields(arguments)
 		if len(parts) != 2 {
@@ -1557,6 +5810,9 @@ func (w *worker) processAdminMessage(endpoint string, chatID int64, command, arg
 		w.setLimit(who, maxModels)
 		w.sendText(w.highPriorityMsg, endpoint, chatID, false, true, lib.ParseRaw, "OK")
 		return true
+	case "temp_limit":
+		w.tempLimit(endpoint, arguments, int(time.Now().Unix()))
+		return true
 	}
 	return false
 }
@@ -1595,27 +5851,98 @@ func (w *worker) mailReceived(e *env) {
 	}
 
 	for email := range emails {
-		w.sendTr(w.lowPriorityMsg, email.endpoint, email.chatID, true, w.tr[email.endpoint].MailReceived, tplData{
-			"subject": e.mime.GetHeader("Subject"),
-			"from":    e.mime.GetHeader("From"),
-			"text":    e.mime.Text})
-		for _, inline := range e.mime.Inlines {
-			b := tg.FileBytes{Name: inline.FileName, Bytes: inline.Content}
-			switch {
-			case strings.HasPrefix(inline.ContentType, "image/"):
-				msg := tg.NewPhotoUpload(email.chatID, b)
-				w.enqueueMessage(w.lowPriorityMsg, email.endpoint, &photoConfig{msg})
-			default:
-				msg := tg.NewDocumentUpload(email.chatID, b)
-				w.enqueueMessage(w.lowPriorityMsg, email.endpoint, &documentConfig{msg})
-			}
+		// the same chat is always processed by the same worker to preserve ordering
+		w.mailJobs[uint64(email.chatID)%uint64(len(w.mailJobs))] <- mailJob{email: email, env: e}
+	}
+}
+
+// deliveryWebhookQueueSize is the maximum number of delivery results waiting to be posted,
+// newer results are dropped when the queue is full
+const deliveryWebhookQueueSize = 1000
+
+// deliveryWebhookTimeoutSeconds is the timeout of a delivery webhook request
+const deliveryWebhookTimeoutSeconds = 10
+
+type deliveryResult struct {
+	Endpoint  string `json:"endpoint"`
+	ChatID    int64  `json:"chat_id"`
+	Result    int    `json:"result"`
+	Priority  int    `json:"priority"`
+	Delay     int    `json:"delay"`
+	Timestamp int    `json:"timestamp"`
+}
+
+// enqueueDeliveryResult passes a message delivery result to the webhook poster without blocking
+func (w *worker) enqueueDeliveryResult(r msgSendResult) {
+	if w.deliveryWebhook == nil {
+		return
+	}
+	select {
+	case w.deliveryWebhook <- r:
+	default:
+		lerr("the delivery webhook queue is full")
+	}
+}
+
+// postDeliveryResults posts queued delivery results to the webhook one by one,
+// failures are logged and the results are dropped
+func (w *worker) postDeliveryResults(client *http.Client) {
+	for r := range w.deliveryWebhook {
+		body, err := json.Marshal(deliveryResult{
+			Endpoint:  r.endpoint,
+			ChatID:    r.chatID,
+			Result:    r.result,
+			Priority:  r.priority,
+			Delay:     r.delay,
+			Timestamp: r.timestamp,
+		})
+		checkErr(err)
+		resp, err := client.Post(w.cfg.DeliveryWebhookURL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			lerr("cannot post a delivery result, %v", err)
+			continue
+		}
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		checkErr(resp.Body.Close())
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			lerr("cannot post a delivery result, status code %d", resp.StatusCode)
 		}
-		for _, inline := range e.mime.Attachments {
-			b := tg.FileBytes{Name: inline.FileName, Bytes: inline.Content}
+	}
+}
+
+func (w *worker) startMailWorkers() {
+	for i := 0; i < w.cfg.Mail.Workers; i++ {
+		jobs := make(chan mailJob, 100)
+		w.mailJobs = append(w.mailJobs, jobs)
+		go func() {
+			for j := range jobs {
+				w.forwardMail(j.email, j.env)
+			}
+		}()
+	}
+}
+
+func (w *worker) forwardMail(email email, e *env) {
+	w.sendTr(w.lowPriorityMsg, email.endpoint, email.chatID, true, w.tr[email.endpoint].MailReceived, tplData{
+		"subject": e.mime.GetHeader("Subject"),
+		"from":    e.mime.GetHeader("From"),
+		"text":    e.mime.Text})
+	for _, inline := range e.mime.Inlines {
+		b := tg.FileBytes{Name: inline.FileName, Bytes: inline.Content}
+		switch {
+		case strings.HasPrefix(inline.ContentType, "image/"):
+			msg := tg.NewPhotoUpload(email.chatID, b)
+			w.enqueueMessage(w.lowPriorityMsg, email.endpoint, &photoConfig{msg})
+		default:
 			msg := tg.NewDocumentUpload(email.chatID, b)
 			w.enqueueMessage(w.lowPriorityMsg, email.endpoint, &documentConfig{msg})
 		}
 	}
+	for _, inline := range e.mime.Attachments {
+		b := tg.FileBytes{Name: inline.FileName, Bytes: inline.Content}
+		msg := tg.NewDocumentUpload(email.chatID, b)
+		w.enqueueMessage(w.lowPriorityMsg, email.endpoint, &documentConfig{msg})
+	}
 }
 
 func envelopeFactory(ch chan *env) func(smtpd.Connection, smtpd.MailAddress, *int) (smtpd.Envelope, error) {
@@ -1645,21 +5972,41 @@ func (w *worker) newRandReferralID() (id string) {
 	return
 }
 
-func (w *worker) refer(followerChatID int64, referrer string) (applied appliedKind) {
+func (w *worker) refer(endpoint string, followerChatID int64, referrer string, now int) (applied appliedKind) {
 	referrerChatID := w.chatForReferralID(referrer)
 	if referrerChatID == nil {
 		return invalidReferral
 	}
 	if _, exists := w.user(followerChatID); exists {
+		w.mustExec("insert into referral_attempts (follower_chat_id, referrer_chat_id, timestamp, applied) values (?,?,?,?)",
+			followerChatID,
+			*referrerChatID,
+			now,
+			false)
+		var firstReferrerChatID int64
+		if w.maybeRecord("select referrer_chat_id from referral_attempts where follower_chat_id=? and applied=1",
+			queryParams{followerChatID},
+			record{&firstReferrerChatID}) {
+			linf("re-referral attempt, follower: %d, referrer: %d, first referrer: %d", followerChatID, *referrerChatID, firstReferrerChatID)
+			return followerAlreadyReferred
+		}
+		linf("referral attempt by an existing user, follower: %d, referrer: %d", followerChatID, *referrerChatID)
 		return followerExists
 	}
-	w.mustExec("insert into users (chat_id, max_models) values (?, ?)", followerChatID, w.cfg.MaxModels+w.cfg.FollowerBonus)
-	w.mustExec(`
-		insert into users (chat_id, max_models) values (?, ?)
-		on conflict(chat_id) do update set max_models=max_models+?`,
+	w.mustExec("insert into referral_attempts (follower_chat_id, referrer_chat_id, timestamp, applied) values (?,?,?,?)",
+		followerChatID,
 		*referrerChatID,
-		w.cfg.MaxModels+w.cfg.ReferralBonus,
-		w.cfg.ReferralBonus)
+		now,
+		true)
+	w.mustExec("insert into users (chat_id, max_models) values (?, ?)", followerChatID, w.cfg.MaxModels+w.cfg.FollowerBonus)
+	w.changeLimit(*referrerChatID, true, func() {
+		w.mustExec(`
+			insert into users (chat_id, max_models) values (?, ?)
+			on conflict(chat_id) do update set max_models=max_models+?`,
+			*referrerChatID,
+			w.cfg.MaxModels+w.cfg.ReferralBonus,
+			w.cfg.ReferralBonus)
+	})
 	w.mustExec("update referrals set referred_users=referred_users+1 where chat_id=?", referrerChatID)
 	return referralApplied
 }
@@ -1683,6 +6030,38 @@ func (w *worker) showReferral(endpoint string, chatID int64) {
 	})
 }
 
+// share sends a deep link subscribing the users following it to the model
+func (w *worker) share(endpoint string, chatID int64, modelID string) {
+	if modelID == "" {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].SyntaxShare, nil)
+		return
+	}
+	modelID = w.modelIDPreprocessing(modelID)
+	if !lib.ModelIDRegexp.MatchString(modelID) || !startParameterRegexp.MatchString("m-"+modelID) {
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].InvalidSymbols, tplData{"model": modelID})
+		return
+	}
+	link := fmt.Sprintf("https://t.me/%s?start=m-%s", w.botNames[endpoint], modelID)
+	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].ShareLink, tplData{"model": modelID, "link": link})
+}
+
+// resetReferralID replaces the referral ID of the chat keeping the number of referred users
+func (w *worker) resetReferralID(chatID int64) string {
+	referralID := w.newRandReferralID()
+	w.mustExec(`
+		insert into referrals (chat_id, referral_id) values (?, ?)
+		on conflict(chat_id) do update set referral_id=excluded.referral_id`,
+		chatID,
+		referralID)
+	return referralID
+}
+
+func (w *worker) resetReferral(endpoint string, chatID int64) {
+	_ = w.resetReferralID(chatID)
+	w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].ReferralReset, nil)
+	w.showReferral(endpoint, chatID)
+}
+
 func (w *worker) start(endpoint string, chatID int64, referrer string, now int) {
 	modelID := ""
 	switch {
@@ -1700,7 +6079,7 @@ func (w *worker) start(endpoint string, chatID int64, referrer string, now int)
 		"website_link": w.cfg.WebsiteLink,
 	})
 	if chatID > 0 && referrer != "" {
-		applied := w.refer(chatID, referrer)
+		applied := w.refer(endpoint, chatID, referrer, now)
 		switch applied {
 		case referralApplied:
 			w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].ReferralApplied, nil)
@@ -1708,6 +6087,8 @@ func (w *worker) start(endpoint string, chatID int64, referrer string, now int)
 			w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].InvalidReferralLink, nil)
 		case followerExists:
 			w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].FollowerExists, nil)
+		case followerAlreadyReferred:
+			w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].FollowerAlreadyReferred, nil)
 		}
 	}
 	w.addUser(endpoint, chatID)
@@ -1719,6 +6100,50 @@ func (w *worker) start(endpoint string, chatID int64, referrer string, now int)
 	}
 }
 
+var helpTopics = []string{"subscriptions", "settings", "payments", "referrals"}
+
+func (w *worker) helpTopic(endpoint string, topic string) *lib.Translation {
+	switch topic {
+	case "subscriptions":
+		return w.tr[endpoint].HelpSubscriptions
+	case "settings":
+		return w.tr[endpoint].HelpSettings
+	case "payments":
+		return w.tr[endpoint].HelpPayments
+	case "referrals":
+		return w.tr[endpoint].HelpReferrals
+	}
+	return nil
+}
+
+func (w *worker) help(endpoint string, chatID int64, topic string) {
+	if tr := w.helpTopic(endpoint, strings.ToLower(topic)); tr != nil {
+		data := tplData{
+			"max_models":        w.cfg.MaxModels,
+			"referral_bonus":    w.cfg.ReferralBonus,
+			"follower_bonus":    w.cfg.FollowerBonus,
+			"payments_enabled":  w.paymentsEnabled(endpoint),
+			"offline_supported": w.cfg.OfflineNotifications,
+		}
+		if cp := w.coinPayments(endpoint); cp != nil {
+			data["dollars"] = cp.subscriptionPacketPrice
+			data["number_of_subscriptions"] = cp.subscriptionPacketModelNumber
+		}
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, tr, data)
+		return
+	}
+	tpl := w.chatTemplates(endpoint, chatID)
+	var buttons [][]tg.InlineKeyboardButton
+	for _, t := range helpTopics {
+		buttonText := templateToString(tpl, w.tr[endpoint].HelpButton.Key, tplData{"topic": t})
+		buttons = append(buttons, []tg.InlineKeyboardButton{tg.NewInlineKeyboardButtonData(buttonText, "help "+t)})
+	}
+	text := templateToString(tpl, w.tr[endpoint].HelpIndex.Key, nil)
+	msg := tg.NewMessage(chatID, text)
+	msg.ReplyMarkup = tg.NewInlineKeyboardMarkup(buttons...)
+	w.enqueueMessage(w.highPriorityMsg, endpoint, &messageConfig{msg})
+}
+
 func (w *worker) processIncomingCommand(endpoint string, chatID int64, command, arguments string, now int) {
 	w.resetBlock(endpoint, chatID)
 	command = strings.ToLower(command)
@@ -1726,8 +6151,11 @@ func (w *worker) processIncomingCommand(endpoint string, chatID int64, command,
 		w.addUser(endpoint, chatID)
 	}
 	linf("chat: %d, command: %s %s", chatID, command, arguments)
+	if command != "feedback_category" {
+		delete(w.pendingFeedback, chat{endpoint: endpoint, chatID: chatID})
+	}
 
-	if chatID == w.cfg.AdminID && w.processAdminMessage(endpoint, chatID, command, arguments) {
+	if chatID == w.cfg.AdminID && w.processAdminMessage(baseEndpoint(endpoint), chatID, command, arguments) {
 		return
 	}
 
@@ -1736,24 +6164,45 @@ func (w *worker) processIncomingCommand(endpoint string, chatID int64, command,
 	switch command {
 	case "add":
 		arguments = strings.Replace(arguments, "—", "--", -1)
-		_ = w.addModel(endpoint, chatID, arguments, now)
+		if models := parseCheckList(arguments); len(models) > 1 {
+			w.addModels(endpoint, chatID, models, now)
+		} else {
+			_ = w.addModel(endpoint, chatID, arguments, now)
+		}
 	case "remove":
 		arguments = strings.Replace(arguments, "—", "--", -1)
-		w.removeModel(endpoint, chatID, arguments)
+		w.removeModel(endpoint, chatID, arguments, now)
+	case "undo_remove":
+		if w.cfg.UndoRemoveMinutes == 0 {
+			unknown()
+			return
+		}
+		w.undoRemove(endpoint, chatID, now)
 	case "list":
-		w.listModels(endpoint, chatID, now)
+		w.listModels(endpoint, chatID, arguments, now)
+	case "list_by_category":
+		w.listModelsByCategory(endpoint, chatID, now)
 	case "pics", "online":
 		w.listOnlineModels(endpoint, chatID, now)
-	case "start", "help":
+	case "start":
 		w.start(endpoint, chatID, arguments, now)
+	case "help":
+		w.help(endpoint, chatID, arguments)
 	case "faq":
-		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].FAQ, tplData{
-			"dollars":                 w.cfg.CoinPayments.subscriptionPacketPrice,
-			"number_of_subscriptions": w.cfg.CoinPayments.subscriptionPacketModelNumber,
-			"max_models":              w.cfg.MaxModels,
-		})
+		data := tplData{"max_models": w.cfg.MaxModels}
+		if cp := w.coinPayments(endpoint); cp != nil {
+			data["dollars"] = cp.subscriptionPacketPrice
+			data["number_of_subscriptions"] = cp.subscriptionPacketModelNumber
+		}
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].FAQ, data)
 	case "feedback":
-		w.feedback(endpoint, chatID, arguments)
+		if arguments == "" {
+			w.askFeedbackCategory(endpoint, chatID)
+			return
+		}
+		w.feedback(endpoint, chatID, "other", arguments, now)
+	case "feedback_category":
+		w.selectFeedbackCategory(endpoint, chatID, arguments, now)
 	case "social":
 		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].Social, nil)
 	case "version":
@@ -1765,7 +6214,73 @@ func (w *worker) processIncomingCommand(endpoint string, chatID int64, command,
 	case "want_more":
 		w.wantMore(endpoint, chatID)
 	case "settings":
-		w.settings(endpoint, chatID)
+		w.settings(endpoint, chatID, now)
+	case "status_me":
+		w.statusMe(endpoint, chatID, now)
+	case "vacation":
+		w.vacation(endpoint, chatID, arguments, now)
+	case "mute":
+		w.mute(endpoint, chatID, arguments, now)
+	case "unmute":
+		w.unmute(endpoint, chatID)
+	case "remind":
+		if w.cfg.MaxReminders == 0 {
+			unknown()
+			return
+		}
+		w.remind(endpoint, chatID, arguments, now)
+	case "unremind":
+		if w.cfg.MaxReminders == 0 {
+			unknown()
+			return
+		}
+		w.unremind(endpoint, chatID, arguments)
+	case "export":
+		w.exportModels(endpoint, chatID)
+	case "import":
+		w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].SyntaxImport, tplData{"max_size_kb": maxImportSize / 1024})
+	case "check_list":
+		if w.cfg.MaxCheckList == 0 {
+			unknown()
+			return
+		}
+		w.checkList(endpoint, chatID, arguments)
+	case "search":
+		if w.cfg.MaxSearchResults == 0 {
+			unknown()
+			return
+		}
+		w.search(endpoint, chatID, arguments, now)
+	case "watch":
+		if w.cfg.WatchMinutes == 0 {
+			unknown()
+			return
+		}
+		w.watch(endpoint, chatID, arguments)
+	case "notify":
+		w.setModelNotifications(endpoint, chatID, arguments)
+	case "rename":
+		w.rename(endpoint, chatID, arguments)
+	case "tag":
+		w.tag(endpoint, chatID, arguments)
+	case "share":
+		w.share(endpoint, chatID, arguments)
+	case "quiet_hours":
+		w.setQuietHours(endpoint, chatID, arguments)
+	case "timezone":
+		w.setTimezone(endpoint, chatID, arguments)
+	case "language":
+		w.setLanguage(endpoint, chatID, arguments)
+	case "image_limit":
+		w.setImageLimit(endpoint, chatID, arguments)
+	case "image_mode":
+		w.setImageMode(endpoint, chatID, arguments)
+	case "min_online":
+		w.setMinOnline(endpoint, chatID, arguments)
+	case "pause":
+		w.pause(endpoint, chatID)
+	case "resume":
+		w.resume(endpoint, chatID, now)
 	case "enable_images":
 		w.enableImages(endpoint, chatID, true)
 	case "disable_images":
@@ -1775,46 +6290,162 @@ func (w *worker) processIncomingCommand(endpoint string, chatID int64, command,
 	case "disable_offline_notifications":
 		w.enableOfflineNotifications(endpoint, chatID, false)
 	case "buy":
-		if w.cfg.CoinPayments == nil || w.cfg.Mail == nil {
+		if !w.paymentsEnabled(endpoint) {
 			unknown()
 			return
 		}
 		w.buy(endpoint, chatID)
 	case "buy_with":
-		if w.cfg.CoinPayments == nil || w.cfg.Mail == nil {
+		if !w.paymentsEnabled(endpoint) {
 			unknown()
 			return
 		}
 		w.buyWith(endpoint, chatID, arguments)
+	case "buy_by_card":
+		if !w.paymentsEnabled(endpoint) || w.cfg.TelegramPayments == nil {
+			unknown()
+			return
+		}
+		w.buyByCard(endpoint, chatID)
+	case "promo":
+		w.redeemPromo(endpoint, chatID, arguments, now)
+	case "transactions":
+		page := 1
+		if arguments != "" {
+			var err error
+			if page, err = strconv.Atoi(arguments); err != nil || page <= 0 {
+				w.sendTr(w.highPriorityMsg, endpoint, chatID, false, w.tr[endpoint].SyntaxTransactions, nil)
+				return
+			}
+		}
+		w.showTransactions(endpoint, chatID, chatID, page)
 	case "referral":
 		w.showReferral(endpoint, chatID)
+	case "referral_reset":
+		w.resetReferral(endpoint, chatID)
 	case "week":
 		if !w.cfg.EnableWeek {
 			unknown()
 			return
 		}
 		w.showWeek(endpoint, chatID, arguments)
+	case "was_online":
+		if !w.cfg.EnableWeek {
+			unknown()
+			return
+		}
+		w.wasOnline(endpoint, chatID, arguments, time.Now())
 	default:
 		unknown()
 	}
 }
 
 func (w *worker) processPeriodic(statusRequests chan lib.StatusRequest) {
-	unsuccessfulRequestsCount := w.unsuccessfulRequestsCount()
 	now := time.Now()
-	if w.nextErrorReport.Before(now) && unsuccessfulRequestsCount > w.cfg.errorThreshold {
-		text := fmt.Sprintf("Dangerous error rate reached: %d/%d", unsuccessfulRequestsCount, w.cfg.errorDenominator)
-		w.sendText(w.highPriorityMsg, w.cfg.AdminEndpoint, w.cfg.AdminID, true, true, lib.ParseRaw, text)
-		w.nextErrorReport = now.Add(time.Minute * time.Duration(w.cfg.ErrorReportingPeriodMinutes))
+	w.checkErrorRate(w.unsuccessfulRequestsCount(), now)
+
+	if w.cfg.MaxStalenessSeconds > 0 {
+		w.checkStaleness(now)
+	}
+
+	w.fireScheduledBroadcasts(int(now.Unix()))
+	w.feedBroadcasts(int(now.Unix()))
+	w.resumeVacations(int(now.Unix()))
+	w.revertTempLimits(int(now.Unix()))
+	w.expirePackets(int(now.Unix()))
+	w.dropExpiredFeedbackRequests(int(now.Unix()))
+	if w.cfg.MaxReminders > 0 {
+		w.sendReminders(int(now.Unix()))
+	}
+	if w.cfg.BlockedProbesPerPeriod > 0 {
+		w.probeBlocked(int(now.Unix()))
+	}
+	if held := w.releaseHeld(int(now.Unix())); len(held) > 0 {
+		w.notifyOfStatuses(w.lowPriorityMsg, held)
 	}
 
 	select {
-	case statusRequests <- lib.StatusRequest{SpecialModels: w.specialModels}:
+	case statusRequests <- w.statusRequest():
 	default:
 		linf("the queue is full")
 	}
 }
 
+// checkErrorRate alerts when the error rate crosses the dangerous threshold,
+// it repeats the alert every error reporting period and reports the recovery once
+func (w *worker) checkErrorRate(unsuccessfulRequestsCount int, now time.Time) {
+	chatID := w.cfg.AlertsChatID
+	if chatID == 0 {
+		chatID = w.cfg.AdminID
+	}
+	var text string
+	switch {
+	case unsuccessfulRequestsCount > w.cfg.errorThreshold && !w.errorRateAlerted:
+		text = fmt.Sprintf("Dangerous error rate reached: %d/%d", unsuccessfulRequestsCount, w.cfg.errorDenominator)
+	case unsuccessfulRequestsCount > w.cfg.errorThreshold && w.nextErrorReport.Before(now):
+		text = fmt.Sprintf("Dangerous error rate persists: %d/%d", unsuccessfulRequestsCount, w.cfg.errorDenominator)
+	case unsuccessfulRequestsCount <= w.cfg.errorThreshold && w.errorRateAlerted:
+		text = fmt.Sprintf("Error rate recovered: %d/%d", unsuccessfulRequestsCount, w.cfg.errorDenominator)
+		w.errorRateAlerted = false
+		w.sendText(w.highPriorityMsg, w.cfg.AdminEndpoint, chatID, true, true, lib.ParseRaw, text)
+		return
+	default:
+		return
+	}
+	w.errorRateAlerted = true
+	w.nextErrorReport = now.Add(time.Minute * time.Duration(w.cfg.ErrorReportingPeriodMinutes))
+	w.sendText(w.highPriorityMsg, w.cfg.AdminEndpoint, chatID, true, true, lib.ParseRaw, text)
+}
+
+// reportUnknownStatus alerts admin of a raw site status that does not map to a status kind,
+// an alert for the same status is repeated not more often than once per error reporting period
+func (w *worker) reportUnknownStatus(raw string, now time.Time) {
+	lerr("unknown status reported: %q", raw)
+	if next, ok := w.unknownStatusReports[raw]; ok && now.Before(next) {
+		return
+	}
+	w.unknownStatusReports[raw] = now.Add(time.Minute * time.Duration(w.cfg.ErrorReportingPeriodMinutes))
+	text := fmt.Sprintf("Unknown %s status: %q", w.cfg.Website, raw)
+	w.sendText(w.highPriorityMsg, w.cfg.AdminEndpoint, w.cfg.AdminID, true, true, lib.ParseRaw, text)
+}
+
+// checkStaleness warns admin if online models have not been updated for too long,
+// it optionally treats all models as offline until the next successful update
+func (w *worker) checkStaleness(now time.Time) {
+	staleness := now.Sub(w.lastOnlineUpdate)
+	if staleness < time.Duration(w.cfg.MaxStalenessSeconds)*time.Second {
+		return
+	}
+	if !w.stalenessReported {
+		text := fmt.Sprintf("Online models have not been updated for %v", staleness.Truncate(time.Second))
+		w.sendText(w.highPriorityMsg, w.cfg.AdminEndpoint, w.cfg.AdminID, true, true, lib.ParseRaw, text)
+		w.stalenessReported = true
+	}
+	if w.cfg.OfflineOnStaleness {
+		_, _, notifications, _ := w.processStatusUpdates(nil, int(now.Unix()))
+		w.notifyOfStatuses(w.lowPriorityMsg, notifications)
+	}
+}
+
+func (w *worker) onlineModelsUpdated(now time.Time) {
+	w.lastOnlineUpdate = now
+	w.lastSuccessfulPoll = now
+	if w.stalenessReported {
+		w.stalenessReported = false
+		w.sendText(w.highPriorityMsg, w.cfg.AdminEndpoint, w.cfg.AdminID, true, true, lib.ParseRaw, "Online models are updated again")
+	}
+}
+
+func (w *worker) pruneInteractions(now time.Time) {
+	timestamp := now.Add(-time.Duration(w.cfg.InteractionsRetentionDays) * 24 * time.Hour).Unix()
+	defer w.measure("db: prune interactions")()
+	result, err := w.db.Exec(w.dialect.rebind("delete from interactions where timestamp<?"), timestamp)
+	checkErr(err)
+	pruned, err := result.RowsAffected()
+	checkErr(err)
+	linf("interactions pruned: %d", pruned)
+}
+
 func (w *worker) queryLastStatusChanges() map[string]statusChange {
 	query := w.mustQuery(`select model_id, status, timestamp from last_status_changes`)
 	defer func() { checkErr(query.Close()) }()
@@ -1827,48 +6458,223 @@ func (w *worker) queryLastStatusChanges() map[string]statusChange {
 	return statusChanges
 }
 
-func (w *worker) queryConfirmedModels() (map[string]bool, map[string]bool) {
+func (w *worker) queryConfirmedModels() (online map[string]bool, idle map[string]bool, private map[string]bool, special map[string]bool) {
 	query := w.mustQuery("select model_id, status, special from models")
 	defer func() { checkErr(query.Close()) }()
-	statuses := map[string]bool{}
-	specialModels := map[string]bool{}
+	online = map[string]bool{}
+	idle = map[string]bool{}
+	private = map[string]bool{}
+	special = map[string]bool{}
 	for query.Next() {
 		var modelID string
 		var status lib.StatusKind
-		var special bool
-		checkErr(query.Scan(&modelID, &status, &special))
-		if status == lib.StatusOnline {
-			statuses[modelID] = true
+		var isSpecial bool
+		checkErr(query.Scan(&modelID, &status, &isSpecial))
+		if present(status) {
+			online[modelID] = true
 		}
-		if special {
-			specialModels[modelID] = true
+		if status == lib.StatusIdle {
+			idle[modelID] = true
 		}
+		if status == lib.StatusPrivate {
+			private[modelID] = true
+		}
+		if isSpecial {
+			special[modelID] = true
+		}
+	}
+	return
+}
+
+func (w *worker) queryCategories() map[string]string {
+	query := w.mustQuery("select model_id, category from models where category != ''")
+	defer func() { checkErr(query.Close()) }()
+	categories := map[string]string{}
+	for query.Next() {
+		var modelID string
+		var category string
+		checkErr(query.Scan(&modelID, &category))
+		categories[modelID] = category
+	}
+	return categories
+}
+
+func (w *worker) queryOfflineConfirmations() map[string]int {
+	query := w.mustQuery("select model_id, offline_confirmation_seconds from models where offline_confirmation_seconds != 0")
+	defer func() { checkErr(query.Close()) }()
+	confirmations := map[string]int{}
+	for query.Next() {
+		var modelID string
+		var seconds int
+		checkErr(query.Scan(&modelID, &seconds))
+		confirmations[modelID] = seconds
+	}
+	return confirmations
+}
+
+func (w *worker) queryOriginalIDs() map[string]string {
+	query := w.mustQuery("select model_id, original_id from models where original_id != ''")
+	defer func() { checkErr(query.Close()) }()
+	originalIDs := map[string]string{}
+	for query.Next() {
+		var modelID string
+		var originalID string
+		checkErr(query.Scan(&modelID, &originalID))
+		originalIDs[modelID] = originalID
+	}
+	return originalIDs
+}
+
+func (w *worker) queryModelIDAliases() map[string]string {
+	query := w.mustQuery("select model_id, canonical_id from model_id_aliases")
+	defer func() { checkErr(query.Close()) }()
+	aliases := map[string]string{}
+	for query.Next() {
+		var modelID string
+		var canonicalID string
+		checkErr(query.Scan(&modelID, &canonicalID))
+		aliases[modelID] = canonicalID
 	}
-	return statuses, specialModels
+	return aliases
 }
 
+func (w *worker) queryDisplayNames() map[string]string {
+	query := w.mustQuery("select model_id, display_name from models where display_name != ''")
+	defer func() { checkErr(query.Close()) }()
+	displayNames := map[string]string{}
+	for query.Next() {
+		var modelID string
+		var displayName string
+		checkErr(query.Scan(&modelID, &displayName))
+		displayNames[modelID] = displayName
+	}
+	return displayNames
+}
+
+// hashDiff returns the keys added to and removed from the map,
+// all contains both added and removed keys
 func hashDiff(before, after map[string]bool) (all, added, removed []string) {
 	for k := range after {
 		if _, ok := before[k]; !ok {
-			all = append(all, k)
+			added = append(added, k)
 		}
 	}
 	for k := range before {
 		if _, ok := after[k]; !ok {
-			all = append(all, k)
+			removed = append(removed, k)
 		}
 	}
+	all = append(append(all, added...), removed...)
 	return
 }
 
 func (w *worker) updateImages(onlineModels []lib.OnlineModel) {
 	for _, u := range onlineModels {
+		if old, ok := w.images[u.ModelID]; ok && old != u.Image {
+			delete(w.imageCache, old)
+		}
 		if u.Image != "" {
 			w.images[u.ModelID] = u.Image
 		} else {
 			delete(w.images, u.ModelID)
 		}
 	}
+	w.pruneImageCache(time.Now())
+}
+
+// updateCategories persists changed categories of the models we have subscriptions for
+func (w *worker) updateCategories(tx *sql.Tx, onlineModels []lib.OnlineModel, usersForModels map[string][]subscriber) {
+	var stmt *sql.Stmt
+	for _, u := range onlineModels {
+		if u.Category == "" || w.categories[u.ModelID] == u.Category || usersForModels[u.ModelID] == nil {
+			continue
+		}
+		if stmt == nil {
+			stmt = w.mustPrepare(tx, updateModelCategory)
+		}
+		w.mustExecPrepared(updateModelCategory, stmt, u.Category, u.ModelID)
+		w.categories[u.ModelID] = u.Category
+	}
+	if stmt != nil {
+		checkErr(stmt.Close())
+	}
+}
+
+// updateOriginalIDs persists the site spelling of the IDs of the models we have subscriptions for
+func (w *worker) updateOriginalIDs(tx *sql.Tx, onlineModels []lib.OnlineModel, usersForModels map[string][]subscriber) {
+	var stmt *sql.Stmt
+	for _, u := range onlineModels {
+		if u.OriginalID == "" || w.originalIDs[u.ModelID] == u.OriginalID || usersForModels[u.ModelID] == nil {
+			continue
+		}
+		if stmt == nil {
+			stmt = w.mustPrepare(tx, updateModelOriginalID)
+		}
+		w.mustExecPrepared(updateModelOriginalID, stmt, u.OriginalID, u.ModelID)
+		w.originalIDs[u.ModelID] = u.OriginalID
+	}
+	if stmt != nil {
+		checkErr(stmt.Close())
+	}
+}
+
+// modelName returns the model ID as it is displayed to users
+func (w *worker) modelName(modelID string) string {
+	switch w.cfg.ModelNameDisplay {
+	case "original":
+		if originalID, ok := w.originalIDs[modelID]; ok && strings.EqualFold(originalID, modelID) {
+			return originalID
+		}
+	case "capitalized":
+		if r, size := utf8.DecodeRuneInString(modelID); size > 0 {
+			return string(unicode.ToUpper(r)) + modelID[size:]
+		}
+	}
+	return modelID
+}
+
+// updateDisplayNames persists changed display names of the models we have subscriptions for
+// and returns notifications of these changes
+func (w *worker) updateDisplayNames(
+	tx *sql.Tx,
+	onlineModels []lib.OnlineModel,
+	usersForModels map[string][]subscriber,
+	endpointsForModels map[string][]string,
+) (
+	notifications []notification,
+) {
+	var stmt *sql.Stmt
+	for _, u := range onlineModels {
+		prev := w.displayNames[u.ModelID]
+		if u.DisplayName == "" || prev == u.DisplayName || usersForModels[u.ModelID] == nil {
+			continue
+		}
+		if stmt == nil {
+			stmt = w.mustPrepare(tx, updateModelDisplayName)
+		}
+		w.mustExecPrepared(updateModelDisplayName, stmt, u.DisplayName, u.ModelID)
+		w.displayNames[u.ModelID] = u.DisplayName
+		if prev == "" {
+			continue
+		}
+		endpoints := endpointsForModels[u.ModelID]
+		for i, user := range usersForModels[u.ModelID] {
+			if user.snooze {
+				continue
+			}
+			notifications = append(notifications, notification{
+				kind:        displayNameNotification,
+				endpoint:    endpoints[i],
+				chatID:      user.chatID,
+				modelID:     u.ModelID,
+				displayName: [2]string{prev, u.DisplayName},
+			})
+		}
+	}
+	if stmt != nil {
+		checkErr(stmt.Close())
+	}
+	return
 }
 
 func (w *worker) processStatusUpdates(
@@ -1881,32 +6687,67 @@ func (w *worker) processStatusUpdates(
 	elapsed time.Duration,
 ) {
 	start := time.Now()
+	onlineModels = w.keepUnchecked(onlineModels)
+	onlineModels = w.applyModelIDAliases(onlineModels)
 	w.updateImages(onlineModels)
 	usersForModels, endpointsForModels := w.usersForModels()
+	unsettled := w.unsettledSubscriptions(now)
 	tx, err := w.db.Begin()
 	checkErr(err)
 
-	insertStatusChangeStmt, err := tx.Prepare(insertStatusChange)
-	checkErr(err)
-	updateLastStatusChangeStmt, err := tx.Prepare(updateLastStatusChange)
-	checkErr(err)
-	updateModelStatusStmt, err := tx.Prepare(updateModelStatus)
-	checkErr(err)
+	insertStatusChangeStmt := w.mustPrepare(tx, insertStatusChange)
+	updateLastStatusChangeStmt := w.mustPrepare(tx, updateLastStatusChange)
+	updateModelStatusStmt := w.mustPrepare(tx, updateModelStatus)
+
+	categoriesDone := w.measure("db: categories")
+	w.updateCategories(tx, onlineModels, usersForModels)
+	categoriesDone()
+
+	if w.cfg.ModelNameDisplay == "original" {
+		originalIDsDone := w.measure("db: original IDs")
+		w.updateOriginalIDs(tx, onlineModels, usersForModels)
+		originalIDsDone()
+	}
+
+	if w.cfg.DisplayNameNotifications {
+		displayNamesDone := w.measure("db: display names")
+		notifications = w.updateDisplayNames(tx, onlineModels, usersForModels, endpointsForModels)
+		displayNamesDone()
+	}
 
 	next := map[string]bool{}
+	nextIdle := map[string]bool{}
+	nextPrivate := map[string]bool{}
 	hashDone := w.measure("algo: hash diff")
 	for _, u := range onlineModels {
 		next[u.ModelID] = true
+		if u.Idle && w.cfg.IdleStatus {
+			nextIdle[u.ModelID] = true
+		} else if u.Private && w.cfg.PrivateStatus {
+			nextPrivate[u.ModelID] = true
+		}
 	}
-	all, _, _ := hashDiff(w.siteOnline, next)
+	all, cameOnline, wentOffline := hashDiff(w.siteOnline, next)
+	idle, _, _ := hashDiff(w.siteIdle, nextIdle)
+	private, _, _ := hashDiff(w.sitePrivate, nextPrivate)
+	all = union(union(all, idle), private)
 	hashDone()
 
 	changesCount = len(all)
+	w.onlineChangesInPeriod = len(cameOnline)
+	w.offlineChangesInPeriod = len(wentOffline)
+	if w.cfg.Debug {
+		ldbg("models came online: %d, went offline: %d", len(cameOnline), len(wentOffline))
+	}
 
 	statusDone := w.measure("db: status updates")
 	for _, u := range all {
 		status := lib.StatusOffline
-		if _, ok := next[u]; ok {
+		if nextIdle[u] {
+			status = lib.StatusIdle
+		} else if nextPrivate[u] {
+			status = lib.StatusPrivate
+		} else if next[u] {
 			status = lib.StatusOnline
 		}
 		statusChange := statusChange{modelID: u, status: status, timestamp: now}
@@ -1922,11 +6763,83 @@ func (w *worker) processStatusUpdates(
 		ldbg("confirmed online models: %d", len(w.ourOnline))
 	}
 
+	confirmedChangesCount = len(confirmations)
+
+	commitDone := w.measure("db: status updates commit")
+	checkErr(insertStatusChangeStmt.Close())
+	checkErr(updateLastStatusChangeStmt.Close())
+	checkErr(updateModelStatusStmt.Close())
+	checkErr(tx.Commit())
+	commitDone()
+
+	// notifications are built from the in-memory state only, so the transaction does not wait for them
+	notificationsDone := w.measure("algo: notifications")
+	notifications = append(notifications, w.parallelConfirmationNotifications(confirmations, usersForModels, endpointsForModels, unsettled)...)
+	notificationsDone()
+
+	elapsed = time.Since(start)
+	return
+}
+
+// parallelConfirmationNotifications splits the confirmations between notification workers,
+// the notifications are returned in the same order as the serial building returns them
+func (w *worker) parallelConfirmationNotifications(
+	confirmations []string,
+	usersForModels map[string][]subscriber,
+	endpointsForModels map[string][]string,
+	unsettled map[subscription]bool,
+) []notification {
+	workers := w.cfg.NotificationWorkers
+	if workers > len(confirmations) {
+		workers = len(confirmations)
+	}
+	if workers <= 1 {
+		return w.confirmationNotifications(confirmations, usersForModels, endpointsForModels, unsettled)
+	}
+	chunkSize := (len(confirmations) + workers - 1) / workers
+	results := make([][]notification, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		begin := i * chunkSize
+		end := begin + chunkSize
+		if end > len(confirmations) {
+			end = len(confirmations)
+		}
+		if begin >= end {
+			break
+		}
+		wg.Add(1)
+		go func(i int, chunk []string) {
+			defer wg.Done()
+			results[i] = w.confirmationNotifications(chunk, usersForModels, endpointsForModels, unsettled)
+		}(i, confirmations[begin:end])
+	}
+	wg.Wait()
+	var notifications []notification
+	for _, r := range results {
+		notifications = append(notifications, r...)
+	}
+	return notifications
+}
+
+// confirmationNotifications returns the notifications of the subscribers of the models with confirmed statuses
+func (w *worker) confirmationNotifications(
+	confirmations []string,
+	usersForModels map[string][]subscriber,
+	endpointsForModels map[string][]string,
+	unsettled map[subscription]bool,
+) (notifications []notification) {
 	for _, c := range confirmations {
 		users := usersForModels[c]
 		endpoints := endpointsForModels[c]
 		for i, user := range users {
+			if user.snooze || unsettled[subscription{endpoint: endpoints[i], chatID: user.chatID, modelID: c}] {
+				continue
+			}
 			status := w.siteStatuses[c].status
+			if status == lib.StatusOffline && !user.notifyOffline || status != lib.StatusOffline && !user.notifyOnline {
+				continue
+			}
 			if (w.cfg.OfflineNotifications && user.offlineNotifications) || status != lib.StatusOffline {
 				notifications = append(notifications, notification{
 					endpoint: endpoints[i],
@@ -1937,23 +6850,64 @@ func (w *worker) processStatusUpdates(
 			}
 		}
 	}
+	return
+}
 
-	confirmedChangesCount = len(confirmations)
+const recentUpdatesSize = 1000
 
-	defer w.measure("db: status updates commit")()
-	checkErr(insertStatusChangeStmt.Close())
-	checkErr(updateLastStatusChangeStmt.Close())
-	checkErr(updateModelStatusStmt.Close())
-	checkErr(tx.Commit())
-	elapsed = time.Since(start)
-	return
+func newRecentUpdates(size int) *recentUpdates {
+	return &recentUpdates{ids: make([]int, 0, size), seen: map[int]bool{}}
+}
+
+// add remembers the update ID evicting the oldest one,
+// it returns false if the update ID has been seen already
+func (r *recentUpdates) add(id int) bool {
+	if r.seen[id] {
+		return false
+	}
+	if len(r.ids) < cap(r.ids) {
+		r.ids = append(r.ids, id)
+	} else {
+		delete(r.seen, r.ids[r.pos])
+		r.ids[r.pos] = id
+		r.pos = (r.pos + 1) % len(r.ids)
+	}
+	r.seen[id] = true
+	return true
+}
+
+// isDuplicate reports whether the update has been already received on the endpoint
+func (w *worker) isDuplicate(p incomingPacket) bool {
+	recent, ok := w.recentUpdates[p.endpoint]
+	if !ok {
+		recent = newRecentUpdates(recentUpdatesSize)
+		w.recentUpdates[p.endpoint] = recent
+	}
+	return !recent.add(p.message.UpdateID)
 }
 
 func (w *worker) processTGUpdate(p incomingPacket) {
 	now := int(time.Now().Unix())
 	u := p.message
+	if w.isDuplicate(p) {
+		linf("skipping duplicate update %d for endpoint %s", u.UpdateID, p.endpoint)
+		return
+	}
+	if u.Message != nil && u.Message.Chat != nil {
+		p.endpoint = w.routeEndpoint(p.endpoint, u.Message.Chat.ID)
+	} else if u.CallbackQuery != nil {
+		p.endpoint = w.routeEndpoint(p.endpoint, int64(u.CallbackQuery.From.ID))
+	} else if u.PreCheckoutQuery != nil {
+		p.endpoint = w.routeEndpoint(p.endpoint, int64(u.PreCheckoutQuery.From.ID))
+	}
+	if u.PreCheckoutQuery != nil {
+		w.preCheckout(p.endpoint, u.PreCheckoutQuery)
+		return
+	}
 	if u.Message != nil && u.Message.Chat != nil {
-		if newMembers := u.Message.NewChatMembers; newMembers != nil && len(*newMembers) > 0 {
+		if payment := u.Message.SuccessfulPayment; payment != nil {
+			w.successfulPayment(p.endpoint, u.Message.Chat.ID, payment)
+		} else if newMembers := u.Message.NewChatMembers; newMembers != nil && len(*newMembers) > 0 {
 			ourIDs := w.ourIDs()
 		addedToChat:
 			for _, m := range *newMembers {
@@ -1967,11 +6921,27 @@ func (w *worker) processTGUpdate(p incomingPacket) {
 				}
 			}
 		} else if u.Message.IsCommand() {
+			if reply := u.Message.ReplyToMessage; u.Message.Command() == "import" && reply != nil && reply.Document != nil {
+				w.importModels(p.endpoint, u.Message.Chat.ID, reply.Document)
+				return
+			}
+			if reply := u.Message.ReplyToMessage; u.Message.Command() == "special_import" && u.Message.Chat.ID == w.cfg.AdminID && reply != nil && reply.Document != nil {
+				w.importSpecialModels(p.endpoint, reply.Document)
+				return
+			}
 			w.processIncomingCommand(p.endpoint, u.Message.Chat.ID, u.Message.Command(), strings.TrimSpace(u.Message.CommandArguments()), now)
 		} else {
 			if u.Message.Text == "" {
 				return
 			}
+			key := chat{endpoint: p.endpoint, chatID: u.Message.Chat.ID}
+			if request, ok := w.pendingFeedback[key]; ok && !strings.HasPrefix(u.Message.Text, "/") {
+				delete(w.pendingFeedback, key)
+				if request.expires > now {
+					w.feedback(p.endpoint, u.Message.Chat.ID, request.category, strings.TrimSpace(u.Message.Text), now)
+					return
+				}
+			}
 			parts := strings.SplitN(u.Message.Text, " ", 2)
 			if parts[0] == "" {
 				return
@@ -2041,6 +7011,9 @@ func (w *worker) getStat(endpoint string) statistics {
 		UpdatesDurationMilliseconds:    int(w.updatesDuration.Milliseconds()),
 		ErrorRate:                      [2]int{w.unsuccessfulRequestsCount(), w.cfg.errorDenominator},
 		DownloadErrorRate:              [2]int{w.downloadErrorsCount(), w.cfg.errorDenominator},
+		ImageCacheHits:                 w.imageCacheHits,
+		ImageCacheMisses:               w.imageCacheMisses,
+		ImageCacheSize:                 len(w.imageCache),
 		Rss:                            rss / 1024,
 		MaxRss:                         rusage.Maxrss,
 		UserReferralsCount:             w.userReferralsCount(),
@@ -2048,6 +7021,9 @@ func (w *worker) getStat(endpoint string) statistics {
 		ReportsCount:                   w.reports(),
 		ChangesInPeriod:                w.changesInPeriod,
 		ConfirmedChangesInPeriod:       w.confirmedChangesInPeriod,
+		OnlineChangesInPeriod:          w.onlineChangesInPeriod,
+		OfflineChangesInPeriod:         w.offlineChangesInPeriod,
+		ConfirmationFactor:             w.confirmationFactor,
 		Interactions:                   w.interactions(endpoint),
 	}
 }
@@ -2085,29 +7061,36 @@ func (w *worker) processStatCommand(endpoint string, writer http.ResponseWriter,
 	}
 }
 
-func (w *worker) handleIPN(ipnRequests chan ipnRequest) func(writer http.ResponseWriter, r *http.Request) {
+func (w *worker) handleIPN(cp *coinPaymentsConfig, ipnRequests chan ipnRequest) func(writer http.ResponseWriter, r *http.Request) {
 	return func(writer http.ResponseWriter, r *http.Request) {
 		command := ipnRequest{
-			writer:  writer,
-			request: r,
-			done:    make(chan bool),
+			coinPayments: cp,
+			writer:       writer,
+			request:      r,
+			done:         make(chan bool),
 		}
 		ipnRequests <- command
 		<-command.done
 	}
 }
 
-func (w *worker) processIPN(writer http.ResponseWriter, r *http.Request, done chan bool) {
+func (w *worker) processIPN(cp *coinPaymentsConfig, writer http.ResponseWriter, r *http.Request, done chan bool) {
 	defer func() { done <- true }()
 
 	linf("got IPN data")
 
-	newStatus, custom, err := payments.ParseIPN(r, w.cfg.CoinPayments.IPNSecret, w.cfg.Debug)
+	newStatus, custom, err := payments.ParseIPN(r, cp.IPNSecret, w.cfg.Debug)
 	if err != nil {
 		lerr("error on processing IPN, %v", err)
 		return
 	}
 
+	_, _, transactionEndpoint, found := w.transaction(custom)
+	if found && w.coinPayments(transactionEndpoint) != cp {
+		lerr("transaction %s does not belong to the CoinPayments account of the IPN listen URL", custom)
+		return
+	}
+
 	switch newStatus {
 	case payments.StatusFinished:
 		oldStatus, chatID, endpoint, found := w.transaction(custom)
@@ -2119,17 +7102,15 @@ func (w *worker) processIPN(writer http.ResponseWriter, r *http.Request, done ch
 			lerr("transaction is already finished")
 			return
 		}
+		if oldStatus == payments.StatusRevoked {
+			lerr("transaction is revoked")
+			return
+		}
 		if oldStatus == payments.StatusUnknown {
 			lerr("unknown transaction ID")
 			return
 		}
-		w.mustExec("update transactions set status=? where local_id=?", payments.StatusFinished, custom)
-		w.mustExec("update users set max_models = max_models + (select coalesce(sum(model_number), 0) from transactions where local_id=?)", custom)
-		user := w.mustUser(chatID)
-		w.sendTr(w.lowPriorityMsg, endpoint, chatID, false, w.tr[endpoint].PaymentComplete, tplData{"max_models": user.maxModels})
-		linf("payment %s is finished", custom)
-		text := fmt.Sprintf("payment %s is finished", custom)
-		w.sendText(w.lowPriorityMsg, w.cfg.AdminEndpoint, w.cfg.AdminID, false, true, lib.ParseRaw, text)
+		w.completePayment(endpoint, chatID, custom)
 	case payments.StatusCanceled:
 		w.mustExec("update transactions set status=? where local_id=?", payments.StatusCanceled, custom)
 		linf("payment %s is canceled", custom)
@@ -2142,14 +7123,44 @@ func (w *worker) processIPN(writer http.ResponseWriter, r *http.Request, done ch
 	}
 }
 
+// completePayment finishes the transaction, adds the subscriptions of the packet to the user
+// and notifies the user and the admin
+func (w *worker) completePayment(endpoint string, chatID int64, localID string) {
+	w.mustExec("update transactions set status=? where local_id=?", payments.StatusFinished, localID)
+	w.mustExec("update users set promo_discount=0 where chat_id=?", chatID)
+	expiresAt := 0
+	if days := w.coinPayments(endpoint).SubscriptionPacketDays; days == 0 {
+		w.grantPacket(chatID, localID)
+	} else {
+		expiresAt = w.startPacket(chatID, localID, days, int(time.Now().Unix()))
+	}
+	user := w.mustUser(chatID)
+	data := tplData{"max_models": user.maxModels}
+	if expiresAt != 0 {
+		data["expires"] = time.Unix(int64(expiresAt), 0).UTC().Format("2006-01-02 15:04")
+	}
+	w.sendTr(w.lowPriorityMsg, endpoint, chatID, false, w.tr[endpoint].PaymentComplete, data)
+	w.sendReceipt(endpoint, chatID, localID)
+	linf("payment %s is finished", localID)
+	text := fmt.Sprintf("payment %s is finished", localID)
+	w.sendText(w.lowPriorityMsg, w.cfg.AdminEndpoint, w.cfg.AdminID, false, true, lib.ParseRaw, text)
+}
+
 func (w *worker) handleStatEndpoints(statRequests chan statRequest) {
 	for n, p := range w.cfg.Endpoints {
 		http.HandleFunc(p.WebhookDomain+"/stat", w.handleStat(n, statRequests))
 	}
 }
 
-func (w *worker) handleIPNEndpoint(ipnRequests chan ipnRequest) {
-	http.HandleFunc(w.cfg.CoinPayments.IPNListenURL, w.handleIPN(ipnRequests))
+func (w *worker) handleIPNEndpoints(ipnRequests chan ipnRequest) {
+	if w.cfg.CoinPayments != nil {
+		http.HandleFunc(w.cfg.CoinPayments.IPNListenURL, w.handleIPN(w.cfg.CoinPayments, ipnRequests))
+	}
+	for _, p := range w.cfg.Endpoints {
+		if p.CoinPayments != nil {
+			http.HandleFunc(p.CoinPayments.IPNListenURL, w.handleIPN(p.CoinPayments, ipnRequests))
+		}
+	}
 }
 
 func (w *worker) incoming() chan incomingPacket {
@@ -2208,6 +7219,40 @@ func (q queryDurationsData) total() float64 {
 	return q.avg * float64(q.count)
 }
 
+func (w *worker) logClientResult(r lib.RequestResult) {
+	ring := w.clientResults[r.Client]
+	if ring == nil {
+		ring = &successRing{results: make([]bool, w.cfg.errorDenominator)}
+		w.clientResults[r.Client] = ring
+	}
+	ring.results[ring.pos] = r.Success
+	ring.pos = (ring.pos + 1) % len(ring.results)
+	if ring.count < len(ring.results) {
+		ring.count++
+	}
+}
+
+func (w *worker) sources(endpoint string) {
+	var lines []string
+	for i, c := range w.clients {
+		successes, total := 0, 0
+		if ring := w.clientResults[c]; ring != nil {
+			total = ring.count
+			for i := 0; i < ring.count; i++ {
+				if ring.results[i] {
+					successes++
+				}
+			}
+		}
+		addr := w.cfg.SourceIPAddresses[i]
+		if addr == "" {
+			addr = "default"
+		}
+		lines = append(lines, fmt.Sprintf("%s: %d/%d successful", addr, successes, total))
+	}
+	w.sendText(w.highPriorityMsg, endpoint, w.cfg.AdminID, false, true, lib.ParseRaw, strings.Join(lines, "\n"))
+}
+
 func (w *worker) logQuerySuccess(success bool) {
 	w.unsuccessfulRequests[w.successfulRequestsPos] = !success
 	w.successfulRequestsPos = (w.successfulRequestsPos + 1) % w.cfg.errorDenominator
@@ -2222,21 +7267,23 @@ func main() {
 	w.setCommands()
 	w.initBotNames()
 	w.createDatabase()
+	w.logPragmas()
+	w.mergeRemovedCanaries()
 	w.initCache()
+	w.resumeBroadcasts()
 
 	incoming := w.incoming()
 	statRequests := make(chan statRequest)
 	w.handleStatEndpoints(statRequests)
 
 	ipnRequests := make(chan ipnRequest)
-	if w.cfg.CoinPayments != nil {
-		w.handleIPNEndpoint(ipnRequests)
-	}
+	w.handleIPNEndpoints(ipnRequests)
 
 	w.serveEndpoints()
 	mail := make(chan *env)
 
 	if w.cfg.Mail != nil {
+		w.startMailWorkers()
 		smtp := &smtpd.Server{
 			Hostname:  w.cfg.Mail.Host,
 			Addr:      w.cfg.Mail.ListenAddress,
@@ -2251,18 +7298,37 @@ func main() {
 
 	go w.sender(w.highPriorityMsg, 0)
 	go w.sender(w.lowPriorityMsg, 1)
+	if w.cfg.DeliveryWebhookURL != "" {
+		w.deliveryWebhook = make(chan msgSendResult, deliveryWebhookQueueSize)
+		go w.postDeliveryResults(&http.Client{Timeout: time.Duration(deliveryWebhookTimeoutSeconds) * time.Second})
+	}
 
 	var periodicTimer = time.NewTicker(time.Duration(w.cfg.PeriodSeconds) * time.Second)
-	statusRequestsChan, onlineModelsChan, errorsChan, elapsed := lib.StartChecker(
-		w.checkModel,
+	// a nil channel is never ready, so the disabled timers are never selected
+	var pruningTimer <-chan time.Time
+	if w.cfg.InteractionsRetentionDays > 0 {
+		pruningTimer = time.NewTicker(time.Duration(w.cfg.PruningPeriodMinutes) * time.Minute).C
+		w.pruneInteractions(time.Now())
+	}
+	var stagingTimer <-chan time.Time
+	if w.cfg.SpreadSubscribersThreshold > 0 {
+		stagingTimer = time.NewTicker(time.Second).C
+	} else {
+		// the notifications staged before spreading was disabled are never released
+		w.mustExec("delete from staged_notifications")
+	}
+	statusRequestsChan, onlineModelsChan, errorsChan, elapsed, requestResults, unknownStatuses := lib.StartChecker(
+		w.cfg.checkerKind,
+		w.retryingChecker(),
 		w.onlineModelsAPI,
 		w.cfg.UsersOnlineEndpoint,
 		w.clients,
-		w.cfg.Headers,
+		w.headers.Headers,
 		w.cfg.IntervalMs,
 		w.cfg.Debug,
 		w.cfg.SpecificConfig)
-	statusRequestsChan <- lib.StatusRequest{SpecialModels: w.specialModels}
+	statusRequestsChan <- w.statusRequest()
+	w.lastOnlineUpdate = time.Now()
 	signals := make(chan os.Signal, 16)
 	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGABRT)
 	for {
@@ -2272,12 +7338,21 @@ func main() {
 		case <-periodicTimer.C:
 			runtime.GC()
 			w.processPeriodic(statusRequestsChan)
+		case <-pruningTimer:
+			w.pruneInteractions(time.Now())
+		case <-stagingTimer:
+			if released := w.releaseStaged(time.Now()); len(released) > 0 {
+				w.notifyOfStatuses(w.lowPriorityMsg, released)
+			}
 		case onlineModels := <-onlineModelsChan:
+			w.onlineModelsUpdated(time.Now())
 			now := int(time.Now().Unix())
 			changesInPeriod, confirmedChangesInPeriod, notifications, elapsed := w.processStatusUpdates(onlineModels, now)
 			w.updatesDuration = elapsed
 			w.changesInPeriod = changesInPeriod
 			w.confirmedChangesInPeriod = confirmedChangesInPeriod
+			w.adaptConfirmation(changesInPeriod, confirmedChangesInPeriod)
+			notifications = w.stageNotifications(notifications, time.Now())
 			w.notifyOfStatuses(w.lowPriorityMsg, notifications)
 			if w.cfg.Debug {
 				ldbg("status updates processed in %v", elapsed)
@@ -2285,6 +7360,20 @@ func main() {
 			w.logQuerySuccess(true)
 		case <-errorsChan:
 			w.logQuerySuccess(false)
+		case r := <-requestResults:
+			w.logClientResult(r)
+		case raw := <-unknownStatuses:
+			w.reportUnknownStatus(raw, time.Now())
+		case r := <-w.importResults:
+			w.processImportResult(r, int(time.Now().Unix()))
+		case r := <-w.addResults:
+			w.processAddResult(r, int(time.Now().Unix()))
+		case r := <-w.checkListResults:
+			w.processCheckListResult(r)
+		case r := <-w.auditResults:
+			w.processAuditResult(r)
+		case r := <-w.watchResults:
+			w.processWatchResult(r, int(time.Now().Unix()))
 		case u := <-incoming:
 			w.processTGUpdate(u)
 		case m := <-mail:
@@ -2292,7 +7381,7 @@ func main() {
 		case s := <-statRequests:
 			w.processStatCommand(s.endpoint, s.writer, s.request, s.done)
 		case s := <-ipnRequests:
-			w.processIPN(s.writer, s.request, s.done)
+			w.processIPN(s.coinPayments, s.writer, s.request, s.done)
 		case s := <-signals:
 			linf("got signal %v", s)
 			w.removeWebhook()
@@ -2301,6 +7390,8 @@ func main() {
 			switch r.result {
 			case messageBlocked:
 				w.incrementBlock(r.endpoint, r.chatID)
+			case messageChatNotFound:
+				w.chatNotFound(r.endpoint, r.chatID)
 			case messageSent:
 				w.resetBlock(r.endpoint, r.chatID)
 			}
@@ -2311,6 +7402,9 @@ func main() {
 				r.endpoint,
 				r.priority,
 				r.delay)
+			w.recordSendResult(r, int(time.Now().Unix()))
+			w.processBroadcastResult(r)
+			w.enqueueDeliveryResult(r)
 		}
 	}
 }
@@ -2,6 +2,8 @@ package main
 
 import (
 	"database/sql"
+
+	"github.com/bcmk/siren/payments"
 )
 
 var migrations = []func(w *worker){
@@ -97,6 +99,220 @@ var migrations = []func(w *worker){
 	func(w *worker) {
 		w.mustExec("alter table models add special integer not null default 0;")
 	},
+	func(w *worker) {
+		w.mustExec(`
+			create table scheduled_broadcasts (
+				id integer primary key,
+				endpoint text not null,
+				timestamp integer not null,
+				text text not null);`)
+	},
+	func(w *worker) {
+		w.mustExec("alter table models add notification_interval integer not null default 0;")
+		w.mustExec("alter table models add last_notified integer not null default 0;")
+	},
+	func(w *worker) {
+		w.mustExec("alter table models add category text not null default '';")
+	},
+	func(w *worker) {
+		w.mustExec("alter table users add snooze integer not null default 0;")
+		w.mustExec("alter table users add resume_timestamp integer not null default 0;")
+	},
+	func(w *worker) {
+		w.mustExec("alter table models add display_name text not null default '';")
+	},
+	func(w *worker) {
+		w.mustExec("alter table signals add created_at integer not null default 0;")
+	},
+	func(w *worker) {
+		w.mustExec("alter table users add daily_notifications integer not null default 0;")
+		w.mustExec(`
+			create table sent_notifications (
+				chat_id integer not null,
+				timestamp integer not null);`)
+		w.mustExec("create index ix_sent_notifications_chat_id_timestamp on sent_notifications (chat_id, timestamp);")
+	},
+	func(w *worker) {
+		w.mustExec(`
+			create table canary_users (
+				chat_id integer not null,
+				endpoint text not null,
+				primary key (chat_id, endpoint));`)
+	},
+	func(w *worker) {
+		w.mustExec(`
+			create table referral_attempts (
+				follower_chat_id integer not null,
+				referrer_chat_id integer not null,
+				timestamp integer not null,
+				applied integer not null);`)
+		w.mustExec("create index ix_referral_attempts_follower_chat_id on referral_attempts (follower_chat_id);")
+	},
+	func(w *worker) {
+		w.mustExec(`
+			create table reminders (
+				endpoint text not null,
+				chat_id integer not null,
+				model_id text not null,
+				hour integer not null,
+				next_timestamp integer not null,
+				primary key (endpoint, chat_id, model_id));`)
+	},
+	func(w *worker) {
+		w.mustExec("alter table feedback add category text not null default '';")
+	},
+	func(w *worker) {
+		w.mustExec("alter table models add offline_confirmation_seconds integer not null default 0;")
+	},
+	func(w *worker) {
+		w.mustExec("alter table block add probed_at integer not null default 0;")
+	},
+	func(w *worker) {
+		w.mustExec("alter table models add original_id text not null default '';")
+	},
+	func(w *worker) {
+		w.mustExec("alter table users add mute_until integer not null default 0;")
+	},
+	func(w *worker) {
+		w.mustExec("alter table users add image_limit integer not null default 0;")
+	},
+	func(w *worker) {
+		w.mustExec("alter table signals add notify_online integer not null default 1;")
+		w.mustExec("alter table signals add notify_offline integer not null default 1;")
+	},
+	func(w *worker) {
+		w.mustExec("alter table users add quiet_from integer not null default 0;")
+		w.mustExec("alter table users add quiet_to integer not null default 0;")
+		w.mustExec(`
+			create table if not exists held_notifications (
+				endpoint text not null,
+				chat_id integer not null,
+				model_id text not null,
+				status integer not null,
+				timestamp integer not null);`)
+	},
+	func(w *worker) {
+		w.mustExec(`
+			create table if not exists aliases (
+				endpoint text not null,
+				chat_id integer not null,
+				model_id text not null,
+				alias text not null,
+				primary key (endpoint, chat_id, model_id));`)
+	},
+	func(w *worker) {
+		w.mustExec(`
+			create table if not exists tags (
+				endpoint text not null,
+				chat_id integer not null,
+				model_id text not null,
+				tag text not null,
+				primary key (endpoint, chat_id, model_id));`)
+	},
+	func(w *worker) {
+		w.mustExec("alter table users add temp_limit_until integer not null default 0;")
+		w.mustExec("alter table users add temp_limit_original integer not null default 0;")
+		w.mustExec("alter table users add temp_limit_granted integer not null default 0;")
+	},
+	func(w *worker) {
+		w.mustExec("alter table users add timezone text not null default '';")
+	},
+	func(w *worker) {
+		w.mustExec("alter table users add language text not null default '';")
+	},
+	func(w *worker) {
+		w.mustExec("alter table users add min_online_minutes integer not null default 0;")
+	},
+	func(w *worker) {
+		w.mustExec("alter table users add paused integer not null default 0;")
+	},
+	func(w *worker) {
+		w.mustExec("alter table transactions add expires_at integer not null default 0;")
+		w.mustExec("alter table transactions add expiry_warned integer not null default 0;")
+		w.mustExec("alter table transactions add expired integer not null default 0;")
+	},
+	func(w *worker) {
+		w.mustExec(`
+			create table if not exists promo_codes (
+				code text primary key,
+				discount_percent integer not null default 0,
+				bonus_models integer not null default 0,
+				max_redemptions integer not null default 0,
+				expires_at integer not null default 0,
+				redemptions integer not null default 0);`)
+		w.mustExec(`
+			create table if not exists promo_redemptions (
+				code text not null,
+				chat_id integer not null,
+				timestamp integer not null,
+				primary key (code, chat_id));`)
+		w.mustExec("alter table users add promo_discount integer not null default 0;")
+	},
+	func(w *worker) {
+		w.mustExec("alter table users add image_links integer not null default 0;")
+	},
+	func(w *worker) {
+		w.mustExec("alter table feedback add timestamp integer not null default 0;")
+		w.mustExec("alter table feedback add handled integer not null default 0;")
+	},
+	func(w *worker) {
+		w.mustExec(`
+			create table if not exists model_id_aliases (
+				model_id text primary key,
+				canonical_id text not null);`)
+	},
+	func(w *worker) {
+		w.mustExec(`
+			create table if not exists broadcasts (
+				id integer primary key,
+				endpoint text not null,
+				text text not null,
+				total integer not null,
+				started_at integer not null,
+				reported_at integer not null default 0,
+				canceled integer not null default 0,
+				finished integer not null default 0);`)
+		w.mustExec(`
+			create table if not exists broadcast_chats (
+				broadcast_id integer not null,
+				chat_id integer not null,
+				state integer not null default 0,
+				primary key (broadcast_id, chat_id));`)
+	},
+	func(w *worker) {
+		w.mustExec(`
+			create table held_notifications_ordered (
+				id integer primary key,
+				endpoint text not null,
+				chat_id integer not null,
+				model_id text not null,
+				status integer not null,
+				timestamp integer not null);`)
+		w.mustExec(`
+			insert into held_notifications_ordered (endpoint, chat_id, model_id, status, timestamp)
+			select endpoint, chat_id, model_id, status, timestamp from held_notifications order by timestamp;`)
+		w.mustExec("drop table held_notifications;")
+		w.mustExec("alter table held_notifications_ordered rename to held_notifications;")
+	},
+	func(w *worker) {
+		w.mustExec("alter table broadcast_chats add endpoint text not null default '';")
+		w.mustExec("update broadcast_chats set endpoint=(select endpoint from broadcasts where broadcasts.id=broadcast_chats.broadcast_id);")
+	},
+	func(w *worker) {
+		w.mustExec("alter table transactions add granted integer not null default 0;")
+		w.mustExec("update transactions set granted=model_number where status=? and expired=0;", payments.StatusFinished)
+	},
+	func(w *worker) {
+		w.mustExec(`
+			create table if not exists staged_notifications (
+				id integer primary key,
+				endpoint text not null,
+				chat_id integer not null,
+				model_id text not null,
+				status integer not null,
+				release_at integer not null);`)
+		w.mustExec("create index ix_staged_notifications_release_at on staged_notifications (release_at);")
+	},
 }
 
 func (w *worker) applyMigrations() {
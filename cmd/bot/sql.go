@@ -17,6 +17,9 @@ var updateModelStatus = `
 	insert into models (model_id, status)
 	values (?,?)
 	on conflict(model_id) do update set status=excluded.status`
+var updateModelCategory = "update models set category=? where model_id=?"
+var updateModelDisplayName = "update models set display_name=? where model_id=?"
+var updateModelOriginalID = "update models set original_id=? where model_id=?"
 
 func (w *worker) measure(query string) func() {
 	now := time.Now()
@@ -29,44 +32,51 @@ func (w *worker) measure(query string) func() {
 	}
 }
 
+// mustPrepare prepares a statement in the transaction
+func (w *worker) mustPrepare(tx *sql.Tx, query string) *sql.Stmt {
+	stmt, err := tx.Prepare(w.dialect.rebind(query))
+	checkErr(err)
+	return stmt
+}
+
 func (w *worker) mustExec(query string, args ...interface{}) {
 	defer w.measure("db: " + query)()
-	stmt, err := w.db.Prepare(query)
+	stmt, err := w.db.Prepare(w.dialect.rebind(query))
 	checkErr(err)
-	_, err = stmt.Exec(args...)
+	_, err = stmt.Exec(w.dialect.args(args)...)
 	checkErr(err)
 	checkErr(stmt.Close())
 }
 
 func (w *worker) mustExecPrepared(query string, stmt *sql.Stmt, args ...interface{}) {
-	_, err := stmt.Exec(args...)
+	_, err := stmt.Exec(w.dialect.args(args)...)
 	checkErr(err)
 }
 
 func (w *worker) mustInt(query string, args ...interface{}) (result int) {
 	defer w.measure("db: " + query)()
-	row := w.db.QueryRow(query, args...)
+	row := w.db.QueryRow(w.dialect.rebind(query), w.dialect.args(args)...)
 	checkErr(row.Scan(&result))
 	return result
 }
 
 func (w *worker) mustString(query string, args ...interface{}) (result string) {
 	defer w.measure("db: " + query)()
-	row := w.db.QueryRow(query, args...)
+	row := w.db.QueryRow(w.dialect.rebind(query), w.dialect.args(args)...)
 	checkErr(row.Scan(&result))
 	return result
 }
 
 func (w *worker) mustQuery(query string, args ...interface{}) *sql.Rows {
 	defer w.measure("db: " + query)()
-	result, err := w.db.Query(query, args...)
+	result, err := w.db.Query(w.dialect.rebind(query), w.dialect.args(args)...)
 	checkErr(err)
 	return result
 }
 
 func (w *worker) maybeRecord(query string, args queryParams, record record) bool {
 	defer w.measure("db: " + query)()
-	row := w.db.QueryRow(query, args...)
+	row := w.db.QueryRow(w.dialect.rebind(query), w.dialect.args(args)...)
 	err := row.Scan(record...)
 	if err == sql.ErrNoRows {
 		return false
@@ -74,3 +84,30 @@ func (w *worker) maybeRecord(query string, args queryParams, record record) bool
 	checkErr(err)
 	return true
 }
+
+// moveRows sets the column of the table to a new value in the transaction,
+// the rows conflicting on the key columns with the rows already having the new value are deleted,
+// it returns the number of the moved rows and the chats of the deleted ones
+func (w *worker) moveRows(tx *sql.Tx, table string, column string, key []string, from string, to string) (moved int64, dropped []int64) {
+	conflict := ""
+	for _, k := range key {
+		conflict += " and existing." + k + "=" + table + "." + k
+	}
+	conflicting := "from " + table + " where " + column + "=? and exists (select 1 from " + table + " existing where existing." + column + "=?" + conflict + ")"
+	rows, err := tx.Query(w.dialect.rebind("select chat_id "+conflicting), from, to)
+	checkErr(err)
+	for rows.Next() {
+		var chatID int64
+		checkErr(rows.Scan(&chatID))
+		dropped = append(dropped, chatID)
+	}
+	checkErr(rows.Err())
+	checkErr(rows.Close())
+	_, err = tx.Exec(w.dialect.rebind("delete "+conflicting), from, to)
+	checkErr(err)
+	updated, err := tx.Exec(w.dialect.rebind("update "+table+" set "+column+"=? where "+column+"=?"), to, from)
+	checkErr(err)
+	moved, err = updated.RowsAffected()
+	checkErr(err)
+	return
+}
@@ -17,6 +17,9 @@ type statistics struct {
 	UpdatesDurationMilliseconds    int         `json:"updates_duration_milliseconds"`
 	ErrorRate                      [2]int      `json:"error_rate"`
 	DownloadErrorRate              [2]int      `json:"download_error_rate"`
+	ImageCacheHits                 int         `json:"image_cache_hits"`
+	ImageCacheMisses               int         `json:"image_cache_misses"`
+	ImageCacheSize                 int         `json:"image_cache_size"`
 	Rss                            int64       `json:"rss"`
 	MaxRss                         int64       `json:"max_rss"`
 	TransactionsOnEndpointCount    int         `json:"transactions_on_endpoint_count"`
@@ -26,5 +29,8 @@ type statistics struct {
 	ReportsCount                   int         `json:"reports_count"`
 	ChangesInPeriod                int         `json:"changes_in_period"`
 	ConfirmedChangesInPeriod       int         `json:"confirmed_changes_in_period"`
+	OnlineChangesInPeriod          int         `json:"online_changes_in_period"`
+	OfflineChangesInPeriod         int         `json:"offline_changes_in_period"`
+	ConfirmationFactor             int         `json:"confirmation_factor"`
 	Interactions                   map[int]int `json:"interactions"`
 }
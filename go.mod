@@ -7,6 +7,7 @@ require (
 	github.com/chromedp/chromedp v0.5.3
 	github.com/google/uuid v1.1.1
 	github.com/jhillyerd/enmime v0.7.0
+	github.com/lib/pq v1.10.9
 	github.com/mattn/go-sqlite3 v1.10.0
 	github.com/shopspring/decimal v0.0.0-20200105231215-408a2507e114
 	gopkg.in/yaml.v3 v3.0.0-20200506231410-2ff61e1afc86
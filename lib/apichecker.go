@@ -1,10 +1,17 @@
 package lib
 
 import (
+	"errors"
 	"strings"
 	"time"
 )
 
+// RequestResult represents a result of a single request made by a client
+type RequestResult struct {
+	Client  *Client
+	Success bool
+}
+
 type clientsLoop struct {
 	clients   []*Client
 	clientIdx int
@@ -19,14 +26,41 @@ func (c *clientsLoop) nextClient() *Client {
 	return client
 }
 
-// StartChecker starts a checker for Chaturbate
+// ModelChecker checks the status of a single model
+type ModelChecker func(client *Client, modelID string, headers [][2]string, dbg bool, specificConfig map[string]string) (StatusKind, error)
+
+// RetryChecker wraps a model checker to retry network errors,
+// the backoff doubles after every attempt, definitive statuses are returned immediately
+func RetryChecker(checker ModelChecker, retries int, backoff time.Duration) ModelChecker {
+	return func(client *Client, modelID string, headers [][2]string, dbg bool, specificConfig map[string]string) (StatusKind, error) {
+		status, err := checker(client, modelID, headers, dbg, specificConfig)
+		for i := 0; i < retries; i++ {
+			var checkError *CheckError
+			if !errors.As(err, &checkError) || checkError.Kind != CheckNetworkError {
+				break
+			}
+			if dbg {
+				Ldbg("retrying the check of the model %s after %v, %v", modelID, backoff, err)
+			}
+			time.Sleep(backoff)
+			backoff *= 2
+			status, err = checker(client, modelID, headers, dbg, specificConfig)
+		}
+		return status, err
+	}
+}
+
+// StartChecker starts a checker for Chaturbate,
+// a polling checker queries the requested models one by one instead of the online API,
+// the models it cannot check are reported as unchecked
 func StartChecker(
+	checkerKind CheckerKind,
 	singleChecker func(
 		client *Client,
 		modelID string,
 		headers [][2]string,
 		dbg bool,
-		specificConfig map[string]string) StatusKind,
+		specificConfig map[string]string) (StatusKind, error),
 	apiChecker func(
 		usersOnlineEndpoint string,
 		client *Client,
@@ -39,7 +73,7 @@ func StartChecker(
 	),
 	usersOnlineEndpoint []string,
 	clients []*Client,
-	headers [][2]string,
+	headers func() [][2]string,
 	intervalMs int,
 	dbg bool,
 	specificConfig map[string]string,
@@ -48,21 +82,54 @@ func StartChecker(
 	output chan []OnlineModel,
 	errorsCh chan struct{},
 	elapsedCh chan time.Duration,
+	requestResultsCh chan RequestResult,
+	unknownStatusesCh chan string,
 ) {
 	statusRequests = make(chan StatusRequest)
 	output = make(chan []OnlineModel)
 	errorsCh = make(chan struct{})
 	elapsedCh = make(chan time.Duration)
+	requestResultsCh = make(chan RequestResult)
+	unknownStatusesCh = make(chan string)
 	clientsLoop := clientsLoop{clients: clients}
+	if checkerKind == CheckerPolling {
+		// the online API is not queried at all
+		usersOnlineEndpoint = nil
+	}
 	go func() {
 	requests:
 		for request := range statusRequests {
 			hash := map[string]OnlineModel{}
 			updates := []OnlineModel{}
 			start := time.Now()
+			if checkerKind == CheckerPolling {
+				for modelID := range request.Models {
+					if request.SpecialModels[modelID] {
+						continue
+					}
+					time.Sleep(time.Duration(intervalMs) * time.Millisecond)
+					client := clientsLoop.nextClient()
+					status, err := singleChecker(client, modelID, headers(), dbg, specificConfig)
+					requestResultsCh <- RequestResult{Client: client, Success: status != StatusUnknown}
+					if status == StatusUnknown {
+						Lerr("status for model %s reported: %v, %v", modelID, status, err)
+						var unknownStatus *UnknownStatusError
+						if errors.As(err, &unknownStatus) {
+							unknownStatusesCh <- unknownStatus.Raw
+						}
+						errorsCh <- struct{}{}
+						hash[modelID] = OnlineModel{ModelID: modelID, Unchecked: true}
+						continue
+					}
+					if status == StatusOnline || status == StatusIdle || status == StatusPrivate {
+						hash[modelID] = OnlineModel{ModelID: modelID, Idle: status == StatusIdle, Private: status == StatusPrivate}
+					}
+				}
+			}
 			for _, endpoint := range usersOnlineEndpoint {
 				client := clientsLoop.nextClient()
-				onlineModels, err := apiChecker(endpoint, client, headers, dbg, specificConfig)
+				onlineModels, err := apiChecker(endpoint, client, headers(), dbg, specificConfig)
+				requestResultsCh <- RequestResult{Client: client, Success: err == nil}
 				if err != nil {
 					Lerr("[%v] %v", client.Addr, err)
 					errorsCh <- struct{}{}
@@ -72,6 +139,9 @@ func StartChecker(
 					Ldbg("online models for endpoint: %d", len(onlineModels))
 				}
 				for _, m := range onlineModels {
+					if m.UnknownStatus != "" {
+						unknownStatusesCh <- m.UnknownStatus
+					}
 					m.ModelID = strings.ToLower(m.ModelID)
 					hash[m.ModelID] = m
 				}
@@ -79,9 +149,17 @@ func StartChecker(
 			for modelID := range request.SpecialModels {
 				time.Sleep(time.Duration(intervalMs) * time.Millisecond)
 				client := clientsLoop.nextClient()
-				status := singleChecker(client, modelID, headers, dbg, specificConfig)
-				if status == StatusOnline {
-					hash[modelID] = OnlineModel{ModelID: modelID}
+				status, err := singleChecker(client, modelID, headers(), dbg, specificConfig)
+				requestResultsCh <- RequestResult{Client: client, Success: status != StatusUnknown}
+				if status == StatusOnline || status == StatusIdle || status == StatusPrivate {
+					hash[modelID] = OnlineModel{ModelID: modelID, Idle: status == StatusIdle, Private: status == StatusPrivate}
+				} else if err != nil {
+					Lerr("status for model %s reported: %v, %v", modelID, status, err)
+					var unknownStatus *UnknownStatusError
+					if errors.As(err, &unknownStatus) {
+						unknownStatusesCh <- unknownStatus.Raw
+					}
+					errorsCh <- struct{}{}
 				} else if status != StatusOffline {
 					Lerr("status for model %s reported: %v", modelID, status)
 					errorsCh <- struct{}{}
@@ -12,13 +12,14 @@ import (
 
 type bongacamsModel struct {
 	Username      string `json:"username"`
+	DisplayName   string `json:"display_name"`
 	ProfileImages struct {
 		ThumbnailImageMediumLive string `json:"thumbnail_image_medium_live"`
 	} `json:"profile_images"`
 }
 
 // CheckModelBongaCams checks BongaCams model status
-func CheckModelBongaCams(client *Client, modelID string, headers [][2]string, dbg bool, _ map[string]string) StatusKind {
+func CheckModelBongaCams(client *Client, modelID string, headers [][2]string, dbg bool, _ map[string]string) (StatusKind, error) {
 	req, err := http.NewRequest("GET", fmt.Sprintf("https://en.bongacams.com/%s", modelID), nil)
 	CheckErr(err)
 	for _, h := range headers {
@@ -27,7 +28,7 @@ func CheckModelBongaCams(client *Client, modelID string, headers [][2]string, db
 	resp, err := client.Client.Do(req)
 	if err != nil {
 		Lerr("[%v] cannot send a query, %v", client.Addr, err)
-		return StatusUnknown
+		return StatusUnknown, NewCheckError(CheckNetworkError, err)
 	}
 	CheckErr(resp.Body.Close())
 	if dbg {
@@ -35,13 +36,13 @@ func CheckModelBongaCams(client *Client, modelID string, headers [][2]string, db
 	}
 	switch resp.StatusCode {
 	case 200:
-		return StatusOnline
+		return StatusOnline, nil
 	case 302:
-		return StatusOffline
+		return StatusOffline, nil
 	case 404:
-		return StatusNotFound
+		return StatusNotFound, nil
 	}
-	return StatusUnknown
+	return StatusUnknown, NewCheckError(CheckResponseError, fmt.Errorf("unexpected status code %d", resp.StatusCode))
 }
 
 // BongaCamsOnlineAPI returns BongaCams online models
@@ -79,7 +80,12 @@ func BongaCamsOnlineAPI(
 
 	for _, m := range parsed {
 		modelID := strings.ToLower(m.Username)
-		onlineModels[modelID] = OnlineModel{ModelID: modelID, Image: "https:" + m.ProfileImages.ThumbnailImageMediumLive}
+		onlineModels[modelID] = OnlineModel{
+			ModelID:     modelID,
+			OriginalID:  m.Username,
+			Image:       "https:" + m.ProfileImages.ThumbnailImageMediumLive,
+			DisplayName: m.DisplayName,
+		}
 	}
 	return
 }
@@ -0,0 +1,117 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// cam4MaxPages limits the number of pages of online models queried at once
+const cam4MaxPages = 100
+
+type cam4Model struct {
+	Username          string `json:"username"`
+	SnapshotImageLink string `json:"snapshotImageLink"`
+	ShowType          string `json:"showType"`
+}
+
+type cam4OnlineResponse struct {
+	Users   []cam4Model `json:"users"`
+	HasMore bool        `json:"hasMore"`
+}
+
+// CheckModelCam4 checks Cam4 model status
+func CheckModelCam4(client *Client, modelID string, headers [][2]string, dbg bool, _ map[string]string) (StatusKind, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("https://www.cam4.com/rest/v1.0/profile/%s/streamInfo", modelID), nil)
+	CheckErr(err)
+	for _, h := range headers {
+		req.Header.Set(h[0], h[1])
+	}
+	resp, err := client.Client.Do(req)
+	if err != nil {
+		Lerr("[%v] cannot send a query, %v", client.Addr, err)
+		return StatusUnknown, NewCheckError(CheckNetworkError, err)
+	}
+	defer func() {
+		CheckErr(resp.Body.Close())
+	}()
+	if dbg {
+		Ldbg("[%v] query status for %s: %d", client.Addr, modelID, resp.StatusCode)
+	}
+	switch resp.StatusCode {
+	case 200:
+		return StatusOnline, nil
+	case 204:
+		return StatusOffline, nil
+	case 403:
+		return StatusDenied, nil
+	case 404:
+		return StatusNotFound, nil
+	}
+	return StatusUnknown, NewCheckError(CheckResponseError, fmt.Errorf("unexpected status code %d", resp.StatusCode))
+}
+
+// cam4PageEndpoint returns the endpoint of the specific page of online models
+func cam4PageEndpoint(endpoint string, page int) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("page", strconv.Itoa(page))
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Cam4OnlineAPI returns Cam4 online models, it queries all the pages of online models
+func Cam4OnlineAPI(
+	endpoint string,
+	client *Client,
+	headers [][2]string,
+	dbg bool,
+	_ map[string]string,
+) (
+	onlineModels map[string]OnlineModel,
+	err error,
+) {
+	onlineModels = map[string]OnlineModel{}
+	for page := 1; page <= cam4MaxPages; page++ {
+		pageEndpoint, err := cam4PageEndpoint(endpoint, page)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse endpoint, %v", err)
+		}
+		resp, buf, err := onlineQuery(pageEndpoint, client, headers)
+		if err != nil {
+			return nil, fmt.Errorf("cannot send a query, %v", err)
+		}
+		if resp.StatusCode != 200 {
+			return nil, fmt.Errorf("query status, %d", resp.StatusCode)
+		}
+		decoder := json.NewDecoder(ioutil.NopCloser(bytes.NewReader(buf.Bytes())))
+		var parsed cam4OnlineResponse
+		err = decoder.Decode(&parsed)
+		if err != nil {
+			if dbg {
+				Ldbg("response: %s", buf.String())
+			}
+			return nil, fmt.Errorf("cannot parse response, %v", err)
+		}
+		for _, m := range parsed.Users {
+			modelID := CanonicalModelID(m.Username)
+			onlineModels[modelID] = OnlineModel{
+				ModelID:    modelID,
+				OriginalID: m.Username,
+				Image:      m.SnapshotImageLink,
+				Private:    m.ShowType == "PRIVATE" || m.ShowType == "GROUP",
+			}
+		}
+		if !parsed.HasMore || len(parsed.Users) == 0 {
+			return onlineModels, nil
+		}
+	}
+	return nil, fmt.Errorf("too many pages, more than %d", cam4MaxPages)
+}
@@ -30,7 +30,7 @@ type camSodaOnlineResponse struct {
 }
 
 // CheckModelCamSoda checks CamSoda model status
-func CheckModelCamSoda(client *Client, modelID string, headers [][2]string, dbg bool, _ map[string]string) StatusKind {
+func CheckModelCamSoda(client *Client, modelID string, headers [][2]string, dbg bool, _ map[string]string) (StatusKind, error) {
 	req, err := http.NewRequest("GET", fmt.Sprintf("https://feed.camsoda.com/api/v1/user/%s", modelID), nil)
 	CheckErr(err)
 	for _, h := range headers {
@@ -39,7 +39,7 @@ func CheckModelCamSoda(client *Client, modelID string, headers [][2]string, dbg
 	resp, err := client.Client.Do(req)
 	if err != nil {
 		Lerr("[%v] cannot send a query, %v", client.Addr, err)
-		return StatusUnknown
+		return StatusUnknown, NewCheckError(CheckNetworkError, err)
 	}
 	defer func() {
 		CheckErr(resp.Body.Close())
@@ -48,16 +48,16 @@ func CheckModelCamSoda(client *Client, modelID string, headers [][2]string, dbg
 		Ldbg("[%v] query status for %s: %d", client.Addr, modelID, resp.StatusCode)
 	}
 	if resp.StatusCode == 401 {
-		return StatusDenied
+		return StatusDenied, nil
 	}
 	if resp.StatusCode == 404 {
-		return StatusNotFound
+		return StatusNotFound, nil
 	}
 	buf := bytes.Buffer{}
 	_, err = buf.ReadFrom(resp.Body)
 	if err != nil {
 		Lerr("[%v] cannot read response for model %s, %v", client.Addr, modelID, err)
-		return StatusUnknown
+		return StatusUnknown, NewCheckError(CheckNetworkError, err)
 	}
 	decoder := json.NewDecoder(ioutil.NopCloser(bytes.NewReader(buf.Bytes())))
 	parsed := &camSodaUserResponse{}
@@ -67,13 +67,13 @@ func CheckModelCamSoda(client *Client, modelID string, headers [][2]string, dbg
 		if dbg {
 			Ldbg("response: %s", buf.String())
 		}
-		return StatusUnknown
+		return StatusUnknown, NewCheckError(CheckResponseError, err)
 	}
 	if !parsed.Status {
 		Lerr("[%v] API error for model %s, %s", client.Addr, modelID, parsed.Error)
-		return StatusUnknown
+		return StatusUnknown, NewCheckError(CheckResponseError, fmt.Errorf("API error, %s", parsed.Error))
 	}
-	return camSodaStatus(parsed.User.Chat.Status)
+	return checkedStatus(camSodaStatus(parsed.User.Chat.Status), parsed.User.Chat.Status)
 }
 
 func camSodaStatus(roomStatus string) StatusKind {
@@ -124,7 +124,7 @@ func CamSodaOnlineAPI(
 	}
 	for _, m := range parsed.Results {
 		modelID := strings.ToLower(m.Username)
-		onlineModels[modelID] = OnlineModel{ModelID: modelID, Image: m.Thumb}
+		onlineModels[modelID] = OnlineModel{ModelID: modelID, OriginalID: m.Username, Image: m.Thumb}
 	}
 	return
 }
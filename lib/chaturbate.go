@@ -10,8 +10,11 @@ import (
 )
 
 type chaturbateModel struct {
-	Username string `json:"username"`
-	ImageURL string `json:"image_url"`
+	Username    string   `json:"username"`
+	DisplayName string   `json:"display_name"`
+	ImageURL    string   `json:"image_url"`
+	Tags        []string `json:"tags"`
+	CurrentShow string   `json:"current_show"`
 }
 
 type chaturbateResponse struct {
@@ -19,7 +22,7 @@ type chaturbateResponse struct {
 }
 
 // CheckModelChaturbate checks Chaturbate model status
-func CheckModelChaturbate(client *Client, modelID string, headers [][2]string, dbg bool, _ map[string]string) StatusKind {
+func CheckModelChaturbate(client *Client, modelID string, headers [][2]string, dbg bool, _ map[string]string) (StatusKind, error) {
 	req, err := http.NewRequest("GET", fmt.Sprintf("https://en.chaturbate.com/api/chatvideocontext/%s/", modelID), nil)
 	CheckErr(err)
 	for _, h := range headers {
@@ -28,7 +31,7 @@ func CheckModelChaturbate(client *Client, modelID string, headers [][2]string, d
 	resp, err := client.Client.Do(req)
 	if err != nil {
 		Lerr("[%v] cannot send a query, %v", client.Addr, err)
-		return StatusUnknown
+		return StatusUnknown, NewCheckError(CheckNetworkError, err)
 	}
 	defer func() {
 		CheckErr(resp.Body.Close())
@@ -37,16 +40,16 @@ func CheckModelChaturbate(client *Client, modelID string, headers [][2]string, d
 		Ldbg("[%v] query status for %s: %d", client.Addr, modelID, resp.StatusCode)
 	}
 	if resp.StatusCode == 401 {
-		return StatusDenied
+		return StatusDenied, nil
 	}
 	if resp.StatusCode == 404 {
-		return StatusNotFound
+		return StatusNotFound, nil
 	}
 	buf := bytes.Buffer{}
 	_, err = buf.ReadFrom(resp.Body)
 	if err != nil {
 		Lerr("[%v] cannot read response for model %s, %v", client.Addr, modelID, err)
-		return StatusUnknown
+		return StatusUnknown, NewCheckError(CheckNetworkError, err)
 	}
 	decoder := json.NewDecoder(ioutil.NopCloser(bytes.NewReader(buf.Bytes())))
 	parsed := &chaturbateResponse{}
@@ -56,9 +59,9 @@ func CheckModelChaturbate(client *Client, modelID string, headers [][2]string, d
 		if dbg {
 			Ldbg("response: %s", buf.String())
 		}
-		return StatusUnknown
+		return StatusUnknown, NewCheckError(CheckResponseError, err)
 	}
-	return chaturbateStatus(parsed.RoomStatus)
+	return checkedStatus(chaturbateStatus(parsed.RoomStatus), parsed.RoomStatus)
 }
 
 func chaturbateStatus(roomStatus string) StatusKind {
@@ -66,9 +69,9 @@ func chaturbateStatus(roomStatus string) StatusKind {
 	case "public":
 		return StatusOnline
 	case "private":
-		return StatusOnline
+		return StatusPrivate
 	case "group":
-		return StatusOnline
+		return StatusPrivate
 	case "hidden":
 		return StatusOnline
 	case "connecting":
@@ -114,7 +117,24 @@ func ChaturbateOnlineAPI(
 	}
 	for _, m := range parsed {
 		modelID := strings.ToLower(m.Username)
-		onlineModels[modelID] = OnlineModel{ModelID: modelID, Image: m.ImageURL}
+		category := ""
+		if len(m.Tags) > 0 {
+			category = strings.ToLower(m.Tags[0])
+		}
+		unknownStatus := ""
+		if chaturbateStatus(m.CurrentShow) == StatusUnknown {
+			unknownStatus = m.CurrentShow
+		}
+		onlineModels[modelID] = OnlineModel{
+			ModelID:       modelID,
+			OriginalID:    m.Username,
+			Image:         m.ImageURL,
+			Category:      category,
+			DisplayName:   m.DisplayName,
+			Idle:          m.CurrentShow == "away",
+			Private:       chaturbateStatus(m.CurrentShow) == StatusPrivate,
+			UnknownStatus: unknownStatus,
+		}
 	}
 	return
 }
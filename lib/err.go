@@ -1,8 +1,50 @@
 package lib
 
+import "fmt"
+
 // CheckErr panics on an error
 func CheckErr(err error) {
 	if err != nil {
 		panic(err)
 	}
 }
+
+// CheckErrorKind represents a kind of a model check error
+type CheckErrorKind int
+
+// Model check error kinds
+const (
+	// CheckNetworkError means that the website could not be reached, the check can be retried later
+	CheckNetworkError CheckErrorKind = iota
+	// CheckResponseError means that the website returned an unexpected response
+	CheckResponseError
+)
+
+// CheckError is an error occurred while checking a model
+type CheckError struct {
+	Kind CheckErrorKind
+	Err  error
+}
+
+// NewCheckError returns a model check error of the specified kind
+func NewCheckError(kind CheckErrorKind, err error) *CheckError {
+	return &CheckError{Kind: kind, Err: err}
+}
+
+func (e *CheckError) Error() string {
+	switch e.Kind {
+	case CheckNetworkError:
+		return "network error, " + e.Err.Error()
+	default:
+		return "unexpected response, " + e.Err.Error()
+	}
+}
+
+func (e *CheckError) Unwrap() error { return e.Err }
+
+// UnknownStatusError is an error reporting a raw site status that does not map to a status kind
+type UnknownStatusError struct {
+	Raw string
+}
+
+func (e *UnknownStatusError) Error() string { return fmt.Sprintf("unknown room status %q", e.Raw) }
@@ -31,7 +31,7 @@ type flirt4FreeOnlineResponse struct {
 }
 
 // CheckModelFlirt4Free checks Flirt4Free model status
-func CheckModelFlirt4Free(client *Client, modelID string, headers [][2]string, dbg bool, _ map[string]string) StatusKind {
+func CheckModelFlirt4Free(client *Client, modelID string, headers [][2]string, dbg bool, _ map[string]string) (StatusKind, error) {
 	req, err := http.NewRequest("GET", fmt.Sprintf("https://ws.vs3.com/rooms/check-model-status.php?model_name=%s", modelID), nil)
 	CheckErr(err)
 	for _, h := range headers {
@@ -40,7 +40,7 @@ func CheckModelFlirt4Free(client *Client, modelID string, headers [][2]string, d
 	resp, err := client.Client.Do(req)
 	if err != nil {
 		Lerr("[%v] cannot send a query, %v", client.Addr, err)
-		return StatusUnknown
+		return StatusUnknown, NewCheckError(CheckNetworkError, err)
 	}
 	defer func() {
 		CheckErr(resp.Body.Close())
@@ -49,13 +49,13 @@ func CheckModelFlirt4Free(client *Client, modelID string, headers [][2]string, d
 		Ldbg("[%v] query status for %s: %d", client.Addr, modelID, resp.StatusCode)
 	}
 	if resp.StatusCode != 200 {
-		return StatusUnknown
+		return StatusUnknown, NewCheckError(CheckResponseError, fmt.Errorf("unexpected status code %d", resp.StatusCode))
 	}
 	buf := bytes.Buffer{}
 	_, err = buf.ReadFrom(resp.Body)
 	if err != nil {
 		Lerr("[%v] cannot read response for model %s, %v", client.Addr, modelID, err)
-		return StatusUnknown
+		return StatusUnknown, NewCheckError(CheckNetworkError, err)
 	}
 	decoder := json.NewDecoder(ioutil.NopCloser(bytes.NewReader(buf.Bytes())))
 	parsed := &flirt4FreeCheckResponse{}
@@ -65,9 +65,9 @@ func CheckModelFlirt4Free(client *Client, modelID string, headers [][2]string, d
 		if dbg {
 			Ldbg("response: %s", buf.String())
 		}
-		return StatusUnknown
+		return StatusUnknown, NewCheckError(CheckResponseError, err)
 	}
-	return flirt4FreeStatus(parsed.Status)
+	return checkedStatus(flirt4FreeStatus(parsed.Status), parsed.Status)
 }
 
 func flirt4FreeStatus(roomStatus string) StatusKind {
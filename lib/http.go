@@ -3,10 +3,14 @@ package lib
 import (
 	"bytes"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -23,24 +27,29 @@ func NoRedirect(_ *http.Request, _ []*http.Request) error { return http.ErrUseLa
 
 // HTTPClientWithTimeoutAndAddress returns HTTP client bound to specific IP address
 func HTTPClientWithTimeoutAndAddress(timeoutSeconds int, address string, cookies bool) *Client {
+	return HTTPClientWithProxy(timeoutSeconds, address, cookies, nil)
+}
+
+// maxProxyDialTimeout limits the time to connect to the proxy
+// so that the timeout of the client leaves time for the direct connection
+const maxProxyDialTimeout = 5 * time.Second
+
+// HTTPClientWithProxy returns HTTP client bound to specific IP address making requests through the proxy,
+// HTTP and SOCKS5 proxies are supported, requests fall back to direct connection if the proxy is unreachable
+func HTTPClientWithProxy(timeoutSeconds int, address string, cookies bool, proxy *url.URL) *Client {
 	addr := &net.TCPAddr{IP: net.ParseIP(address)}
+	timeout := time.Second * time.Duration(timeoutSeconds)
+	var transport http.RoundTripper = newTransport(timeout, timeout, addr, http.ProxyFromEnvironment)
+	if proxy != nil {
+		transport = &fallbackTransport{
+			proxied: newTransport(proxyDialTimeout(timeout), timeout, addr, http.ProxyURL(proxy)),
+			direct:  transport,
+		}
+	}
 	var client = &http.Client{
 		CheckRedirect: NoRedirect,
-		Timeout:       time.Second * time.Duration(timeoutSeconds),
-		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			DialContext: (&net.Dialer{
-				LocalAddr: addr,
-				Timeout:   time.Second * time.Duration(timeoutSeconds),
-				KeepAlive: 30 * time.Second,
-			}).DialContext,
-			ForceAttemptHTTP2:     true,
-			MaxIdleConns:          10,
-			IdleConnTimeout:       http.DefaultTransport.(*http.Transport).IdleConnTimeout,
-			TLSHandshakeTimeout:   time.Second * time.Duration(timeoutSeconds),
-			ExpectContinueTimeout: time.Duration(0),
-			TLSClientConfig:       &tls.Config{MinVersion: tls.VersionTLS12},
-		},
+		Timeout:       timeout,
+		Transport:     transport,
 	}
 	if cookies {
 		cookieJar, _ := cookiejar.New(nil)
@@ -49,6 +58,85 @@ func HTTPClientWithTimeoutAndAddress(timeoutSeconds int, address string, cookies
 	return &Client{Client: client, Addr: addr}
 }
 
+// proxyDialTimeout returns the time to connect to the proxy, a third of the client timeout at most
+func proxyDialTimeout(timeout time.Duration) time.Duration {
+	if timeout > 0 && timeout/3 < maxProxyDialTimeout {
+		return timeout / 3
+	}
+	return maxProxyDialTimeout
+}
+
+func newTransport(dialTimeout time.Duration, timeout time.Duration, addr *net.TCPAddr, proxy func(*http.Request) (*url.URL, error)) *http.Transport {
+	return &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			LocalAddr: addr,
+			Timeout:   dialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       http.DefaultTransport.(*http.Transport).IdleConnTimeout,
+		TLSHandshakeTimeout:   timeout,
+		ExpectContinueTimeout: time.Duration(0),
+		TLSClientConfig:       &tls.Config{MinVersion: tls.VersionTLS12},
+	}
+}
+
+// fallbackTransport makes requests through the proxy
+// and repeats requests without a body directly if the proxy is unreachable
+type fallbackTransport struct {
+	proxied http.RoundTripper
+	direct  http.RoundTripper
+}
+
+func (t *fallbackTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.proxied.RoundTrip(req)
+	if err == nil || !proxyUnreachable(err) || (req.Body != nil && req.Body != http.NoBody) {
+		return resp, err
+	}
+	Lerr("proxy is unreachable, falling back to direct connection, %v", err)
+	return t.direct.RoundTrip(req)
+}
+
+// proxyUnreachable returns true if the error occurred while connecting to the proxy
+func proxyUnreachable(err error) bool {
+	var opError *net.OpError
+	return errors.As(err, &opError) && (opError.Op == "proxyconnect" || strings.HasPrefix(opError.Op, "socks"))
+}
+
+// HeadersRotation provides HTTP headers with user agents picked round-robin
+type HeadersRotation struct {
+	headers    [][2]string
+	userAgents []string
+	next       uint32
+}
+
+// NewHeadersRotation returns headers rotation, headers are constant if no user agents are provided
+func NewHeadersRotation(headers [][2]string, userAgents []string) *HeadersRotation {
+	return &HeadersRotation{headers: headers, userAgents: userAgents}
+}
+
+// UserAgent returns the next user agent or an empty string if no user agents are provided
+func (r *HeadersRotation) UserAgent() string {
+	if len(r.userAgents) == 0 {
+		return ""
+	}
+	i := atomic.AddUint32(&r.next, 1) - 1
+	return r.userAgents[i%uint32(len(r.userAgents))]
+}
+
+// Headers returns the headers with the next user agent overriding the configured one
+func (r *HeadersRotation) Headers() [][2]string {
+	userAgent := r.UserAgent()
+	if userAgent == "" {
+		return r.headers
+	}
+	headers := make([][2]string, 0, len(r.headers)+1)
+	headers = append(headers, r.headers...)
+	return append(headers, [2]string{"User-Agent", userAgent})
+}
+
 func onlineQuery(
 	usersOnlineEndpoint string,
 	client *Client,
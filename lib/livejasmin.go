@@ -26,7 +26,7 @@ type liveJasminResponse struct {
 }
 
 // CheckModelLiveJasmin checks LiveJasmin model status
-func CheckModelLiveJasmin(client *Client, modelID string, headers [][2]string, dbg bool, config map[string]string) StatusKind {
+func CheckModelLiveJasmin(client *Client, modelID string, headers [][2]string, dbg bool, config map[string]string) (StatusKind, error) {
 	psID := config["ps_id"]
 	accessKey := config["access_key"]
 	url := fmt.Sprintf("https://pt.potawe.com/api/model/status?performerId=%s&psId=%s&accessKey=%s&legacyRedirect=1", modelID, psID, accessKey)
@@ -38,7 +38,7 @@ func CheckModelLiveJasmin(client *Client, modelID string, headers [][2]string, d
 	resp, err := client.Client.Do(req)
 	if err != nil {
 		Lerr("[%v] cannot send a query, %v", client.Addr, err)
-		return StatusUnknown
+		return StatusUnknown, NewCheckError(CheckNetworkError, err)
 	}
 	defer func() {
 		CheckErr(resp.Body.Close())
@@ -47,18 +47,18 @@ func CheckModelLiveJasmin(client *Client, modelID string, headers [][2]string, d
 		Ldbg("[%v] query status for %s: %d", client.Addr, modelID, resp.StatusCode)
 	}
 	if resp.StatusCode == 401 {
-		return StatusDenied
+		return StatusDenied, nil
 	}
 	if resp.StatusCode == 404 {
-		return StatusNotFound
+		return StatusNotFound, nil
 	}
 	buf := bytes.Buffer{}
 	_, err = buf.ReadFrom(resp.Body)
 	if err != nil {
 		Lerr("[%v] cannot read response for model %s, %v", client.Addr, modelID, err)
-		return StatusUnknown
+		return StatusUnknown, NewCheckError(CheckNetworkError, err)
 	}
-	return liveJasminStatus(buf.String())
+	return checkedStatus(liveJasminStatus(buf.String()), buf.String())
 }
 
 func liveJasminStatus(roomStatus string) StatusKind {
@@ -114,7 +114,7 @@ func LiveJasminOnlineAPI(
 	}
 	for _, m := range parsed.Data.Models {
 		modelID := strings.ToLower(m.PerformerID)
-		onlineModels[modelID] = OnlineModel{ModelID: modelID, Image: "https:" + m.ProfilePictureURL.Size896x503}
+		onlineModels[modelID] = OnlineModel{ModelID: modelID, OriginalID: m.PerformerID, Image: "https:" + m.ProfilePictureURL.Size896x503}
 	}
 	return
 }
@@ -8,15 +8,43 @@ import (
 // ModelIDRegexp is a regular expression to check model IDs
 var ModelIDRegexp = regexp.MustCompile(`^[a-z0-9\-_@]+$`)
 
+// CheckerKind represents a strategy of querying model statuses
+type CheckerKind int
+
+const (
+	// CheckerAPI queries all online models of the website at once
+	CheckerAPI CheckerKind = iota
+	// CheckerPolling queries the models one by one
+	CheckerPolling
+)
+
+func (c CheckerKind) String() string {
+	switch c {
+	case CheckerAPI:
+		return "api"
+	case CheckerPolling:
+		return "polling"
+	}
+	return "unknown"
+}
+
 // StatusRequest represents a request of model status
 type StatusRequest struct {
 	SpecialModels map[string]bool
+	Models        map[string]bool // the models queried one by one by a polling checker
 }
 
 // OnlineModel represents an update of model status
 type OnlineModel struct {
-	ModelID string
-	Image   string
+	ModelID       string
+	OriginalID    string
+	Image         string
+	Category      string
+	DisplayName   string
+	Idle          bool
+	Private       bool   // the model is in a private or group show
+	UnknownStatus string // a raw status the online API reported that does not map to a status kind
+	Unchecked     bool   // the status of the model could not be checked, the previous status is kept
 }
 
 // CanonicalModelID preprocesses model ID string to canonical form
@@ -0,0 +1,207 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+)
+
+// MyFreeCams video states
+const (
+	myFreeCamsPublic  = 0
+	myFreeCamsAway    = 2
+	myFreeCamsPrivate = 12
+	myFreeCamsGroup   = 13
+	myFreeCamsClub    = 14
+	myFreeCamsCamOff  = 90
+	myFreeCamsOffline = 127
+)
+
+type myFreeCamsSession struct {
+	VState  int `json:"vstate"`
+	CamServ int `json:"camserv"`
+}
+
+type myFreeCamsUserResponse struct {
+	Err    int `json:"err"`
+	Result struct {
+		User *struct {
+			ID       int                 `json:"id"`
+			Username string              `json:"username"`
+			Sessions []myFreeCamsSession `json:"sessions"`
+		} `json:"user"`
+	} `json:"result"`
+}
+
+// myFreeCamsOnlineResponse is the online models feed,
+// the first row of the data contains the names of the fields
+type myFreeCamsOnlineResponse struct {
+	Rdata [][]interface{} `json:"rdata"`
+}
+
+// CheckModelMyFreeCams checks MyFreeCams model status
+func CheckModelMyFreeCams(client *Client, modelID string, headers [][2]string, dbg bool, _ map[string]string) (StatusKind, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("https://api-edge.myfreecams.com/usernameLookup/%s", modelID), nil)
+	CheckErr(err)
+	for _, h := range headers {
+		req.Header.Set(h[0], h[1])
+	}
+	resp, err := client.Client.Do(req)
+	if err != nil {
+		Lerr("[%v] cannot send a query, %v", client.Addr, err)
+		return StatusUnknown, NewCheckError(CheckNetworkError, err)
+	}
+	defer func() {
+		CheckErr(resp.Body.Close())
+	}()
+	if dbg {
+		Ldbg("[%v] query status for %s: %d", client.Addr, modelID, resp.StatusCode)
+	}
+	if resp.StatusCode == 404 {
+		return StatusNotFound, nil
+	}
+	if resp.StatusCode != 200 {
+		return StatusUnknown, NewCheckError(CheckResponseError, fmt.Errorf("unexpected status code %d", resp.StatusCode))
+	}
+	buf := bytes.Buffer{}
+	_, err = buf.ReadFrom(resp.Body)
+	if err != nil {
+		Lerr("[%v] cannot read response for model %s, %v", client.Addr, modelID, err)
+		return StatusUnknown, NewCheckError(CheckNetworkError, err)
+	}
+	decoder := json.NewDecoder(ioutil.NopCloser(bytes.NewReader(buf.Bytes())))
+	parsed := &myFreeCamsUserResponse{}
+	err = decoder.Decode(parsed)
+	if err != nil {
+		Lerr("[%v] cannot parse response for model %s, %v", client.Addr, modelID, err)
+		if dbg {
+			Ldbg("response: %s", buf.String())
+		}
+		return StatusUnknown, NewCheckError(CheckResponseError, err)
+	}
+	if parsed.Err != 0 || parsed.Result.User == nil {
+		return StatusNotFound, nil
+	}
+	if len(parsed.Result.User.Sessions) == 0 {
+		return StatusOffline, nil
+	}
+	vstate := parsed.Result.User.Sessions[0].VState
+	return checkedStatus(myFreeCamsStatus(vstate), strconv.Itoa(vstate))
+}
+
+func myFreeCamsStatus(vstate int) StatusKind {
+	switch vstate {
+	case myFreeCamsPublic:
+		return StatusOnline
+	case myFreeCamsAway:
+		return StatusIdle
+	case myFreeCamsPrivate:
+		return StatusPrivate
+	case myFreeCamsGroup:
+		return StatusPrivate
+	case myFreeCamsClub:
+		return StatusPrivate
+	case myFreeCamsCamOff:
+		return StatusOffline
+	case myFreeCamsOffline:
+		return StatusOffline
+	}
+	Lerr("cannot parse video state %d", vstate)
+	return StatusUnknown
+}
+
+// myFreeCamsImage returns the snapshot URL of the model streaming on the camera server
+func myFreeCamsImage(uid int, camserv int) string {
+	if camserv < 500 {
+		return ""
+	}
+	return fmt.Sprintf("https://snap.mfcimg.com/snapimg/%d/320x240/mfc_%d", camserv-500, 100000000+uid)
+}
+
+// MyFreeCamsOnlineAPI returns MyFreeCams online models
+func MyFreeCamsOnlineAPI(
+	endpoint string,
+	client *Client,
+	headers [][2]string,
+	dbg bool,
+	_ map[string]string,
+) (
+	onlineModels map[string]OnlineModel,
+	err error,
+) {
+	onlineModels = map[string]OnlineModel{}
+	resp, buf, err := onlineQuery(endpoint, client, headers)
+	if err != nil {
+		return nil, fmt.Errorf("cannot send a query, %v", err)
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("query status, %d", resp.StatusCode)
+	}
+	decoder := json.NewDecoder(ioutil.NopCloser(bytes.NewReader(buf.Bytes())))
+	decoder.UseNumber()
+	var parsed myFreeCamsOnlineResponse
+	err = decoder.Decode(&parsed)
+	if err != nil {
+		if dbg {
+			Ldbg("response: %s", buf.String())
+		}
+		return nil, fmt.Errorf("cannot parse response, %v", err)
+	}
+	if len(parsed.Rdata) == 0 {
+		return nil, errors.New("no fields in response")
+	}
+	fields := map[string]int{}
+	for i, f := range parsed.Rdata[0] {
+		if name, ok := f.(string); ok {
+			fields[name] = i
+		}
+	}
+	for _, f := range []string{"nm", "uid", "vs", "camserv"} {
+		if _, ok := fields[f]; !ok {
+			return nil, fmt.Errorf("no field %s in response", f)
+		}
+	}
+	for _, row := range parsed.Rdata[1:] {
+		if len(row) != len(parsed.Rdata[0]) {
+			return nil, fmt.Errorf("unexpected row length %d", len(row))
+		}
+		name, _ := row[fields["nm"]].(string)
+		uid, errUID := myFreeCamsInt(row[fields["uid"]])
+		vstate, errVState := myFreeCamsInt(row[fields["vs"]])
+		camserv, errCamServ := myFreeCamsInt(row[fields["camserv"]])
+		if name == "" || errUID != nil || errVState != nil || errCamServ != nil {
+			return nil, fmt.Errorf("cannot parse row %v", row)
+		}
+		status := myFreeCamsStatus(vstate)
+		if status == StatusOffline {
+			continue
+		}
+		unknownStatus := ""
+		if status == StatusUnknown {
+			unknownStatus = strconv.Itoa(vstate)
+		}
+		modelID := CanonicalModelID(name)
+		onlineModels[modelID] = OnlineModel{
+			ModelID:       modelID,
+			OriginalID:    name,
+			Image:         myFreeCamsImage(uid, camserv),
+			Idle:          vstate == myFreeCamsAway,
+			Private:       status == StatusPrivate,
+			UnknownStatus: unknownStatus,
+		}
+	}
+	return
+}
+
+func myFreeCamsInt(x interface{}) (int, error) {
+	n, ok := x.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("not a number %v", x)
+	}
+	i, err := strconv.ParseInt(string(n), 10, 64)
+	return int(i), err
+}
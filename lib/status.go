@@ -10,6 +10,8 @@ const (
 	StatusOnline
 	StatusNotFound
 	StatusDenied
+	StatusIdle
+	StatusPrivate
 )
 
 func (s StatusKind) String() string {
@@ -22,6 +24,19 @@ func (s StatusKind) String() string {
 		return "not found"
 	case StatusDenied:
 		return "denied"
+	case StatusIdle:
+		return "idle"
+	case StatusPrivate:
+		return "private"
 	}
 	return "unknown"
 }
+
+// checkedStatus returns an error for an unknown status parsed from a response,
+// the error wraps the raw status so that it can be reported
+func checkedStatus(status StatusKind, raw string) (StatusKind, error) {
+	if status == StatusUnknown {
+		return status, NewCheckError(CheckResponseError, &UnknownStatusError{Raw: raw})
+	}
+	return status, nil
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"strings"
@@ -16,6 +17,7 @@ import (
 type stripchatModel struct {
 	Username    string `json:"username"`
 	SnapshotURL string `json:"snapshotUrl"`
+	Status      string `json:"status"`
 }
 
 type stripchatResponse struct {
@@ -27,14 +29,24 @@ var statusesOffline = map[string]bool{
 }
 
 var statusesOnline = map[string]bool{
+	"status-idle": true,
+}
+
+var statusesPrivate = map[string]bool{
 	"status-p2p":       true,
 	"status-private":   true,
 	"status-groupShow": true,
-	"status-idle":      true,
+}
+
+// privateShows are the statuses of the online API meaning a private or group show
+var privateShows = map[string]bool{
+	"p2p":       true,
+	"private":   true,
+	"groupShow": true,
 }
 
 // CheckModelStripchat checks Stripchat model status
-func CheckModelStripchat(client *Client, modelID string, headers [][2]string, dbg bool, _ map[string]string) StatusKind {
+func CheckModelStripchat(client *Client, modelID string, headers [][2]string, dbg bool, _ map[string]string) (StatusKind, error) {
 	ctx, cancel := chromedp.NewContext(context.Background(), chromedp.WithLogf(Ldbg))
 	defer cancel()
 
@@ -55,25 +67,25 @@ func CheckModelStripchat(client *Client, modelID string, headers [][2]string, db
 	)
 	if err != nil {
 		Lerr("[%v] cannot open a page for model %s, %v", client.Addr, modelID, err)
-		return StatusUnknown
+		return StatusUnknown, NewCheckError(CheckNetworkError, err)
 	}
 	if len(videoNode) > 0 {
 		if dbg {
 			Ldbg("video found")
 		}
-		return StatusOnline
+		return StatusOnline, nil
 	}
 	if len(notFoundNode) > 0 {
 		if dbg {
 			Ldbg(".not-found-error found")
 		}
-		return StatusNotFound
+		return StatusNotFound, nil
 	}
 	if len(disabledNode) > 0 {
 		if dbg {
 			Ldbg(".account-disabled-page found")
 		}
-		return StatusDenied
+		return StatusDenied, nil
 	}
 	if len(statusNode) > 0 {
 		classes := strings.Split(statusNode[0].AttributeValue("class"), " ")
@@ -82,19 +94,26 @@ func CheckModelStripchat(client *Client, modelID string, headers [][2]string, db
 				if dbg {
 					Ldbg("offline status found")
 				}
-				return StatusOffline
+				return StatusOffline, nil
 			}
 			if statusesOnline[c] {
 				if dbg {
 					Ldbg("online status found")
 				}
-				return StatusOnline
+				return StatusOnline, nil
+			}
+			if statusesPrivate[c] {
+				if dbg {
+					Ldbg("private status found")
+				}
+				return StatusPrivate, nil
 			}
 		}
 		Lerr("[%v] unknown status for model %s, %v", client.Addr, modelID, classes)
+		return StatusUnknown, NewCheckError(CheckResponseError, &UnknownStatusError{Raw: strings.Join(classes, " ")})
 	}
 	Lerr("[%v] unknown status for model %s", client.Addr, modelID)
-	return StatusUnknown
+	return StatusUnknown, NewCheckError(CheckResponseError, errors.New("unknown status"))
 }
 
 // StripchatOnlineAPI returns Stripchat online models
@@ -127,7 +146,7 @@ func StripchatOnlineAPI(
 	}
 	for _, m := range parsed.Models {
 		modelID := strings.ToLower(m.Username)
-		onlineModels[modelID] = OnlineModel{ModelID: modelID, Image: m.SnapshotURL}
+		onlineModels[modelID] = OnlineModel{ModelID: modelID, OriginalID: m.Username, Image: m.SnapshotURL, Private: privateShows[m.Status]}
 	}
 	return
 }
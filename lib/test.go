@@ -6,8 +6,8 @@ import (
 )
 
 // CheckModelTest mimics checker
-func CheckModelTest(client *Client, modelID string, headers [][2]string, dbg bool, _ map[string]string) StatusKind {
-	return StatusOnline
+func CheckModelTest(client *Client, modelID string, headers [][2]string, dbg bool, _ map[string]string) (StatusKind, error) {
+	return StatusOnline, nil
 }
 
 // TestOnlineAPI returns random online models
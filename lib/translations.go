@@ -78,6 +78,19 @@ type Translations struct {
 	UnknownCurrency             *Translation `yaml:"unknown_currency"`
 	BuyAd                       *Translation `yaml:"buy_ad"`
 	PaymentComplete             *Translation `yaml:"payment_complete"`
+	PaymentRevoked              *Translation `yaml:"payment_revoked"`
+	SyntaxPromo                 *Translation `yaml:"syntax_promo"`
+	PromoInvalid                *Translation `yaml:"promo_invalid"`
+	PromoAlreadyUsed            *Translation `yaml:"promo_already_used"`
+	PromoApplied                *Translation `yaml:"promo_applied"`
+	CardButton                  *Translation `yaml:"card_button"`
+	InvoiceTitle                *Translation `yaml:"invoice_title"`
+	InvoiceDescription          *Translation `yaml:"invoice_description"`
+	LimitChanged                *Translation `yaml:"limit_changed"`
+	PacketExpiring              *Translation `yaml:"packet_expiring"`
+	Receipt                     *Translation `yaml:"receipt"`
+	Transactions                *Translation `yaml:"transactions"`
+	SyntaxTransactions          *Translation `yaml:"syntax_transactions"`
 	MailReceived                *Translation `yaml:"mail_received"`
 	BuyButton                   *Translation `yaml:"buy_button"`
 	ReferralLink                *Translation `yaml:"referral_link"`
@@ -95,6 +108,72 @@ type Translations struct {
 	Settings                    *Translation `yaml:"settings"`
 	OK                          *Translation `yaml:"ok"`
 	TooManySubscriptionsForPics *Translation `yaml:"too_many_subscriptions_for_pics"`
+	ListByCategory              *Translation `yaml:"list_by_category"`
+	SyntaxVacation              *Translation `yaml:"syntax_vacation"`
+	Vacation                    *Translation `yaml:"vacation"`
+	SyntaxMute                  *Translation `yaml:"syntax_mute"`
+	Muted                       *Translation `yaml:"muted"`
+	Paused                      *Translation `yaml:"paused"`
+	SyntaxImageLimit            *Translation `yaml:"syntax_image_limit"`
+	SyntaxImageMode             *Translation `yaml:"syntax_image_mode"`
+	SyntaxNotify                *Translation `yaml:"syntax_notify"`
+	SyntaxRename                *Translation `yaml:"syntax_rename"`
+	SyntaxShare                 *Translation `yaml:"syntax_share"`
+	ShareLink                   *Translation `yaml:"share_link"`
+	ModelsAdded                 *Translation `yaml:"models_added"`
+	SyntaxImport                *Translation `yaml:"syntax_import"`
+	ImportFailed                *Translation `yaml:"import_failed"`
+	ImportResult                *Translation `yaml:"import_result"`
+	TooManyModels               *Translation `yaml:"too_many_models"`
+	SyntaxTag                   *Translation `yaml:"syntax_tag"`
+	NoTaggedModels              *Translation `yaml:"no_tagged_models"`
+	TagHeader                   *Translation `yaml:"tag_header"`
+	SyntaxQuietHours            *Translation `yaml:"syntax_quiet_hours"`
+	SyntaxTimezone              *Translation `yaml:"syntax_timezone"`
+	UnknownTimezone             *Translation `yaml:"unknown_timezone"`
+	SyntaxMinOnline             *Translation `yaml:"syntax_min_online"`
+	AvailableLanguages          *Translation `yaml:"available_languages"`
+	SyntaxSearch                *Translation `yaml:"syntax_search"`
+	SearchTooOften              *Translation `yaml:"search_too_often"`
+	NoSearchResults             *Translation `yaml:"no_search_results"`
+	SearchResults               *Translation `yaml:"search_results"`
+	StatusMe                    *Translation `yaml:"status_me"`
+	DisplayNameChanged          *Translation `yaml:"display_name_changed"`
+	HelpIndex                   *Translation `yaml:"help_index"`
+	HelpButton                  *Translation `yaml:"help_button"`
+	HelpSubscriptions           *Translation `yaml:"help_subscriptions"`
+	HelpSettings                *Translation `yaml:"help_settings"`
+	HelpPayments                *Translation `yaml:"help_payments"`
+	HelpReferrals               *Translation `yaml:"help_referrals"`
+	Idle                        *Translation `yaml:"idle"`
+	Private                     *Translation `yaml:"private"`
+	SyntaxWatch                 *Translation `yaml:"syntax_watch"`
+	Watching                    *Translation `yaml:"watching"`
+	AlreadyWatching             *Translation `yaml:"already_watching"`
+	TooManyWatches              *Translation `yaml:"too_many_watches"`
+	WatchFinished               *Translation `yaml:"watch_finished"`
+	DailyNotificationsReached   *Translation `yaml:"daily_notifications_reached"`
+	ReferralReset               *Translation `yaml:"referral_reset"`
+	FollowerAlreadyReferred     *Translation `yaml:"follower_already_referred"`
+	SyntaxRemind                *Translation `yaml:"syntax_remind"`
+	ReminderSet                 *Translation `yaml:"reminder_set"`
+	NoSchedule                  *Translation `yaml:"no_schedule"`
+	TooManyReminders            *Translation `yaml:"too_many_reminders"`
+	Reminder                    *Translation `yaml:"reminder"`
+	FeedbackCategories          *Translation `yaml:"feedback_categories"`
+	FeedbackCategoryButton      *Translation `yaml:"feedback_category_button"`
+	FeedbackPrompt              *Translation `yaml:"feedback_prompt"`
+	ModelNotFound               *Translation `yaml:"model_not_found"`
+	CheckFailed                 *Translation `yaml:"check_failed"`
+	SyntaxCheckList             *Translation `yaml:"syntax_check_list"`
+	CheckListTooLong            *Translation `yaml:"check_list_too_long"`
+	CheckListInProgress         *Translation `yaml:"check_list_in_progress"`
+	CheckListStarted            *Translation `yaml:"check_list_started"`
+	CheckList                   *Translation `yaml:"check_list"`
+	NothingToUndo               *Translation `yaml:"nothing_to_undo"`
+	ModelRestored               *Translation `yaml:"model_restored"`
+	SyntaxWasOnline             *Translation `yaml:"syntax_was_online"`
+	WasOnline                   *Translation `yaml:"was_online"`
 }
 
 // LoadEndpointTranslations loads translations for a specific endpoint
@@ -163,6 +242,31 @@ func noNils(x *Translations) error {
 	return nil
 }
 
+// Lookup returns the translation with the given key or nil if there is no such translation
+func Lookup(tr *Translations, key string) *Translation {
+	rv := reflect.ValueOf(tr).Elem()
+	for i := 0; i < rv.NumField(); i++ {
+		if rv.Type().Field(i).Tag.Get("yaml") == key {
+			translation, _ := rv.Field(i).Interface().(*Translation)
+			return translation
+		}
+	}
+	return nil
+}
+
+// MissingTemplates returns the keys of the translations having no parsed template
+func MissingTemplates(tr *Translations, tpl *template.Template) []string {
+	var missing []string
+	rv := reflect.ValueOf(tr).Elem()
+	for i := 0; i < rv.NumField(); i++ {
+		tag := rv.Type().Field(i).Tag.Get("yaml")
+		if tpl == nil || tpl.Lookup(tag) == nil {
+			missing = append(missing, tag)
+		}
+	}
+	return missing
+}
+
 func loadTranslations(path string) AllTranslations {
 	file, err := os.Open(filepath.Clean(path))
 	CheckErr(err)
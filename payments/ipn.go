@@ -66,3 +66,25 @@ func ParseIPN(r *http.Request, ipnSecret string, debug bool) (StatusKind, string
 
 	return StatusCreated, custom, nil
 }
+
+// TestIPNCustom is the transaction UUID of the test IPN
+const TestIPNCustom = "5f0b3c1e-8a2d-4e6b-9c7a-1d2e3f4a5b6c"
+
+// testIPNBody is a raw IPN body in the format CoinPayments sends for a completed payment
+const testIPNBody = "ipn_version=1.0&ipn_type=api&ipn_mode=hmac&ipn_id=6d3f0c8b2e7a4f1d9b5c&merchant=3a9f2c7e1b4d8a6f0e5c" +
+	"&status=100&status_text=Complete&txn_id=CPFA1B2C3D4E5F6G7H8I9J&currency1=USD&currency2=BTC" +
+	"&amount1=10&amount2=0.00091&fee=0.0000046&buyer_name=CoinPayments+API&email=buyer%40example.com" +
+	"&item_name=20+subscriptions&received_amount=0.00091&received_confirms=3" +
+	"&custom=" + TestIPNCustom
+
+// NewTestIPNRequest returns the raw IPN request of a completed payment signed with the IPN secret,
+// it is used to check IPN configuration
+func NewTestIPNRequest(ipnURL string, ipnSecret string) (*http.Request, error) {
+	r, err := http.NewRequest("POST", ipnURL, bytes.NewBufferString(testIPNBody))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("HMAC", calcHMAC(testIPNBody, ipnSecret))
+	return r, nil
+}
@@ -9,6 +9,7 @@ const (
 	StatusCreated
 	StatusCanceled
 	StatusFinished
+	StatusRevoked
 )
 
 func (s StatusKind) String() string {
@@ -19,6 +20,8 @@ func (s StatusKind) String() string {
 		return "canceled"
 	case StatusFinished:
 		return "finished"
+	case StatusRevoked:
+		return "revoked"
 	}
 	return "unknown"
 }